@@ -4,6 +4,8 @@ import (
 	"crypto/elliptic"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/XieGuochao/HippoCoin/host"
 	"gopkg.in/yaml.v2"
@@ -18,9 +20,22 @@ type HippoConfig struct {
 	BroadcastQueueLen int    `yaml:"broadcast-queue-len"`
 	MiningCapacity    int    `yaml:"mining-capacity"`
 	MiningInterval    int    `yaml:"mining-interval"`
+	DifficultyWindow  int    `yaml:"difficulty-window"`
 	MiningTTL         int    `yaml:"mining-ttl"`
 	Protocol          string `yaml:"protocol"`
 
+	ChainID     string `yaml:"chain-id"`
+	GenesisHash string `yaml:"genesis-hash"`
+
+	RewardInitial         uint64 `yaml:"reward-initial"`
+	RewardHalvingInterval int    `yaml:"reward-halving-interval"`
+	RewardMaxSupply       uint64 `yaml:"reward-max-supply"`
+
+	MedianTimeWindow       int `yaml:"median-time-window"`
+	BlockFutureDrift       int `yaml:"block-future-drift"`
+	TransactionFutureDrift int `yaml:"transaction-future-drift"`
+	TransactionMaxAge      int `yaml:"transaction-max-age"`
+
 	MaxNeighbors   int `yaml:"max-neighbors"`
 	UpdateTimeBase int `yaml:"update-time-base"`
 	UpdateTimeRand int `yaml:"update-time-rand"`
@@ -28,13 +43,37 @@ type HippoConfig struct {
 	RegisterAddress  string `yaml:"register-address"`
 	RegisterProtocol string `yaml:"register-protocol"`
 
+	SeedPeers       []string `yaml:"seed-peers"`
+	AddressBookPath string   `yaml:"address-book-path"`
+	BanDuration     int      `yaml:"ban-duration"`
+	NodeKeyPath     string   `yaml:"node-key-path"`
+	NodePinsPath    string   `yaml:"node-pins-path"`
+	KeyFile         string   `yaml:"key-file"`
+	KeyPasswordFile string   `yaml:"key-password-file"`
+	WalletPath      string   `yaml:"wallet-path"`
+
+	RPCTimeout        int     `yaml:"rpc-timeout"`
+	MaxInFlight       int     `yaml:"max-in-flight"`
+	HealthCheckPeriod int     `yaml:"health-check-period"`
+	MaxInbound        int     `yaml:"max-inbound"`
+	ExternalAddress   string  `yaml:"external-address"`
+	MaxMessageSize    int     `yaml:"max-message-size"`
+	MaxLevelSpan      int     `yaml:"max-level-span"`
+	MaxHashes         int     `yaml:"max-hashes"`
+	RateLimit         float64 `yaml:"rate-limit"`
+	RateBurst         int     `yaml:"rate-burst"`
+
 	DebugFileTemplate string `yaml:"debug-file-template"`
 	InfoFileTemplate  string `yaml:"info-file-template"`
 
+	UIHost string `yaml:"ui-host"`
 	UIPort string `yaml:"ui-port"`
 
 	LocalMode    bool `yaml:"local-mode"`
 	ListenerPort int  `yaml:"listener-port"`
+
+	StorageBackend string `yaml:"storage-backend"`
+	StoragePath    string `yaml:"storage-path"`
 }
 
 // Load ...
@@ -58,6 +97,40 @@ func (config *HippoConfig) Load(path string) *HippoConfig {
 		config.curve = elliptic.P224()
 	}
 
+	if config.StorageBackend == "" {
+		config.StorageBackend = "memory"
+	}
+	if config.StorageBackend == "file" && config.StoragePath == "" {
+		config.StoragePath = "./data/blocks.log"
+	}
+
+	if config.DifficultyWindow <= 0 {
+		config.DifficultyWindow = 10
+	}
+
+	if config.RewardInitial == 0 {
+		config.RewardInitial = host.DefaultRewardSchedule.Initial
+	}
+	if config.RewardHalvingInterval <= 0 {
+		config.RewardHalvingInterval = host.DefaultRewardSchedule.HalvingInterval
+	}
+	if config.RewardMaxSupply == 0 {
+		config.RewardMaxSupply = host.DefaultRewardSchedule.MaxSupply
+	}
+
+	if config.MedianTimeWindow <= 0 {
+		config.MedianTimeWindow = 11
+	}
+	if config.BlockFutureDrift <= 0 {
+		config.BlockFutureDrift = 120
+	}
+	if config.TransactionFutureDrift <= 0 {
+		config.TransactionFutureDrift = 120
+	}
+	if config.TransactionMaxAge <= 0 {
+		config.TransactionMaxAge = config.MiningTTL
+	}
+
 	if config.MiningThreads <= 1 {
 		config.miningFunction = new(host.SingleMiningFunction)
 	} else {
@@ -65,3 +138,23 @@ func (config *HippoConfig) Load(path string) *HippoConfig {
 	}
 	return config
 }
+
+// UIPassword ...
+// The password of the web client: the HIPPO_UI_PASSWORD environment variable.
+func (config *HippoConfig) UIPassword() string {
+	return os.Getenv("HIPPO_UI_PASSWORD")
+}
+
+// KeyPassword ...
+// The password of key-file and of the wallet: the first line of key-password-file,
+// or the HIPPO_KEY_PASSWORD environment variable.
+func (config *HippoConfig) KeyPassword() (string, error) {
+	if config.KeyPasswordFile == "" {
+		return os.Getenv("HIPPO_KEY_PASSWORD"), nil
+	}
+	data, err := ioutil.ReadFile(config.KeyPasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r"), nil
+}
@@ -0,0 +1,237 @@
+package host
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAddressBookCapacity ...
+	DefaultAddressBookCapacity = 1000
+	// MaxAddressFailures ...
+	// An address failing this many times in a row is forgotten.
+	MaxAddressFailures = 5
+)
+
+// AddressEntry ...
+// Source tells where the address comes from, e.g. "seed", "register" or "peer".
+type AddressEntry struct {
+	Address  string `json:"address"`
+	Source   string `json:"source"`
+	LastSeen int64  `json:"lastSeen"`
+	Failures int    `json:"failures"`
+}
+
+// AddressBook ...
+// Known peer addresses, kept across restarts.
+// Steps:
+// 1. New(path, capacity)  An empty path keeps the book in memory.
+// 2. Load()
+// 3. Add(address, source)  MarkGood(address)  MarkFailed(address)
+// 4. Save()
+type AddressBook interface {
+	New(path string, capacity int)
+	Load() int
+	Save() error
+
+	Add(address string, source string) bool
+	MarkGood(address string)
+	MarkFailed(address string)
+	Remove(address string)
+	Addresses(n int) []string
+	Entries() []AddressEntry
+	Len() int
+}
+
+// HippoAddressBook ...
+// HippoAddressBook is thread-safe and saved as a JSON file.
+// When it is full, the entry with the most failures, then the oldest one, is evicted.
+type HippoAddressBook struct {
+	lock     sync.Mutex
+	path     string
+	capacity int
+	entries  map[string]*AddressEntry
+}
+
+// New ...
+func (book *HippoAddressBook) New(path string, capacity int) {
+	book.path, book.capacity = path, capacity
+	book.entries = make(map[string]*AddressEntry)
+}
+
+// Load ...
+// Return the number of addresses loaded.
+func (book *HippoAddressBook) Load() int {
+	if book.path == "" {
+		return 0
+	}
+	data, err := ioutil.ReadFile(book.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			infoLogger.Error("address book: load:", err)
+		}
+		return 0
+	}
+	var entries []AddressEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		infoLogger.Error("address book: cannot decode:", err)
+		return 0
+	}
+
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	for i := range entries {
+		entry := entries[i]
+		if entry.Address == "" || entry.Failures >= MaxAddressFailures {
+			continue
+		}
+		book.entries[entry.Address] = &entry
+	}
+	for len(book.entries) > book.capacity && book.capacity > 0 {
+		book.evictUnsafe()
+	}
+	infoLogger.Info("address book: load", len(book.entries), "addresses")
+	return len(book.entries)
+}
+
+// Save ...
+func (book *HippoAddressBook) Save() error {
+	if book.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(book.Entries())
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(book.path); dir != "" {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	// Write to a temporary file first so that a crash never leaves a broken book.
+	tmp := book.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, book.path)
+}
+
+// Add ...
+// Return true if address is new. The source of a known address is kept.
+func (book *HippoAddressBook) Add(address string, source string) bool {
+	if address == "" {
+		return false
+	}
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	if _, has := book.entries[address]; has {
+		return false
+	}
+	for len(book.entries) >= book.capacity && book.capacity > 0 {
+		book.evictUnsafe()
+	}
+	if book.capacity <= 0 {
+		return false
+	}
+	book.entries[address] = &AddressEntry{
+		Address: address,
+		Source:  source,
+	}
+	debugLogger.Debug("address book: add", address, "from", source)
+	return true
+}
+
+// MarkGood ...
+// Called after a successful handshake.
+func (book *HippoAddressBook) MarkGood(address string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	if entry, has := book.entries[address]; has {
+		entry.LastSeen = time.Now().Unix()
+		entry.Failures = 0
+	}
+}
+
+// MarkFailed ...
+func (book *HippoAddressBook) MarkFailed(address string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	entry, has := book.entries[address]
+	if !has {
+		return
+	}
+	entry.Failures++
+	if entry.Failures >= MaxAddressFailures {
+		infoLogger.Warn("address book: forget", address)
+		delete(book.entries, address)
+	}
+}
+
+// Remove ...
+func (book *HippoAddressBook) Remove(address string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	delete(book.entries, address)
+}
+
+// Addresses ...
+// Return at most n addresses, the recently seen ones with fewer failures first.
+func (book *HippoAddressBook) Addresses(n int) []string {
+	entries := book.Entries()
+	if n > len(entries) || n < 0 {
+		n = len(entries)
+	}
+	addresses := make([]string, 0, n)
+	for _, entry := range entries[:n] {
+		addresses = append(addresses, entry.Address)
+	}
+	return addresses
+}
+
+// Entries ...
+// Sorted by failures, then by the last seen time from new to old.
+func (book *HippoAddressBook) Entries() []AddressEntry {
+	book.lock.Lock()
+	entries := make([]AddressEntry, 0, len(book.entries))
+	for _, entry := range book.entries {
+		entries = append(entries, *entry)
+	}
+	book.lock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Failures != entries[j].Failures {
+			return entries[i].Failures < entries[j].Failures
+		}
+		if entries[i].LastSeen != entries[j].LastSeen {
+			return entries[i].LastSeen > entries[j].LastSeen
+		}
+		return entries[i].Address < entries[j].Address
+	})
+	return entries
+}
+
+// Len ...
+func (book *HippoAddressBook) Len() int {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	return len(book.entries)
+}
+
+func (book *HippoAddressBook) evictUnsafe() {
+	var worst *AddressEntry
+	for _, entry := range book.entries {
+		if worst == nil || entry.Failures > worst.Failures ||
+			(entry.Failures == worst.Failures && entry.LastSeen < worst.LastSeen) ||
+			(entry.Failures == worst.Failures && entry.LastSeen == worst.LastSeen &&
+				entry.Address < worst.Address) {
+			worst = entry
+		}
+	}
+	debugLogger.Debug("address book: full, evict", worst.Address)
+	delete(book.entries, worst.Address)
+}
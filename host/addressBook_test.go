@@ -0,0 +1,52 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddressBook(t *testing.T) {
+	initTest(1)
+	dir, err := ioutil.TempDir("", "hippo-address-book")
+	assertT(err == nil, t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers.json")
+
+	book := new(HippoAddressBook)
+	book.New(path, 3)
+	assertT(book.Add("a:1", "seed") && book.Add("b:1", "peer"), t)
+	assertT(!book.Add("a:1", "peer") && !book.Add("", "peer"), t)
+	book.MarkGood("b:1")
+	book.MarkFailed("a:1")
+	addresses := book.Addresses(2)
+	assertT(len(addresses) == 2 && addresses[0] == "b:1" && addresses[1] == "a:1", t)
+
+	// The one with the most failures is evicted when the book is full.
+	assertT(book.Add("c:1", "peer") && book.Add("d:1", "peer"), t)
+	assertT(book.Len() == 3, t)
+	for _, entry := range book.Entries() {
+		assertT(entry.Address != "a:1", t)
+	}
+
+	// Forget an address after too many failures.
+	for i := 0; i < MaxAddressFailures; i++ {
+		book.MarkFailed("c:1")
+	}
+	assertT(book.Len() == 2, t)
+
+	assertT(book.Save() == nil, t)
+	reloaded := new(HippoAddressBook)
+	reloaded.New(path, 3)
+	assertT(reloaded.Load() == 2, t)
+	entries := reloaded.Entries()
+	assertT(entries[0].Address == "b:1" && entries[0].LastSeen > 0, t)
+	assertT(entries[1].Address == "d:1" && entries[1].Source == "peer", t)
+}
+
+func TestSeedDiscovery(t *testing.T) {
+	d := &SeedDiscovery{Seeds: []string{"a:1", "self:1", "b:1"}}
+	addresses := d.Discover("self:1", 10)
+	assertT(len(addresses) == 2 && addresses[0] == "a:1" && addresses[1] == "b:1", t)
+}
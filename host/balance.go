@@ -16,17 +16,25 @@ type Balance interface {
 	AllBalance() map[string]uint64
 	Update(address string, change int64) (uint64, bool)
 	UpdateUnsafe(address string, change int64) (uint64, bool)
+
+	GetNonce(address string) uint64
+	GetNonceUnsafe(address string) uint64
+	UpdateNonceUnsafe(address string, change int64)
 }
 
 // HippoBalance ...
 type HippoBalance struct {
 	lock    sync.Mutex
 	balance map[string]uint64
+
+	// nonces: the number of transactions sent by each address.
+	nonces map[string]uint64
 }
 
 // New ...
 func (b *HippoBalance) New() {
 	b.balance = make(map[string]uint64)
+	b.nonces = make(map[string]uint64)
 }
 
 // Lock ...
@@ -99,10 +107,37 @@ func (b *HippoBalance) UpdateUnsafe(address string, change int64) (uint64, bool)
 		value = 0
 		b.balance[address] = 0
 	}
-	if int64(value)+change > 0 {
+	if int64(value)+change >= 0 {
 		value = uint64(int64(value) + change)
 		b.balance[address] = value
 		return value, true
 	}
 	return value, false
 }
+
+// GetNonce ...
+// The nonce of the next transaction sent by address.
+func (b *HippoBalance) GetNonce(address string) uint64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.GetNonceUnsafe(address)
+}
+
+// GetNonceUnsafe ...
+func (b *HippoBalance) GetNonceUnsafe(address string) uint64 {
+	return b.nonces[address]
+}
+
+// UpdateNonceUnsafe ...
+func (b *HippoBalance) UpdateNonceUnsafe(address string, change int64) {
+	value := int64(b.nonces[address]) + change
+	if value < 0 {
+		infoLogger.Error("balance: nonce underflow:", address)
+		value = 0
+	}
+	if value == 0 {
+		delete(b.nonces, address)
+	} else {
+		b.nonces[address] = uint64(value)
+	}
+}
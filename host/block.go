@@ -4,6 +4,8 @@ import (
 	"crypto/elliptic"
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"time"
 )
 
@@ -18,6 +20,10 @@ type Block interface {
 		Level int, balance Balance, curve elliptic.Curve)
 	Digest() string
 	DigestSignature() string
+	TransactionRoot() string
+	ComputeTransactionRoot() string
+	GetHeader() BlockHeader
+	HeaderBytes() []byte
 	HashBytes() []byte
 	Hash() string
 	HashSignatureBytes() []byte
@@ -39,11 +45,16 @@ type Block interface {
 	Signature() string
 	CheckSignature() bool
 	CheckTransactions() bool
+	CheckStateUnsafe(balance Balance) bool
+	CheckTransactionRoot() bool
+	CheckCoinbase(fees uint64) bool
 	CheckNonce() bool
 	Check() bool
 	GetLevel() int
 	GetBalanceChange() map[string]int64
+	GetNonceChange() map[string]int64
 	GetTimestamp() int64
+	SetTimestamp(timestamp int64)
 	GetMiner() string
 	GetNonce() uint32
 
@@ -56,13 +67,14 @@ type Block interface {
 
 // HippoBlock ...
 type HippoBlock struct {
-	transactions []Transaction
-	PreviousHash []byte `json:"previousHash"`
-	NumBytes     uint   `json:"numBytes"`
-	Nonce        uint32 `json:"nonce"`
-	hashFunction HashFunction
-	Level        int   `json:"level"`
-	Timestamp    int64 `json:"timestamp"`
+	transactions     []Transaction
+	TransactionsRoot string `json:"transactionRoot"`
+	PreviousHash     []byte `json:"previousHash"`
+	NumBytes         uint   `json:"numBytes"`
+	Nonce            uint32 `json:"nonce"`
+	hashFunction     HashFunction
+	Level            int   `json:"level"`
+	Timestamp        int64 `json:"timestamp"`
 
 	// miner
 	MinerAddress   string `json:"minerAddress"`
@@ -79,22 +91,50 @@ func (b *HippoBlock) New(previousHash []byte, numBytes uint,
 	b.PreviousHash, b.NumBytes, b.hashFunction, b.Level, b.balance, b.curve =
 		previousHash, numBytes, hashFunction, Level, balance, curve
 	b.Timestamp = time.Now().Unix()
+	b.TransactionsRoot = b.ComputeTransactionRoot()
 }
 
 // Digest ...
-func (b *HippoBlock) Digest() string {
-	d := ""
-	d += fmt.Sprintf("%d|%d|", b.Timestamp, b.Level)
-	for _, t := range b.transactions {
-		d += "|" + t.HashSignatures()
-	}
-	d += "|"
-	return d
-}
+// The digest commits to the parent hash, the transaction root, the timestamp,
+// the difficulty, the level and the miner.
+func (b *HippoBlock) Digest() string { return b.GetHeader().Digest() }
 
 // DigestSignature ...
 func (b *HippoBlock) DigestSignature() string { return b.Digest() + b.MinerSignature }
 
+// TransactionRoot ...
+// The Merkle root of the transactions stored in the header.
+func (b *HippoBlock) TransactionRoot() string { return b.TransactionsRoot }
+
+// ComputeTransactionRoot ...
+// The Merkle root over HashSignatures of all transactions.
+func (b *HippoBlock) ComputeTransactionRoot() string {
+	leaves := make([][]byte, len(b.transactions))
+	for i, t := range b.transactions {
+		leaves[i] = t.HashSignaturesBytes()
+	}
+	return ByteToHexString(MerkleRoot(leaves, b.hashFunction))
+}
+
+// GetHeader ...
+func (b *HippoBlock) GetHeader() BlockHeader {
+	return BlockHeader{
+		PreviousHash:    b.PreviousHash,
+		TransactionRoot: b.TransactionsRoot,
+		NumBytes:        b.NumBytes,
+		Nonce:           b.Nonce,
+		Level:           b.Level,
+		Timestamp:       b.Timestamp,
+		MinerAddress:    b.MinerAddress,
+		MinerSignature:  b.MinerSignature,
+	}
+}
+
+// HeaderBytes ...
+// The header to mine, i.e. the digest with the miner signature.
+// A nonce is only valid for this exact header.
+func (b *HippoBlock) HeaderBytes() []byte { return b.GetHeader().HeaderBytes(b.hashFunction) }
+
 // HashBytes ...
 func (b *HippoBlock) HashBytes() []byte {
 	return b.hashFunction([]byte(b.Digest()))
@@ -126,8 +166,10 @@ func (b *HippoBlock) ParentHash() string {
 }
 
 // SetTransactions ...
+// It also updates the transaction root.
 func (b *HippoBlock) SetTransactions(tr []Transaction) {
 	b.transactions = tr
+	b.TransactionsRoot = b.ComputeTransactionRoot()
 }
 
 // GetTransactions ...
@@ -181,6 +223,7 @@ func (b *HippoBlock) CloneConstants() (block Block) {
 // CopyVariables ...
 func (b *HippoBlock) CopyVariables(newBlock Block) {
 	b.transactions = newBlock.GetTransactions()
+	b.TransactionsRoot = newBlock.TransactionRoot()
 	b.Level = newBlock.GetLevel()
 	b.MinerAddress = newBlock.GetMiner()
 	b.MinerSignature = newBlock.Signature()
@@ -191,10 +234,11 @@ func (b *HippoBlock) CopyVariables(newBlock Block) {
 }
 
 // Sign ...
+// The miner address is part of the digest, so it is set before signing.
 func (b *HippoBlock) Sign(key Key) {
+	b.MinerAddress = key.ToAddress()
 	if sig, err := b.generateSignature(key); err == nil {
 		b.MinerSignature = sig
-		b.MinerAddress = key.ToAddress()
 	}
 }
 
@@ -230,12 +274,124 @@ func (b *HippoBlock) CheckSignature() bool {
 }
 
 // CheckTransactions ...
+// The fees, the signatures and the coinbase. Balances and nonces depend on the
+// branch of the block; see CheckStateUnsafe.
 func (b *HippoBlock) CheckTransactions() bool {
-	for _, t := range b.transactions {
-		if !t.Check(b.balance) {
+	if len(b.transactions) == 0 {
+		infoLogger.Error("block has no coinbase:", b.Hash())
+		return false
+	}
+	fees := make([]uint64, 0, len(b.transactions)-1)
+	for _, t := range b.transactions[1:] {
+		if t.IsCoinbase() || !t.CheckWithoutBalance() {
 			infoLogger.Error("transaction check failed:", b.Hash())
 			return false
 		}
+		fees = append(fees, t.GetFee())
+	}
+	totalFee, ok := sumAmounts(fees)
+	if !ok {
+		infoLogger.Error("block fees overflow:", b.Hash())
+		return false
+	}
+	return b.CheckCoinbase(totalFee)
+}
+
+// CheckStateUnsafe ...
+// Check the transactions in order against balance, the state after the parent block.
+// A sender may have several transactions in one block with increasing nonces,
+// and may spend what it received earlier in the block, but not the coinbase.
+// Make sure you manually lock the balance first.
+func (b *HippoBlock) CheckStateUnsafe(balance Balance) bool {
+	var (
+		change  = make(map[string]int64)
+		pending = make(map[string]uint64)
+	)
+	for i, t := range b.transactions {
+		if i == 0 {
+			continue
+		}
+		// The amounts fit in the int64 balance changes.
+		if !t.CheckFee() {
+			infoLogger.Error("transaction amount check failed:", t.Hash(), b.Hash())
+			return false
+		}
+		senders, amounts := t.GetSender()
+		nonces := t.GetNonces()
+		if len(nonces) != len(senders) {
+			infoLogger.Error("transaction nonces missing:", t.Hash(), b.Hash())
+			return false
+		}
+		seen := make(map[string]bool)
+		for j, address := range senders {
+			if seen[address] {
+				infoLogger.Error("transaction with a duplicated sender:", t.Hash(), b.Hash())
+				return false
+			}
+			seen[address] = true
+			if nonces[j] != balance.GetNonceUnsafe(address)+pending[address] {
+				infoLogger.Error("transaction nonce check failed:", t.Hash(), b.Hash())
+				return false
+			}
+			available, ok := applyChange(balance.GetUnsafe(address), change[address])
+			if amounts[j] > math.MaxInt64 || !ok || available < amounts[j] {
+				infoLogger.Error("transaction balance check failed:", t.Hash(), b.Hash())
+				return false
+			}
+		}
+		for _, address := range senders {
+			pending[address]++
+		}
+		for address, value := range t.GetBalanceChange() {
+			if address != "fee" {
+				change[address] += value
+			}
+		}
+	}
+	return true
+}
+
+// applyChange ...
+// The balance after a change, false if it goes below zero or overflows.
+func applyChange(balance uint64, change int64) (uint64, bool) {
+	if change < 0 {
+		debit := uint64(-(change + 1)) + 1
+		return balance - debit, debit <= balance
+	}
+	return balance + uint64(change), balance <= math.MaxUint64-uint64(change)
+}
+
+// CheckCoinbase ...
+// The first transaction should be a coinbase paying the miner
+// no more than the reward and the fees.
+func (b *HippoBlock) CheckCoinbase(fees uint64) bool {
+	coinbase := b.transactions[0]
+	receivers, amounts := coinbase.GetReceiver()
+	limit, ok := sumAmounts([]uint64{Reward(b), fees})
+	switch {
+	case !coinbase.IsCoinbase():
+		infoLogger.Error("block has no coinbase:", b.Hash())
+	case len(receivers) != 1 || len(amounts) != 1 || receivers[0] != b.MinerAddress:
+		infoLogger.Error("coinbase does not pay the miner:", b.Hash())
+	case coinbase.GetLevel() != b.Level:
+		infoLogger.Error("coinbase level mismatched:", b.Hash())
+	case !ok:
+		infoLogger.Errorf("coinbase limit overflows, reward %d and fees %d: %s",
+			Reward(b), fees, b.Hash())
+	case amounts[0] > limit:
+		infoLogger.Errorf("coinbase overpays %d, reward %d and fees %d: %s",
+			amounts[0], Reward(b), fees, b.Hash())
+	default:
+		return true
+	}
+	return false
+}
+
+// CheckTransactionRoot ...
+func (b *HippoBlock) CheckTransactionRoot() bool {
+	if b.TransactionsRoot != b.ComputeTransactionRoot() {
+		infoLogger.Error("transaction root check failed:", b.Hash())
+		return false
 	}
 	return true
 }
@@ -245,57 +401,104 @@ func (b *HippoBlock) CheckNonce() bool {
 	var (
 		result bool
 	)
-	if result = checkNonce(b.HashSignatureBytes(), b.Nonce, b.NumBytes, b.hashFunction); !result {
+	if result = checkNonce(b.HeaderBytes(), b.Nonce, b.NumBytes, b.hashFunction); !result {
 		infoLogger.Error("nonce check failed:", b.Hash())
 	}
-	checkNonceShow(b.HashSignatureBytes(), b.Nonce, b.NumBytes, b.hashFunction)
+	checkNonceShow(b.HeaderBytes(), b.Nonce, b.NumBytes, b.hashFunction)
 	return result
 }
 
 // Check ...
 func (b *HippoBlock) Check() bool {
-	return b.CheckSignature() && b.CheckTransactions() && b.CheckNonce()
+	return b.CheckTransactionRoot() && b.CheckSignature() &&
+		b.CheckTransactions() && b.CheckNonce()
 }
 
 // GetLevel ...
 func (b *HippoBlock) GetLevel() int { return b.Level }
 
 // GetBalanceChange ...
+// The reward and the fees are paid by the coinbase transaction.
 func (b *HippoBlock) GetBalanceChange() map[string]int64 {
 	balanceChange := make(map[string]int64)
 	for _, tr := range b.transactions {
 		for k, v := range tr.GetBalanceChange() {
 			if k == "fee" {
-				k = b.MinerAddress
-			}
-			if _, has := balanceChange[k]; !has {
-				balanceChange[k] = v
-			} else {
-				balanceChange[k] += v
+				continue
 			}
+			balanceChange[k] += v
 		}
 	}
-	if _, has := balanceChange[b.MinerAddress]; !has {
-		balanceChange[b.MinerAddress] = Reward(b)
-	} else {
-		balanceChange[b.MinerAddress] += Reward(b)
-	}
 	return balanceChange
 }
 
+// GetNonceChange ...
+// The number of transactions sent by each address in this block.
+func (b *HippoBlock) GetNonceChange() map[string]int64 {
+	nonceChange := make(map[string]int64)
+	for _, tr := range b.transactions {
+		senders, _ := tr.GetSender()
+		for _, address := range senders {
+			nonceChange[address]++
+		}
+	}
+	return nonceChange
+}
+
 // GetTimestamp ...
 func (b *HippoBlock) GetTimestamp() int64 { return b.Timestamp }
 
+// SetTimestamp ...
+// Set it before signing.
+func (b *HippoBlock) SetTimestamp(timestamp int64) { b.Timestamp = timestamp }
+
 // GetMiner ...
 func (b *HippoBlock) GetMiner() string { return b.MinerAddress }
 
 // =============================================================
 
+// BlockHeader ...
+// A block without transactions. Light clients follow the chain with headers only.
+type BlockHeader struct {
+	PreviousHash    []byte `json:"previousHash"`
+	TransactionRoot string `json:"transactionRoot"`
+	NumBytes        uint   `json:"numBytes"`
+	Nonce           uint32 `json:"nonce"`
+	Level           int    `json:"level"`
+	Timestamp       int64  `json:"timestamp"`
+	MinerAddress    string `json:"minerAddress"`
+	MinerSignature  string `json:"minerSignature"`
+}
+
+// Digest ...
+func (h BlockHeader) Digest() string {
+	return fmt.Sprintf("%s|%s|%d|%d|%d|%s|", ByteToHexString(h.PreviousHash),
+		h.TransactionRoot, h.Timestamp, h.NumBytes, h.Level, h.MinerAddress)
+}
+
+// Hash ...
+// The same as the hash of the block.
+func (h BlockHeader) Hash(hashFunction HashFunction) string {
+	return ByteToHexString(hashFunction([]byte(h.Digest())))
+}
+
+// HeaderBytes ...
+func (h BlockHeader) HeaderBytes(hashFunction HashFunction) []byte {
+	return hashFunction([]byte(h.Digest() + h.MinerSignature))
+}
+
+// CheckNonce ...
+func (h BlockHeader) CheckNonce(hashFunction HashFunction) bool {
+	return checkNonce(h.HeaderBytes(hashFunction), h.Nonce, h.NumBytes, hashFunction)
+}
+
 // CreateGenesisBlock ...
 func CreateGenesisBlock(hashFunction HashFunction,
 	curve elliptic.Curve, key Key) HippoBlock {
 	var block HippoBlock
 	block.New([]byte{}, 235, hashFunction, 0, nil, curve)
+	block.SetTransactions([]Transaction{CreateCoinbaseTransaction(hashFunction, curve,
+		key.ToAddress(), Reward(&block), 0)})
 	block.Sign(key)
 	return block
 }
@@ -341,6 +544,14 @@ func (b *HippoBlock) Encode() []byte {
 func DecodeBlock(bytes []byte, templateBlock Block) Block {
 	var b Block
 
+	if IsBinary(bytes) {
+		b, err := decodeBlockBinary(bytes, templateBlock)
+		if err != nil {
+			infoLogger.Error("decode block error:", err)
+			return nil
+		}
+		return b
+	}
 	b = templateBlock.CloneConstants()
 
 	var be BlockEncoding
@@ -396,8 +607,110 @@ func BasicDifficulty(block Block, storage Storage,
 	return block.GetNumBytes()
 }
 
+// HeaderDifficultyFunc ...
+// The difficulty rule on headers, for light clients and headers-first sync.
+// Return the NumBytes of a child of parent, or false if an ancestor it needs
+// is not found by ancestor.
+type HeaderDifficultyFunc func(parent BlockHeader,
+	ancestor func(hashKey string) (BlockHeader, bool), baseInterval int64) (uint, bool)
+
+// NewWindowDifficulty ...
+// Retarget every window blocks by the time the last window blocks took.
+// prevBlock is the parent of the block to mine.
+// The number of bytes moves by at most 2 (4x the work) at each retarget,
+// larger if blocks were too slow and smaller if too fast.
+func NewWindowDifficulty(window int) DifficultyFunc {
+	headerDifficulty := NewWindowHeaderDifficulty(window)
+	return func(prevBlock Block, storage Storage, baseInterval int64) uint {
+		numBytes, ok := headerDifficulty(prevBlock.GetHeader(), storageAncestor(storage), baseInterval)
+		if !ok {
+			infoLogger.Error("difficulty: missing ancestor of", prevBlock.Hash())
+			return prevBlock.GetNumBytes()
+		}
+		return numBytes
+	}
+}
+
+// NewWindowHeaderDifficulty ...
+// The rule of NewWindowDifficulty on headers.
+func NewWindowHeaderDifficulty(window int) HeaderDifficultyFunc {
+	if window <= 0 {
+		window = 1
+	}
+	return func(parent BlockHeader, ancestor func(hashKey string) (BlockHeader, bool),
+		baseInterval int64) (uint, bool) {
+		numBytes := parent.NumBytes
+		if (parent.Level+1)%window != 0 {
+			return numBytes, true
+		}
+
+		first := parent
+		for i := 0; i < window && first.Level > 0; i++ {
+			var has bool
+			if first, has = ancestor(ByteToHexString(first.PreviousHash)); !has {
+				return numBytes, false
+			}
+		}
+		intervals := int64(parent.Level - first.Level)
+		if intervals == 0 {
+			return numBytes, true
+		}
+
+		actual := parent.Timestamp - first.Timestamp
+		expected := intervals * baseInterval
+		if actual < 1 {
+			actual = 1
+		}
+		delta := 0
+		for ; actual >= 2*expected && delta < 2; actual /= 2 {
+			delta++
+		}
+		for ; 2*actual <= expected && delta > -2; actual *= 2 {
+			delta--
+		}
+		debugLogger.Debug("difficulty: retarget", parent.Level+1, "delta:", delta)
+
+		switch {
+		case delta > 0 && numBytes+uint(delta) > 255:
+			return 255, true
+		case delta < 0 && numBytes <= uint(-delta):
+			return 1, true
+		}
+		return uint(int(numBytes) + delta), true
+	}
+}
+
+// storageAncestor ...
+// Find the headers of stored blocks.
+func storageAncestor(storage Storage) func(hashKey string) (BlockHeader, bool) {
+	return func(hashKey string) (BlockHeader, bool) {
+		block, has := storage.Get(hashKey)
+		if !has {
+			return BlockHeader{}, false
+		}
+		return block.GetHeader(), true
+	}
+}
+
+// BlockWork ...
+// The expected number of hashes to mine a block of numBytes,
+// which is 2^(257-numBytes) since a valid hash has fewer than numBytes significant bits.
+func BlockWork(numBytes uint) *big.Int {
+	if numBytes >= 257 {
+		return big.NewInt(1)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), 257-numBytes)
+}
+
 // StaticDifficulty ...
 func StaticDifficulty(block Block, storage Storage,
 	baseInterval int64) uint {
 	return block.GetNumBytes()
 }
+
+// StaticHeaderDifficulty ...
+// The rule of StaticDifficulty on headers.
+func StaticHeaderDifficulty(parent BlockHeader,
+	ancestor func(hashKey string) (BlockHeader, bool), baseInterval int64) (uint, bool) {
+	return parent.NumBytes, true
+}
@@ -2,6 +2,7 @@ package host
 
 import (
 	"fmt"
+	"math"
 	"testing"
 )
 
@@ -105,7 +106,7 @@ func TestJsonBlock(t *testing.T) {
 	rb.Data = bytes
 
 	rb.Decode(&bb2)
-	debugLogger.Debug(bb2.block, bb2.block.GetTransactions(), bb2.Level, bb2.Addresses)
+	debugLogger.Debug(bb2.block, bb2.block.GetTransactions(), bb2.Level)
 }
 
 func TestEncodeBlock(t *testing.T) {
@@ -169,3 +170,164 @@ func TestEncodeBlock(t *testing.T) {
 	infoLogger.Warn("\n\ncompare decode transaction:", block.GetTransactions()[0])
 
 }
+
+func TestBlockTampered(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test tampered block ===============================================")
+	initBalance()
+	testBalance.Store(testKeys[0].ToAddress(), 20)
+
+	newTransaction := func(amount uint64) Transaction {
+		tr := new(HippoTransaction)
+		tr.New(testHashfunction, testCurve)
+		tr.SetSender([]string{testKeys[0].ToAddress()}, []uint64{amount})
+		tr.SetReceiver([]string{testKeys[1].ToAddress()}, []uint64{amount})
+		tr.UpdateFee()
+		tr.Sign(testKeys[0])
+		return tr
+	}
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	block := mineTestBlock(genesis, 240, testKeys[0],
+		[]Transaction{newTransaction(10)}).(*HippoBlock)
+	assertT(block.Check(), t)
+
+	// Another transaction set with the same nonce.
+	tampered := *block
+	tampered.SetTransactions([]Transaction{newTransaction(20)})
+	assertT(!tampered.CheckNonce(), t)
+	assertT(!tampered.Check(), t)
+
+	// Another miner signs the same block and reuses the nonce.
+	tampered = *block
+	tampered.Sign(testKeys[2])
+	assertT(tampered.CheckSignature(), t)
+	assertT(!tampered.CheckNonce(), t)
+
+	// Another parent.
+	tampered = *block
+	tampered.PreviousHash = []byte{1, 2, 3}
+	assertT(!tampered.CheckNonce(), t)
+	assertT(!tampered.CheckSignature(), t)
+
+	// Another timestamp or difficulty.
+	tampered = *block
+	tampered.Timestamp++
+	assertT(!tampered.CheckNonce(), t)
+	tampered = *block
+	tampered.NumBytes = 250
+	assertT(!tampered.CheckNonce(), t)
+}
+
+func TestWindowDifficulty(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test window difficulty ===============================================")
+	initBalance()
+	initStorage()
+	difficulty := NewWindowDifficulty(2)
+	testStorage.SetDifficulty(difficulty, 10)
+
+	genesis := mineTestBlockAt(nil, 250, testKeys[0], nil, 1000)
+	a1 := mineTestBlockAt(genesis, 250, testKeys[0], nil, 1001)
+	assertT(testStorage.Add(genesis), t)
+
+	// Not a retarget level: the difficulty is kept.
+	assertT(difficulty(genesis, testStorage, 10) == 250, t)
+
+	// Too fast: smaller numBytes, at most 2 each time.
+	assertT(difficulty(a1, testStorage, 10) == 248, t)
+	// Too slow: larger numBytes.
+	slow := mineTestBlockAt(genesis, 250, testKeys[1], nil, 1100)
+	assertT(difficulty(slow, testStorage, 10) == 252, t)
+	// On time.
+	onTime := mineTestBlockAt(genesis, 250, testKeys[1], nil, 1010)
+	assertT(difficulty(onTime, testStorage, 10) == 250, t)
+
+	// A child arriving before its parent is checked once the parent is added.
+	bad2 := mineTestBlockAt(a1, 250, testKeys[1], nil, 1002)
+	assertT(testStorage.Add(bad2), t)
+	assertT(testStorage.Add(a1), t)
+	assertT(testStorage.CheckVerified(a1.Hash()), t)
+	assertT(!testStorage.CheckVerified(bad2.Hash()), t)
+
+	// Blocks with a wrong difficulty are rejected.
+	assertT(!testStorage.Add(mineTestBlockAt(a1, 250, testKeys[0], nil, 1002)), t)
+	a2 := mineTestBlockAt(a1, 248, testKeys[0], nil, 1002)
+	assertT(testStorage.Add(a2), t)
+	assertT(testStorage.GetTopBlock().Hash() == a2.Hash(), t)
+
+	// Header chains are checked by the same rule.
+	testStorage.SetHeaderDifficulty(NewWindowHeaderDifficulty(2))
+	rules := testStorage.HeaderRules()
+	rules.Anchor = genesis.Hash()
+	assertT(VerifyHeaderChain([]BlockHeader{genesis.GetHeader(), a1.GetHeader(),
+		a2.GetHeader()}, rules, testHashfunction), t)
+	assertT(!VerifyHeaderChain([]BlockHeader{genesis.GetHeader(), a1.GetHeader(),
+		bad2.GetHeader()}, rules, testHashfunction), t)
+	// The ancestors of the first header are found in the storage.
+	rules.Anchor = a1.Hash()
+	assertT(VerifyHeaderChain([]BlockHeader{a2.GetHeader()}, rules, testHashfunction), t)
+	assertT(!VerifyHeaderChain([]BlockHeader{bad2.GetHeader()}, rules, testHashfunction), t)
+}
+
+func TestBlockCoinbase(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test block coinbase ===============================================")
+	initBalance()
+	testBalance.Store(testKeys[0].ToAddress(), 20)
+
+	tr := new(HippoTransaction)
+	tr.New(testHashfunction, testCurve)
+	tr.SetSender([]string{testKeys[0].ToAddress()}, []uint64{20})
+	tr.SetReceiver([]string{testKeys[1].ToAddress()}, []uint64{15})
+	tr.UpdateFee()
+	tr.Sign(testKeys[0])
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	block := mineTestBlock(genesis, 250, testKeys[1], []Transaction{tr})
+	assertT(block.Check(), t)
+	change := block.GetBalanceChange()
+	assertT(change[testKeys[1].ToAddress()] == int64(Reward(block))+5+15, t)
+	assertT(change[testKeys[0].ToAddress()] == -20, t)
+
+	newBlock := func(coinbase Transaction, trs ...Transaction) *HippoBlock {
+		var b HippoBlock
+		b.New(genesis.HashBytes(), 250, testHashfunction, 1, testBalance, testCurve)
+		b.SetTransactions(append([]Transaction{coinbase}, trs...))
+		b.Sign(testKeys[1])
+		return &b
+	}
+	miner := testKeys[1].ToAddress()
+
+	// Paying less is fine, more is not.
+	assertT(newBlock(CreateCoinbaseTransaction(testHashfunction, testCurve,
+		miner, Reward(block), 1), tr).CheckTransactions(), t)
+	assertT(!newBlock(CreateCoinbaseTransaction(testHashfunction, testCurve,
+		miner, Reward(block)+6, 1), tr).CheckTransactions(), t)
+	// Pay to the miner at the block level only.
+	assertT(!newBlock(CreateCoinbaseTransaction(testHashfunction, testCurve,
+		testKeys[0].ToAddress(), 1, 1)).CheckTransactions(), t)
+	assertT(!newBlock(CreateCoinbaseTransaction(testHashfunction, testCurve,
+		miner, 1, 2)).CheckTransactions(), t)
+	// The coinbase goes first, and only once.
+	assertT(!newBlock(tr).CheckTransactions(), t)
+	coinbase := CreateCoinbaseTransaction(testHashfunction, testCurve, miner, 1, 1)
+	assertT(!newBlock(coinbase, coinbase).CheckTransactions(), t)
+
+	// Fees that add up past MaxInt64 do not wrap into a large coinbase.
+	var huge []Transaction
+	for i := 0; i < 3; i++ {
+		h := new(HippoTransaction)
+		h.New(testHashfunction, testCurve)
+		h.SetSender([]string{testKeys[i%2].ToAddress()}, []uint64{math.MaxInt64})
+		h.SetReceiver([]string{miner}, []uint64{1})
+		h.UpdateFee()
+		h.Sign(testKeys[i%2])
+		assertT(h.CheckWithoutBalance(), t)
+		huge = append(huge, h)
+	}
+	assertT(!newBlock(CreateCoinbaseTransaction(testHashfunction, testCurve,
+		miner, 1<<62, 1), huge...).CheckTransactions(), t)
+	assertT(!newBlock(CreateCoinbaseTransaction(testHashfunction, testCurve,
+		miner, Reward(block), 1), huge[:2]...).CheckTransactions(), t)
+}
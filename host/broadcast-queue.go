@@ -2,6 +2,7 @@ package host
 
 import (
 	"context"
+	"sync"
 )
 
 // BroadcastQueue ...
@@ -24,6 +25,7 @@ type BroadcastQueue interface {
 }
 
 // HippoBroadcastQueue ...
+// New blocks and transactions are announced by hash once; see gossip.go.
 type HippoBroadcastQueue struct {
 	ctx                context.Context
 	cancel             context.CancelFunc
@@ -34,6 +36,8 @@ type HippoBroadcastQueue struct {
 	p2pClient          P2PClientInterface
 
 	maxBroadcastLevel uint
+	// announced: inventory type + hash of the data already relayed.
+	announced SeenCache
 }
 
 // New ...
@@ -45,6 +49,8 @@ func (bq *HippoBroadcastQueue) New(ctx context.Context, protocol string,
 	bq.protocol = protocol
 	bq.p2pClient = p2pClient
 	bq.maxBroadcastLevel = maxBroadcastLevel
+	bq.announced = new(HippoSeenCache)
+	bq.announced.New(DefaultSeenCapacity, DefaultSeenTTL)
 }
 
 // SetNetworkClient ...
@@ -95,69 +101,102 @@ func (bq *HippoBroadcastQueue) Stop() {
 	bq.cancel()
 }
 
-func (bq *HippoBroadcastQueue) broadcastBlockSend(block BroadcastBlock) {
-	debugLogger.Debug("receive broadcast block")
-	addresses := bq.networkClient.GetNeighbors()
-	debugLogger.Debug("neighbors all:", addresses)
+// supportsInventory ...
+// Whether address takes Inv instead of the full data.
+func (bq *HippoBroadcastQueue) supportsInventory(address string) bool {
+	info, ok := bq.networkClient.GetPeerInfo(address)
+	return ok && info.HasFeature(FeatureInventory)
+}
 
-	if block.Addresses == nil {
-		block.Addresses = make(map[string]bool)
-		infoLogger.Fatal("error: no address")
+// targets ...
+// The neighbors to relay to, except self and from.
+func (bq *HippoBroadcastQueue) targets(from string) []string {
+	self := bq.networkClient.GetAddress()
+	targets := make([]string, 0)
+	for _, address := range bq.networkClient.GetNeighbors() {
+		if address != self && address != from {
+			targets = append(targets, address)
+		}
 	}
+	return targets
+}
 
-	addressesToSend := make(map[string]bool)
-	for _, address := range addresses {
-		addressesToSend[address] = true
+// sendAll ...
+// Send to all targets in parallel, so that a slow neighbor does not delay the others.
+func sendAll(targets []string, send func(address string)) {
+	var wg sync.WaitGroup
+	for _, address := range targets {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			send(address)
+		}(address)
 	}
-	for address := range block.Addresses {
-		delete(addressesToSend, address)
+	wg.Wait()
+}
+
+func (bq *HippoBroadcastQueue) broadcastBlockSend(block BroadcastBlock) {
+	debugLogger.Debug("receive broadcast block")
+	if bq.networkClient == nil {
+		infoLogger.Error("broadcastQueue: no netowrk client")
+		return
+	}
+	h := block.block.Hash()
+	if !bq.announced.Add(InventoryBlock + h) {
+		debugLogger.Debug("broadcast block: already announced", h)
+		return
 	}
-	delete(addressesToSend, bq.networkClient.GetAddress())
 
-	for address := range addressesToSend {
-		block.Addresses[address] = true
+	targets := bq.targets(block.Sender)
+	block.Sender = bq.networkClient.GetAddress()
+	inv := Inventory{
+		Type:   InventoryBlock,
+		Hashes: []string{h},
+		Sender: block.Sender,
 	}
-	debugLogger.Debug("neighbors to send:", addressesToSend)
-	for address := range addressesToSend {
-		debugLogger.Debug("send broadcast block to", address)
+	debugLogger.Debug("neighbors to send:", targets)
+	sendAll(targets, func(address string) {
 		var reply string
-		if bq.networkClient == nil {
-			infoLogger.Error("broadcastQueue: no netowrk client")
-			break
+		if bq.supportsInventory(address) {
+			debugLogger.Debug("announce block to", address)
+			bq.networkClient.Inv(address, inv, &reply)
+		} else {
+			debugLogger.Debug("send broadcast block to", address)
+			bq.networkClient.BroadcastBlock(address, block, &reply)
 		}
-
-		bq.networkClient.BroadcastBlock(address, block, &reply)
-	}
+	})
 	debugLogger.Debug("broadcast send done.")
 }
 
 func (bq *HippoBroadcastQueue) broadcastTransactionSend(transaction BroadcastTransaction) {
 	debugLogger.Debug("receive broadcast transaction")
-	addresses := bq.networkClient.GetNeighbors()
-	debugLogger.Debug("neighbors all:", addresses)
-
-	addressesToSend := make(map[string]bool)
-	for _, address := range addresses {
-		addressesToSend[address] = true
+	if bq.networkClient == nil {
+		infoLogger.Error("broadcastQueue: no netowrk client")
+		return
 	}
-	for address := range transaction.Addresses {
-		delete(addressesToSend, address)
+	h := transaction.transaction.Hash()
+	if !bq.announced.Add(InventoryTransaction + h) {
+		debugLogger.Debug("broadcast transaction: already announced", h)
+		return
 	}
-	delete(addressesToSend, bq.networkClient.GetAddress())
 
-	for address := range addressesToSend {
-		transaction.Addresses[address] = true
+	targets := bq.targets(transaction.Sender)
+	transaction.Sender = bq.networkClient.GetAddress()
+	inv := Inventory{
+		Type:   InventoryTransaction,
+		Hashes: []string{h},
+		Sender: transaction.Sender,
 	}
-	debugLogger.Debug("neighbors to send:", addressesToSend)
-	for address := range addressesToSend {
-		debugLogger.Debug("send broadcast transaction to", address)
+	debugLogger.Debug("neighbors to send:", targets)
+	sendAll(targets, func(address string) {
 		var reply string
-		if bq.networkClient == nil {
-			infoLogger.Error("broadcastQueue: no netowrk client")
-			break
+		if bq.supportsInventory(address) {
+			debugLogger.Debug("announce transaction to", address)
+			bq.networkClient.Inv(address, inv, &reply)
+		} else {
+			debugLogger.Debug("send broadcast transaction to", address)
+			bq.networkClient.BroadcastTransaction(address, transaction, &reply)
 		}
-
-		bq.networkClient.BroadcastTransaction(address, transaction, &reply)
-	}
+	})
 	debugLogger.Debug("broadcast transaction send done.")
 }
@@ -0,0 +1,428 @@
+package host
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Binary codec:
+// A compact and deterministic encoding of blocks, transactions and the broadcast envelopes.
+// Every message starts with binaryMagic, the codec version and the kind.
+// JSON always starts with '{' or '[', so the decoders accept both encodings.
+// Integers are varints. Hex strings such as hashes, signatures and addresses
+// are stored as raw bytes.
+// Version 2 adds the multisig witnesses of transactions; version 1 is still read.
+
+// Codecs ...
+// CodecBinaryV1 is asked in GetBlocks by peers reading only version 1 of the binary
+// codec. They get a binary list of JSON blocks, which they decode as well.
+const (
+	CodecJSON     = "json"
+	CodecBinary   = "binary-2"
+	CodecBinaryV1 = "binary"
+)
+
+const (
+	binaryMagic        = 0xb1
+	binaryCodecVersion = 2
+
+	kindBlock                byte = 1
+	kindTransaction          byte = 2
+	kindBroadcastBlock       byte = 3
+	kindBroadcastTransaction byte = 4
+
+	// string forms
+	stringRaw     byte = 0
+	stringHex     byte = 1
+	stringHexPair byte = 2 // "x|y", e.g. an address
+
+	// maxBinaryLength limits every length read, against garbage input.
+	maxBinaryLength = 1 << 24
+)
+
+var errBinaryCodec = errors.New("binary codec: malformed data")
+
+// IsBinary ...
+// Whether data is in the binary encoding.
+func IsBinary(data []byte) bool { return len(data) > 0 && data[0] == binaryMagic }
+
+// ==============================================================
+
+type binaryWriter struct {
+	bytes.Buffer
+}
+
+func newBinaryWriter(kind byte) *binaryWriter {
+	w := new(binaryWriter)
+	w.WriteByte(binaryMagic)
+	w.WriteByte(binaryCodecVersion)
+	w.WriteByte(kind)
+	return w
+}
+
+func (w *binaryWriter) uvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+func (w *binaryWriter) varint(x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.Write(b)
+}
+
+// hexDigits ...
+// Write a lowercase hex string of any length as bytes.
+func (w *binaryWriter) hexDigits(s string) {
+	w.uvarint(uint64(len(s)))
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, _ := hex.DecodeString(s)
+	w.Write(b)
+}
+
+func (w *binaryWriter) string(s string) {
+	if isLowerHex(s) {
+		w.WriteByte(stringHex)
+		w.hexDigits(s)
+		return
+	}
+	if parts := strings.Split(s, "|"); len(parts) == 2 &&
+		isLowerHex(parts[0]) && isLowerHex(parts[1]) {
+		w.WriteByte(stringHexPair)
+		w.hexDigits(parts[0])
+		w.hexDigits(parts[1])
+		return
+	}
+	w.WriteByte(stringRaw)
+	w.bytes([]byte(s))
+}
+
+func (w *binaryWriter) strings(s []string) {
+	w.uvarint(uint64(len(s)))
+	for _, x := range s {
+		w.string(x)
+	}
+}
+
+func (w *binaryWriter) uint64s(s []uint64) {
+	w.uvarint(uint64(len(s)))
+	for _, x := range s {
+		w.uvarint(x)
+	}
+}
+
+func isLowerHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ==============================================================
+
+// binaryReader ...
+// The first error is kept and later reads return zero values.
+type binaryReader struct {
+	data    []byte
+	err     error
+	version byte
+}
+
+func newBinaryReader(data []byte, kind byte) *binaryReader {
+	r := &binaryReader{data: data}
+	if len(data) < 3 || data[0] != binaryMagic || data[1] < 1 || data[1] > binaryCodecVersion ||
+		data[2] != kind {
+		r.err = errBinaryCodec
+		return r
+	}
+	r.version, r.data = data[1], data[3:]
+	return r
+}
+
+func (r *binaryReader) fail() { r.err, r.data = errBinaryCodec, nil }
+
+func (r *binaryReader) byte() byte {
+	if r.err != nil || len(r.data) == 0 {
+		r.fail()
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+// length ...
+// Read a length and check that at least min bytes per item are left.
+func (r *binaryReader) length(min int) int {
+	n := r.uvarint()
+	if r.err != nil || n > maxBinaryLength || int(n)*min > len(r.data) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) next(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.fail()
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, r.data[:n])
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) bytes() []byte { return r.next(r.length(1)) }
+
+func (r *binaryReader) hexDigits() string {
+	n := r.length(0)
+	s := hex.EncodeToString(r.next((n + 1) / 2))
+	if r.err != nil {
+		return ""
+	}
+	return s[len(s)-n:]
+}
+
+func (r *binaryReader) string() string {
+	switch r.byte() {
+	case stringRaw:
+		return string(r.bytes())
+	case stringHex:
+		return r.hexDigits()
+	case stringHexPair:
+		x := r.hexDigits()
+		return x + "|" + r.hexDigits()
+	}
+	r.fail()
+	return ""
+}
+
+func (r *binaryReader) strings() []string {
+	s := make([]string, r.length(1))
+	for i := range s {
+		s[i] = r.string()
+	}
+	return s
+}
+
+func (r *binaryReader) uint64s() []uint64 {
+	s := make([]uint64, r.length(1))
+	for i := range s {
+		s[i] = r.uvarint()
+	}
+	return s
+}
+
+// end ...
+// Return the error, or an error if anything is left.
+func (r *binaryReader) end() error {
+	if r.err == nil && len(r.data) > 0 {
+		r.fail()
+	}
+	return r.err
+}
+
+// ==============================================================
+
+// EncodeTransactionBinary ...
+func EncodeTransactionBinary(t Transaction) []byte {
+	w := newBinaryWriter(kindTransaction)
+	writeTransaction(w, t)
+	return w.Bytes()
+}
+
+func writeTransaction(w *binaryWriter, t Transaction) {
+	senders, senderAmounts := t.GetSender()
+	receivers, receiverAmounts := t.GetReceiver()
+	w.strings(senders)
+	w.uint64s(senderAmounts)
+	w.uint64s(t.GetNonces())
+	w.strings(receivers)
+	w.uint64s(receiverAmounts)
+	w.uvarint(t.GetFee())
+	w.varint(t.GetTimestamp())
+	w.strings(t.GetSignatures())
+	w.varint(int64(t.GetLevel()))
+	witnesses := t.GetMultisig()
+	w.uvarint(uint64(len(witnesses)))
+	for _, witness := range witnesses {
+		w.uvarint(uint64(witness.M))
+		w.strings(witness.PublicKeys)
+		w.strings(witness.Signatures)
+	}
+}
+
+func readTransaction(r *binaryReader) *HippoTransaction {
+	t := new(HippoTransaction)
+	t.SenderAddresses = r.strings()
+	t.SenderAmounts = r.uint64s()
+	t.SenderNonces = r.uint64s()
+	t.ReceiverAddresses = r.strings()
+	t.ReceiverAmounts = r.uint64s()
+	t.Fee = r.uvarint()
+	t.Timestamp = r.varint()
+	t.SenderSignatures = r.strings()
+	t.Level = int(r.varint())
+	if r.version < 2 {
+		return t
+	}
+	if n := r.length(3); n > 0 {
+		t.SenderMultisig = make([]MultisigWitness, n)
+		for i := range t.SenderMultisig {
+			witness := &t.SenderMultisig[i]
+			witness.M = int(r.uvarint())
+			witness.PublicKeys = r.strings()
+			witness.Signatures = r.strings()
+		}
+	}
+	return t
+}
+
+// decodeTransactionBinary ...
+// The hash function and the curve are not set.
+func decodeTransactionBinary(data []byte) (*HippoTransaction, error) {
+	r := newBinaryReader(data, kindTransaction)
+	t := readTransaction(r)
+	return t, r.end()
+}
+
+// EncodeBlockBinary ...
+func EncodeBlockBinary(b Block) []byte {
+	header := b.GetHeader()
+	w := newBinaryWriter(kindBlock)
+	w.bytes(header.PreviousHash)
+	w.string(header.TransactionRoot)
+	w.uvarint(uint64(header.NumBytes))
+	w.uvarint(uint64(header.Nonce))
+	w.varint(int64(header.Level))
+	w.varint(header.Timestamp)
+	w.string(header.MinerAddress)
+	w.string(header.MinerSignature)
+	transactions := b.GetTransactions()
+	w.uvarint(uint64(len(transactions)))
+	for _, t := range transactions {
+		writeTransaction(w, t)
+	}
+	return w.Bytes()
+}
+
+// decodeBlockBinary ...
+// The transaction root is kept as it is encoded.
+func decodeBlockBinary(data []byte, templateBlock Block) (Block, error) {
+	r := newBinaryReader(data, kindBlock)
+	hb := new(HippoBlock)
+	hb.PreviousHash = r.bytes()
+	hb.TransactionsRoot = r.string()
+	hb.NumBytes = uint(r.uvarint())
+	hb.Nonce = uint32(r.uvarint())
+	hb.Level = int(r.varint())
+	hb.Timestamp = r.varint()
+	hb.MinerAddress = r.string()
+	hb.MinerSignature = r.string()
+	hb.transactions = make([]Transaction, r.length(1))
+	for i := range hb.transactions {
+		t := readTransaction(r)
+		t.hashFunction = templateBlock.GetHashFunction()
+		t.curve = templateBlock.GetCurve()
+		hb.transactions[i] = t
+	}
+	if err := r.end(); err != nil {
+		return nil, err
+	}
+	b := templateBlock.CloneConstants()
+	b.CopyVariables(hb)
+	return b, nil
+}
+
+// ==============================================================
+
+// EncodeBlockList ...
+// Encode a list of blocks with codec.
+func EncodeBlockList(blocks []Block, codec string) ([]byte, error) {
+	encoded := make([][]byte, len(blocks))
+	for i, b := range blocks {
+		if codec == CodecBinary {
+			encoded[i] = EncodeBlockBinary(b)
+		} else {
+			encoded[i] = b.Encode()
+		}
+	}
+	if codec != CodecBinary && codec != CodecBinaryV1 {
+		return json.Marshal(encoded)
+	}
+	w := new(binaryWriter)
+	w.uvarint(uint64(len(encoded)))
+	for _, data := range encoded {
+		w.bytes(data)
+	}
+	return w.Bytes(), nil
+}
+
+// DecodeBlockList ...
+// Decode a list from EncodeBlockList. Blocks that cannot be decoded are skipped.
+func DecodeBlockList(data []byte, templateBlock Block, codec string) []Block {
+	var encoded [][]byte
+	if codec == CodecBinary || codec == CodecBinaryV1 {
+		r := &binaryReader{data: data}
+		encoded = make([][]byte, r.length(1))
+		for i := range encoded {
+			encoded[i] = r.bytes()
+		}
+		if err := r.end(); err != nil {
+			infoLogger.Error("decode blocks:", err)
+			return nil
+		}
+	} else if err := json.Unmarshal(data, &encoded); err != nil {
+		infoLogger.Error("decode blocks:", err)
+		return nil
+	}
+	blocks := make([]Block, 0, len(encoded))
+	for _, blockBytes := range encoded {
+		if b := DecodeBlock(blockBytes, templateBlock); b != nil {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
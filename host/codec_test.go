@@ -0,0 +1,112 @@
+package host
+
+import (
+	"testing"
+)
+
+func newCodecTestBlock() Block {
+	trs := []Transaction{newNonceTestTransaction(0), newNonceTestTransaction(1)}
+	return mineTestBlock(nil, 250, testKeys[0], trs)
+}
+
+func TestBinaryCodec(t *testing.T) {
+	initTest(2)
+	initBalance()
+	block := newCodecTestBlock()
+
+	data := EncodeBlockBinary(block)
+	assertT(IsBinary(data) && !IsBinary(block.Encode()), t)
+	assertT(len(data) < len(block.Encode()), t)
+	// The encoding is deterministic.
+	assertT(string(data) == string(EncodeBlockBinary(block)), t)
+
+	decoded := DecodeBlock(data, block)
+	assertT(decoded != nil && decoded.Hash() == block.Hash(), t)
+	assertT(decoded.CheckSignature() && decoded.CheckTransactionRoot() && decoded.CheckNonce(), t)
+	trs := decoded.GetTransactions()
+	assertT(len(trs) == 3 && trs[0].IsCoinbase(), t)
+	for i, tr := range trs {
+		assertT(tr.HashSignatures() == block.GetTransactions()[i].HashSignatures(), t)
+	}
+	assertT(trs[1].CheckSignatures() && trs[2].GetNonces()[0] == 1, t)
+
+	tr := DecodeTransaction(EncodeTransactionBinary(trs[1]), testHashfunction, testCurve)
+	assertT(tr != nil && tr.HashSignatures() == trs[1].HashSignatures(), t)
+
+	// Broken data is rejected.
+	assertT(DecodeBlock(data[:len(data)-1], block) == nil, t)
+	assertT(DecodeBlock(append(data, 0), block) == nil, t)
+	assertT(DecodeTransaction([]byte{binaryMagic, binaryCodecVersion, kindTransaction, 0xff},
+		testHashfunction, testCurve) == nil, t)
+}
+
+func TestBinaryCodecEnvelope(t *testing.T) {
+	initTest(2)
+	initBalance()
+	block := newCodecTestBlock()
+
+	for _, codec := range []string{CodecJSON, CodecBinary} {
+		bb := BroadcastBlock{block: block, Level: 2, Sender: "a:1"}
+		bb.SetCodec(codec)
+		received, err := DecodeBroadcastBlock(bb.Encode())
+		assertT(err == nil && received.Level == 2 && received.Sender == "a:1", t)
+		decoded := DecodeBlock(received.Data, block)
+		assertT(decoded != nil && decoded.Hash() == block.Hash(), t)
+
+		bt := BroadcastTransaction{transaction: block.GetTransactions()[1], Sender: "b:1"}
+		bt.SetCodec(codec)
+		receivedTr := BroadcastTransaction{Data: bt.Encode()}
+		assertT(receivedTr.Decode(block.GetTransactions()[1]) == nil, t)
+		assertT(receivedTr.Sender == "b:1" && receivedTr.transaction.HashSignatures() ==
+			block.GetTransactions()[1].HashSignatures(), t)
+
+		list, err := EncodeBlockList([]Block{block, block}, codec)
+		assertT(err == nil, t)
+		blocks := DecodeBlockList(list, block, codec)
+		assertT(len(blocks) == 2 && blocks[1].Hash() == block.Hash(), t)
+	}
+
+	// Peers reading only version 1 get JSON blocks in a binary list.
+	list, err := EncodeBlockList([]Block{block}, CodecBinaryV1)
+	assertT(err == nil, t)
+	r := &binaryReader{data: list}
+	assertT(r.length(1) == 1 && !IsBinary(r.bytes()) && r.end() == nil, t)
+	blocks := DecodeBlockList(list, block, CodecBinaryV1)
+	assertT(len(blocks) == 1 && blocks[0].Hash() == block.Hash(), t)
+}
+
+// BenchmarkBlockEncoding ...
+// Compare the size and speed of the JSON and the binary codec.
+func BenchmarkBlockEncoding(b *testing.B) {
+	initTest(2)
+	initBalance()
+	trs := make([]Transaction, 20)
+	for i := range trs {
+		trs[i] = newNonceTestTransaction(uint64(i))
+	}
+	block := mineTestBlock(nil, 250, testKeys[0], trs)
+	envelope := func(codec string) []byte {
+		bb := BroadcastBlock{block: block}
+		bb.SetCodec(codec)
+		return bb.Encode()
+	}
+
+	b.Run("json", func(b *testing.B) {
+		var data []byte
+		for i := 0; i < b.N; i++ {
+			data = envelope(CodecJSON)
+			received, _ := DecodeBroadcastBlock(data)
+			DecodeBlock(received.Data, block)
+		}
+		b.ReportMetric(float64(len(data)), "bytes/block")
+	})
+	b.Run("binary", func(b *testing.B) {
+		var data []byte
+		for i := 0; i < b.N; i++ {
+			data = envelope(CodecBinary)
+			received, _ := DecodeBroadcastBlock(data)
+			DecodeBlock(received.Data, block)
+		}
+		b.ReportMetric(float64(len(data)), "bytes/block")
+	})
+}
@@ -42,6 +42,12 @@ func (k *Key) GenerateKey() {
 	k.publicKey = &k.key.PublicKey
 }
 
+// SetKey ...
+func (k *Key) SetKey(key *ecdsa.PrivateKey) {
+	k.key = key
+	k.publicKey = &key.PublicKey
+}
+
 // SaveKey ...
 func (k *Key) SaveKey(privateKeyFile string) {
 	SaveKey(k.key, privateKeyFile)
@@ -152,7 +158,7 @@ func SaveKey(key *ecdsa.PrivateKey, privateKeyFile string) {
 	privateKeyBytes := keyToByte(key)
 	infoLogger.Info("Create one key pair:", privateKeyFile)
 
-	ioutil.WriteFile(privateKeyFile, privateKeyBytes, 0644)
+	ioutil.WriteFile(privateKeyFile, privateKeyBytes, 0600)
 }
 
 func publicKeyToAddress(publicKey ecdsa.PublicKey) string {
@@ -225,6 +231,17 @@ func publicKeyToString(publicKey ecdsa.PublicKey) string {
 	return publicKey.X.Text(16) + "|" + publicKey.Y.Text(16)
 }
 
+// ValidAddress ...
+// Check that address is a public key string of a point on curve, or a multisig address.
+func ValidAddress(address string, curve elliptic.Curve) bool {
+	if IsMultisigAddress(address) {
+		_, _, ok := ParseMultisigAddress(address)
+		return ok
+	}
+	publicKey := stringToPublicKey(address, curve)
+	return publicKey != nil && curve.IsOnCurve(publicKey.X, publicKey.Y)
+}
+
 // validate if the publicKey and the address matched
 func validatePublicKeyAddress(publicKey ecdsa.PublicKey, address string) bool {
 	return publicKeyToAddress(publicKey) == address
@@ -0,0 +1,67 @@
+package host
+
+import (
+	"encoding/json"
+
+	registerlib "github.com/XieGuochao/HippoCoinRegister/lib"
+)
+
+// Discovery ...
+// A source of peer addresses. The network client asks every source,
+// the address book and the neighbors (peer exchange) for new peers,
+// so that no single source is required.
+type Discovery interface {
+	Name() string
+	Discover(self string, n int) []string
+}
+
+// SeedDiscovery ...
+// Static peers from the config.
+type SeedDiscovery struct {
+	Seeds []string
+}
+
+// Name ...
+func (d *SeedDiscovery) Name() string { return "seed" }
+
+// Discover ...
+func (d *SeedDiscovery) Discover(self string, n int) []string {
+	addresses := make([]string, 0, len(d.Seeds))
+	for _, seed := range d.Seeds {
+		if seed != self {
+			addresses = append(addresses, seed)
+		}
+	}
+	return addresses
+}
+
+// RegisterDiscovery ...
+// Peers from the HippoCoinRegister service. It also registers self there.
+type RegisterDiscovery struct {
+	Register Register
+}
+
+// Name ...
+func (d *RegisterDiscovery) Name() string { return "register" }
+
+// Discover ...
+func (d *RegisterDiscovery) Discover(self string, n int) []string {
+	var reply []byte
+	registerClient := d.Register.Client()
+	if registerClient == nil {
+		return nil
+	}
+	defer registerClient.Close()
+	err := registerClient.AddressesRefresh(registerlib.RefreshStruct{
+		Number:  n,
+		Address: self,
+	}, &reply)
+	if err != nil {
+		infoLogger.Error("register discovery:", err)
+		return nil
+	}
+
+	var addresses []string
+	json.Unmarshal(reply, &addresses)
+	return addresses
+}
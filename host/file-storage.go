@@ -0,0 +1,162 @@
+package host
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HippoFileStorage ...
+// HippoFileStorage works like HippoStorage and appends every new block to a log file,
+// so that the chain survives restarts.
+// Each record in the log is a 4-byte little-endian length followed by block.Encode().
+// Steps:
+// 1. SetPath(path)
+// 2. New()
+// 3. SetBalance(balance)
+// 4. Load(templateBlock)  Reload and re-verify the blocks.
+// 5. Close()
+type HippoFileStorage struct {
+	HippoStorage
+
+	path     string
+	fileLock sync.Mutex
+	file     *os.File
+	loading  bool
+
+	// index: hash -> offset of the record in the log.
+	index map[string]int64
+	size  int64
+}
+
+// SetPath ...
+func (storage *HippoFileStorage) SetPath(path string) { storage.path = path }
+
+// New ...
+func (storage *HippoFileStorage) New() {
+	var err error
+	storage.HippoStorage.New()
+	storage.onAdd = storage.appendBlock
+	storage.index = make(map[string]int64)
+
+	if dir := filepath.Dir(storage.path); dir != "" {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			infoLogger.Error("file storage: create directory:", err)
+		}
+	}
+	storage.file, err = os.OpenFile(storage.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		infoLogger.Error("file storage: open:", err)
+		storage.file = nil
+		return
+	}
+	if info, err := storage.file.Stat(); err == nil {
+		storage.size = info.Size()
+	}
+	infoLogger.Info("file storage: open", storage.path)
+}
+
+// Load ...
+// Read all blocks from the log and add them again, so that every block is re-verified.
+// A broken record at the end of the log (e.g. after a crash) is truncated.
+// Return the number of blocks loaded.
+func (storage *HippoFileStorage) Load(templateBlock Block) int {
+	storage.fileLock.Lock()
+	defer storage.fileLock.Unlock()
+	if storage.file == nil {
+		infoLogger.Error("file storage: no file to load")
+		return 0
+	}
+
+	var (
+		offset int64
+		count  int
+		header = make([]byte, 4)
+	)
+	storage.loading = true
+	defer func() { storage.loading = false }()
+
+	if _, err := storage.file.Seek(0, io.SeekStart); err != nil {
+		infoLogger.Error("file storage: load:", err)
+		return 0
+	}
+	for {
+		if _, err := io.ReadFull(storage.file, header); err != nil {
+			if err != io.EOF {
+				infoLogger.Warn("file storage: broken record at", offset)
+			}
+			break
+		}
+		data := make([]byte, ByteToUint32(header))
+		if _, err := io.ReadFull(storage.file, data); err != nil {
+			infoLogger.Warn("file storage: broken record at", offset)
+			break
+		}
+
+		block := DecodeBlock(data, templateBlock)
+		if block == nil {
+			infoLogger.Error("file storage: cannot decode block at", offset)
+		} else {
+			storage.index[block.Hash()] = offset
+			// Unlock while adding since Add calls appendBlock.
+			storage.fileLock.Unlock()
+			ok := storage.HippoStorage.Add(block)
+			storage.fileLock.Lock()
+			if ok {
+				count++
+			} else {
+				infoLogger.Error("file storage: block check failed:", block.Hash())
+			}
+		}
+		offset += int64(len(header) + len(data))
+	}
+
+	if err := storage.file.Truncate(offset); err != nil {
+		infoLogger.Error("file storage: truncate:", err)
+	}
+	storage.size = offset
+	infoLogger.Info("file storage: load blocks:", count)
+	return count
+}
+
+// appendBlock ...
+// Append a new block to the log.
+func (storage *HippoFileStorage) appendBlock(block Block) {
+	storage.fileLock.Lock()
+	defer storage.fileLock.Unlock()
+	if storage.loading || storage.file == nil {
+		return
+	}
+	h := block.Hash()
+	if _, has := storage.index[h]; has {
+		return
+	}
+
+	data := block.Encode()
+	if data == nil {
+		infoLogger.Error("file storage: cannot encode block:", h)
+		return
+	}
+	record := append(Uint32ToBytes(uint32(len(data))), data...)
+	if _, err := storage.file.WriteAt(record, storage.size); err != nil {
+		infoLogger.Error("file storage: write:", err)
+		return
+	}
+	if err := storage.file.Sync(); err != nil {
+		infoLogger.Error("file storage: sync:", err)
+	}
+	storage.index[h] = storage.size
+	storage.size += int64(len(record))
+	debugLogger.Debug("file storage: append block:", h)
+}
+
+// Close ...
+func (storage *HippoFileStorage) Close() {
+	storage.fileLock.Lock()
+	defer storage.fileLock.Unlock()
+	if storage.file != nil {
+		storage.file.Close()
+		storage.file = nil
+	}
+}
@@ -0,0 +1,70 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorageReload(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test file storage ==============================")
+	dir, err := ioutil.TempDir("", "hippo-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocks.log")
+
+	initBalance()
+	storage := NewStorage("file", path)
+	storage.New()
+	storage.SetBalance(testBalance)
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	block1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	assertT(storage.Add(genesis), t)
+	assertT(storage.Add(block1), t)
+	assertT(storage.Add(block1), t) // stored twice but written once
+	storage.Close()
+	balance := testBalance.AllBalance()
+
+	// Restart with a fresh storage and balance.
+	initBalance()
+	storage = NewStorage("file", path)
+	storage.New()
+	storage.SetBalance(testBalance)
+	assertT(storage.Load(block1.CloneConstants()) == 2, t)
+	defer storage.Close()
+
+	assertT(storage.Count() == 2, t)
+	assertT(storage.MaxLevel() == 1, t)
+	assertT(storage.CheckVerified(block1.Hash()), t)
+	assertT(storage.GetTopBlock().Hash() == block1.Hash(), t)
+	for address, value := range balance {
+		assertT(testBalance.Get(address) == value, t)
+	}
+
+	// A broken tail record is dropped on the next load.
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte{100, 0, 0, 0, '{'})
+	file.Close()
+	storage.Close()
+
+	initBalance()
+	storage = NewStorage("file", path)
+	storage.New()
+	storage.SetBalance(testBalance)
+	assertT(storage.Load(block1.CloneConstants()) == 2, t)
+	block2 := mineTestBlock(block1, 250, testKeys[0], nil)
+	assertT(storage.Add(block2), t)
+	storage.Close()
+
+	initBalance()
+	storage = NewStorage("file", path)
+	storage.New()
+	storage.SetBalance(testBalance)
+	assertT(storage.Load(block1.CloneConstants()) == 3, t)
+	assertT(storage.GetTopBlock().Hash() == block2.Hash(), t)
+}
@@ -0,0 +1,144 @@
+package host
+
+import (
+	"sync"
+	"time"
+)
+
+// Inventory-based gossip:
+// 1. A node with a new block or transaction announces its hash to the neighbors (Inv).
+// 2. A neighbor filters out what it has or has already requested,
+//    and pulls the rest from the announcer (getdata).
+// 3. After checking them, it announces the hashes to its own neighbors.
+// Peers without FeatureInventory still get the full data pushed.
+
+// Inventory types ...
+const (
+	InventoryBlock       = "block"
+	InventoryTransaction = "transaction"
+)
+
+const (
+	// MaxInventorySize ...
+	// The most hashes in one announcement.
+	MaxInventorySize = 1000
+	// DefaultSeenCapacity ...
+	DefaultSeenCapacity = 10000
+	// DefaultSeenTTL ...
+	// Seconds to remember a hash.
+	DefaultSeenTTL = 600
+)
+
+// Inventory ...
+// Sender is the address to pull the data from.
+type Inventory struct {
+	Type   string
+	Hashes []string
+	Sender string
+}
+
+// SeenCache ...
+// Recently seen hashes, to suppress duplicates.
+// Steps:
+// 1. New(capacity, TTL)
+// 2. Add(hash)  Has(hash)  Remove(hash)
+type SeenCache interface {
+	New(capacity int, TTL int64)
+	Add(hash string) bool
+	Has(hash string) bool
+	Remove(hash string)
+	Len() int
+}
+
+// HippoSeenCache ...
+// HippoSeenCache is thread-safe. When it is full, the oldest hash is forgotten.
+type HippoSeenCache struct {
+	lock     sync.Mutex
+	capacity int
+	TTL      int64
+
+	seen map[string]int64
+	// order: hashes from old to new. It may keep removed hashes,
+	// which are skipped by comparing the time with seen.
+	order []seenEntry
+}
+
+type seenEntry struct {
+	hash string
+	time int64
+}
+
+// New ...
+func (c *HippoSeenCache) New(capacity int, TTL int64) {
+	c.capacity, c.TTL = capacity, TTL
+	c.seen = make(map[string]int64)
+	c.order = make([]seenEntry, 0)
+}
+
+// Add ...
+// Return true if hash has not been seen.
+func (c *HippoSeenCache) Add(hash string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now().Unix()
+	c.expireUnsafe(now)
+	if _, has := c.seen[hash]; has {
+		return false
+	}
+	for len(c.seen) >= c.capacity && c.capacity > 0 {
+		c.popUnsafe()
+	}
+	c.seen[hash] = now
+	c.order = append(c.order, seenEntry{hash: hash, time: now})
+	return true
+}
+
+// Has ...
+func (c *HippoSeenCache) Has(hash string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.expireUnsafe(time.Now().Unix())
+	_, has := c.seen[hash]
+	return has
+}
+
+// Remove ...
+// Forget hash, e.g. when it cannot be fetched.
+func (c *HippoSeenCache) Remove(hash string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.seen, hash)
+}
+
+// Len ...
+func (c *HippoSeenCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.seen)
+}
+
+// popUnsafe ...
+// Forget the oldest hash.
+func (c *HippoSeenCache) popUnsafe() {
+	for len(c.order) > 0 {
+		entry := c.order[0]
+		c.order = c.order[1:]
+		if t, has := c.seen[entry.hash]; has && t == entry.time {
+			delete(c.seen, entry.hash)
+			return
+		}
+	}
+}
+
+func (c *HippoSeenCache) expireUnsafe(now int64) {
+	if c.TTL <= 0 {
+		return
+	}
+	for len(c.order) > 0 && c.order[0].time < now-c.TTL {
+		entry := c.order[0]
+		c.order = c.order[1:]
+		if t, has := c.seen[entry.hash]; has && t == entry.time {
+			delete(c.seen, entry.hash)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package host
+
+import "testing"
+
+// testInvClient ...
+// Serve blocks by hash as the announcing peer does.
+type testInvClient struct {
+	NetworkClient
+	storage Storage
+	missing map[string]bool
+	known   map[string]bool
+}
+
+func (c *testInvClient) IsKnownNeighbor(address string) bool { return c.known[address] }
+
+func (c *testInvClient) QueryHashes(address string, hashes []string) []Block {
+	blocks := make([]Block, 0)
+	for _, h := range hashes {
+		if block, has := c.storage.Get(h); has && !c.missing[h] {
+			blocks = append(blocks, DecodeBlock(block.Encode(), block))
+		}
+	}
+	return blocks
+}
+
+func TestSeenCache(t *testing.T) {
+	cache := new(HippoSeenCache)
+	cache.New(2, DefaultSeenTTL)
+	assertT(cache.Add("a") && !cache.Add("a") && cache.Has("a"), t)
+	assertT(cache.Add("b") && cache.Add("c"), t)
+	assertT(!cache.Has("a") && cache.Has("b") && cache.Has("c") && cache.Len() == 2, t)
+	cache.Remove("b")
+	assertT(!cache.Has("b") && cache.Add("b"), t)
+}
+
+func TestGossipInventory(t *testing.T) {
+	initTest(1)
+	initBalance()
+	remote := newSyncTestStorage()
+	local := newSyncTestStorage()
+
+	var block Block
+	hashes := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		block = mineTestBlock(block, 250, testKeys[0], nil)
+		assertT(remote.Add(block), t)
+		hashes = append(hashes, block.Hash())
+	}
+	genesis, _ := remote.Get(hashes[0])
+	assertT(local.Add(genesis), t)
+
+	client := &testInvClient{storage: remote, missing: map[string]bool{hashes[2]: true},
+		known: map[string]bool{"a:1": true, "c:1": true}}
+	s := new(P2PServer)
+	s.setStorage(local)
+	s.setNetworkClient(client)
+	s.seen = new(HippoSeenCache)
+	s.seen.New(DefaultSeenCapacity, DefaultSeenTTL)
+
+	// Only the blocks not stored are pulled, and only once.
+	inv := Inventory{Type: InventoryBlock, Hashes: hashes, Sender: "a:1"}
+	wanted := s.filterInventory(inv)
+	assertT(len(wanted) == 2 && wanted[0] == hashes[1] && wanted[1] == hashes[2], t)
+	assertT(len(s.filterInventory(inv)) == 0, t)
+
+	// The missing one can be pulled again from the next announcer.
+	s.getData(InventoryBlock, wanted, "a:1")
+	assertT(local.Count() == 2, t)
+	delete(client.missing, hashes[2])
+	wanted = s.filterInventory(inv)
+	assertT(len(wanted) == 1 && wanted[0] == hashes[2], t)
+	s.getData(InventoryBlock, wanted, "b:1")
+	assertT(local.Count() == 3, t)
+
+	var reply string
+	oversized := Inventory{Type: InventoryBlock, Hashes: make([]string, MaxInventorySize+1)}
+	assertT(s.Inv(oversized, &reply) != nil, t)
+
+	// Data is pulled only from a known neighbor on the host of the connection.
+	assertT(s.pullSource("a", "a:1") == "a:1", t)
+	assertT(s.pullSource("c", "a:1") == "" && s.pullSource("a", "a:2") == "", t)
+	assertT(s.pullSource("", "a:1") == "", t)
+	forged := Inventory{Type: InventoryBlock, Hashes: []string{"ff"}, Sender: "a:1"}
+	assertT(s.inv("c", forged, &reply) == nil && reply == "unknown sender", t)
+	assertT(!s.seen.Has(InventoryBlock+"ff"), t)
+}
@@ -0,0 +1,114 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ProtocolVersion ...
+	ProtocolVersion = 4
+	// MinProtocolVersion ...
+	// The oldest version we can talk to.
+	MinProtocolVersion = 1
+
+	// DefaultChainID ...
+	DefaultChainID = "hippocoin"
+)
+
+// Features ...
+const (
+	FeatureHeadersFirst = "headers-first"
+	FeatureOrphanFetch  = "orphan-fetch"
+	// FeatureInventory ...
+	// Announce hashes with Inv instead of pushing the data. Since version 2.
+	FeatureInventory = "inventory"
+	// FeatureBinaryCodec ...
+	// Read version 1 of the binary codec. Since version 3.
+	FeatureBinaryCodec = "binary-codec"
+	// FeatureBinaryCodec2 ...
+	// Take version 2 of the binary codec, with the multisig witnesses, for broadcasts
+	// and GetBlocks. Since version 4. Peers with only FeatureBinaryCodec get JSON.
+	FeatureBinaryCodec2 = "binary-codec-2"
+)
+
+// SupportedFeatures ...
+var SupportedFeatures = []string{FeatureHeadersFirst, FeatureOrphanFetch, FeatureInventory,
+	FeatureBinaryCodec, FeatureBinaryCodec2}
+
+// HandshakeInfo ...
+// Exchanged by peers before they become neighbors.
+// Genesis is the fixed genesis block of the network, or else the genesis block of
+// the local main chain. It is empty while there is no block.
+// NodeID is the node ID of the TLS certificate, empty without TLS.
+type HandshakeInfo struct {
+	Version  int      `json:"version"`
+	ChainID  string   `json:"chainID"`
+	Genesis  string   `json:"genesis"`
+	Curve    string   `json:"curve"`
+	Height   int      `json:"height"`
+	Features []string `json:"features"`
+	Address  string   `json:"address"`
+	NodeID   string   `json:"nodeID"`
+}
+
+// HandshakeFunc ...
+// Return the local HandshakeInfo.
+type HandshakeFunc func() HandshakeInfo
+
+// CheckHandshake ...
+// Return the reason if remote cannot be a peer of local.
+// A newer peer is expected to talk to older versions down to MinProtocolVersion.
+// Another genesis block is refused once both peers have built on theirs: a peer
+// with only its genesis block can still switch to the chain with more work.
+func CheckHandshake(local, remote HandshakeInfo) error {
+	switch {
+	case remote.Version < MinProtocolVersion:
+		return fmt.Errorf("protocol version %d is too old", remote.Version)
+	case remote.ChainID != local.ChainID:
+		return fmt.Errorf("chain ID %q, expected %q", remote.ChainID, local.ChainID)
+	case remote.Curve != local.Curve:
+		return fmt.Errorf("curve %s, expected %s", remote.Curve, local.Curve)
+	case local.Genesis != "" && remote.Genesis != "" && remote.Genesis != local.Genesis &&
+		local.Height > 0 && remote.Height > 0:
+		return fmt.Errorf("genesis %s, expected %s", remote.Genesis, local.Genesis)
+	}
+	return nil
+}
+
+// CommonFeatures ...
+// The features both sides support.
+func CommonFeatures(local, remote HandshakeInfo) []string {
+	features := make([]string, 0)
+	for _, a := range local.Features {
+		for _, b := range remote.Features {
+			if a == b {
+				features = append(features, a)
+				break
+			}
+		}
+	}
+	return features
+}
+
+// HasFeature ...
+func (info HandshakeInfo) HasFeature(feature string) bool {
+	for _, f := range info.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoHandshake ...
+// The handshake of a peer without the Handshake RPC. Its chain ID, curve and
+// genesis cannot be checked, so it is refused.
+var ErrNoHandshake = errors.New("no handshake")
+
+// isMissingMethod ...
+// Whether err is the reply of a server without the method called.
+func isMissingMethod(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't find method")
+}
@@ -0,0 +1,70 @@
+package host
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	local := HandshakeInfo{
+		Version:  ProtocolVersion,
+		ChainID:  DefaultChainID,
+		Curve:    "P-224",
+		Features: SupportedFeatures,
+	}
+	remote := local
+	remote.Genesis = "abcd"
+	remote.Features = []string{FeatureOrphanFetch, "unknown"}
+	assertT(CheckHandshake(local, remote) == nil, t)
+
+	features := CommonFeatures(local, remote)
+	assertT(len(features) == 1 && features[0] == FeatureOrphanFetch, t)
+	info := HandshakeInfo{Features: features}
+	assertT(info.HasFeature(FeatureOrphanFetch) && !info.HasFeature(FeatureHeadersFirst), t)
+
+	old := remote
+	old.Version = MinProtocolVersion - 1
+	assertT(CheckHandshake(local, old) != nil, t)
+
+	otherChain := remote
+	otherChain.ChainID = "testnet"
+	assertT(CheckHandshake(local, otherChain) != nil, t)
+
+	otherCurve := remote
+	otherCurve.Curve = "P-256"
+	assertT(CheckHandshake(local, otherCurve) != nil, t)
+
+	// Another genesis is refused once both peers have built on theirs.
+	local.Genesis, local.Height, remote.Height = "1234", 5, 0
+	assertT(CheckHandshake(local, remote) == nil, t)
+	remote.Height = 3
+	assertT(CheckHandshake(local, remote) != nil, t)
+	remote.Genesis = "1234"
+	assertT(CheckHandshake(local, remote) == nil, t)
+	remote.Genesis = ""
+	assertT(CheckHandshake(local, remote) == nil, t)
+}
+
+func TestHandshakeLegacy(t *testing.T) {
+	initTest(0)
+	// A server without the Handshake RPC.
+	_, listener := newTestSlowServer(t)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := new(HippoNetworkClient)
+	client.New(ctx, "127.0.0.1:1", ProtocolTCP, 1, nil, 1, 1, new(P2PClient), nil)
+	client.SetTransport(new(TCPTransport))
+	client.SetHandshake(func() HandshakeInfo {
+		return HandshakeInfo{Version: ProtocolVersion, ChainID: DefaultChainID,
+			Curve: "P-224", Features: SupportedFeatures}
+	})
+
+	// It is refused rather than assumed to be on the local chain.
+	_, err := client.Handshake(listener.Addr().String())
+	assertT(err == ErrNoHandshake, t)
+	_, ok := client.GetPeerInfo(listener.Addr().String())
+	assertT(!ok, t)
+	assertT(client.GetRejectedPeers()[listener.Addr().String()] == ErrNoHandshake.Error(), t)
+}
@@ -2,8 +2,12 @@ package host
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
 	"sync"
 
 	"github.com/withmandala/go-log"
@@ -14,6 +18,20 @@ import (
 // Host ...
 type Host interface {
 	New(debug bool, debugFile string, infoFile string, curve elliptic.Curve, localMode bool)
+	SetStorageConfig(backend string, path string)
+	SetTimestampConfig(medianWindow int, blockFutureDrift,
+		transactionFutureDrift, transactionMaxAge int64)
+	SetChainConfig(chainID string, genesisHash string)
+	SetHeaderDifficulty(headerDifficulty HeaderDifficultyFunc)
+	SetPeerConfig(seedPeers []string, addressBookPath string)
+	SetBanDuration(seconds int64)
+	SetNodeKeyPath(path string)
+	SetNodePinsPath(path string)
+	SetPoolConfig(config PoolConfig)
+	SetMaxInbound(n int)
+	SetExternalAddress(address string)
+	SetServerLimits(limits ServerLimits)
+	SetKeyFile(path string, password string) error
 
 	Run()
 	InitLogger(debug bool)
@@ -54,6 +72,15 @@ type Host interface {
 	LoadPrivateKeyString(priString string) error
 
 	GetBalance() map[string]uint64
+	GetNonce(address string) uint64
+	GetMainChain() []Block
+	GetSupply() (supply uint64, maxSupply uint64)
+	GetSyncProgress() SyncProgress
+	GetRejectedPeers() map[string]string
+	GetKnownPeers() []AddressEntry
+	GetBannedPeers() []BannedPeer
+	GetPoolStats() []PeerPoolStats
+	GetInboundPeers() []string
 	GetHashFunction() HashFunction
 	GetCurve() elliptic.Curve
 
@@ -91,6 +118,18 @@ type HippoHost struct {
 
 	registerAddress  string
 	registerProtocol string
+	seedPeers        []string
+	addressBookPath  string
+	addressBook      AddressBook
+	banDuration      int64
+	peerScores       PeerScores
+	nodeKeyPath      string
+	nodePinsPath     string
+	transport        Transport
+	poolConfig       PoolConfig
+	maxInbound       int
+	externalAddress  string
+	serverLimits     ServerLimits
 
 	waitGroup sync.WaitGroup
 
@@ -99,6 +138,12 @@ type HippoHost struct {
 	miningQueue         MiningQueue
 	transactionPool     TransactionPool
 	storage             Storage
+	storageBackend      string
+	storagePath         string
+	timestampConfig     timestampConfig
+	chainID             string
+	genesisHash         string
+	headerDifficulty    HeaderDifficultyFunc
 	broadcastQueue      BroadcastQueue
 	blockTemplate       Block
 	transactionTemplate Transaction
@@ -116,6 +161,27 @@ func (host *HippoHost) InitKey(curve elliptic.Curve) {
 	infoLogger.Info("key:", host.key.ToAddress())
 }
 
+// SetKeyFile ...
+// Use the key of the keystore at path instead of a new key on every start.
+// The keystore is created with a new key if it does not exist. Call it after New.
+func (host *HippoHost) SetKeyFile(path string, password string) error {
+	key, err := LoadKeystore(path, password)
+	if os.IsNotExist(err) {
+		infoLogger.Info("key file: create", path)
+		key, err = CreateKeystore(path, host.curve, password)
+	}
+	if err != nil {
+		return err
+	}
+	if key.Curve != host.curve {
+		return fmt.Errorf("key file: curve %s, expected %s",
+			key.Curve.Params().Name, host.curve.Params().Name)
+	}
+	host.key.SetKey(key)
+	infoLogger.Info("key:", host.key.ToAddress())
+	return nil
+}
+
 // InitLogger ...
 func (host *HippoHost) InitLogger(debug bool) {
 	initLogger(host.debugFile, host.infoFile)
@@ -155,9 +221,14 @@ func (host *HippoHost) InitLocals(
 	host.balance = new(HippoBalance)
 	host.balance.New()
 
-	host.storage = new(HippoStorage)
+	host.storage = NewStorage(host.storageBackend, host.storagePath)
 	host.storage.New()
 	host.storage.SetBalance(host.balance)
+	host.storage.SetDifficulty(difficultyFunction, host.miningInterval)
+	host.storage.SetHeaderDifficulty(host.headerDifficulty)
+	host.storage.SetGenesis(host.genesisHash)
+	host.storage.SetTimestampRules(host.timestampConfig.medianWindow,
+		host.timestampConfig.blockFutureDrift)
 
 	host.P2PClientTemplate = p2pClientTemplate
 	host.broadcastQueue = new(HippoBroadcastQueue)
@@ -175,6 +246,8 @@ func (host *HippoHost) InitLocals(
 
 	host.transactionPool = new(HippoTransactionPool)
 	host.transactionPool.New(host.balance, host.broadcastQueue)
+	host.transactionPool.SetTimestampRules(host.timestampConfig.transactionFutureDrift,
+		host.timestampConfig.transactionMaxAge)
 	host.mining.New(&host.miningQueue, host.transactionPool,
 		difficultyFunction, host.miningInterval, miningCapacity, miningTTL,
 		host.balance, host.key)
@@ -206,16 +279,30 @@ func (host *HippoHost) InitNetwork(
 	host.transactionTemplate = transactionTemplate
 	host.transactionTemplate.New(host.hashFunction, host.curve)
 
+	infoLogger.Info("storage: load blocks:", host.storage.Load(host.blockTemplate))
+
+	host.initTransport()
+
 	host.networkListener = new(HippoNetworkListener)
 	host.networkListener.New(host.ctx, host.IP, host.protocol)
 	host.networkListener.SetConfigPort(listenerPort)
+	host.networkListener.SetTransport(host.transport)
 	host.networkListener.Listen()
 
 	infoLogger.Info("listener: create")
 
 	host.address = host.networkListener.NetworkAddress()
+	if host.externalAddress != "" {
+		host.address = externalAddress(host.externalAddress, host.address)
+	}
 	infoLogger.Info("listener:", host.address)
 
+	if host.banDuration <= 0 {
+		host.banDuration = DefaultBanDuration
+	}
+	host.peerScores = new(HippoPeerScores)
+	host.peerScores.New(DefaultBanThreshold, host.banDuration)
+
 	host.P2PServer = new(P2PServer)
 	host.P2PServer.new(host.ctx, host.networkListener.Listener())
 	host.P2PServer.setBroadcastQueue(host.broadcastQueue)
@@ -223,23 +310,108 @@ func (host *HippoHost) InitNetwork(
 	host.P2PServer.setBlockTemplate(host.blockTemplate)
 	host.P2PServer.setTransactionTemplate(host.transactionTemplate)
 	host.P2PServer.setTransactionPool(host.transactionPool)
+	host.P2PServer.setHandshake(host.localHandshake)
+	host.P2PServer.setTransport(host.transport)
+	host.P2PServer.setPeers(host.knownNeighbors)
+	host.P2PServer.setPeerScores(host.peerScores)
+	host.P2PServer.setLimits(host.serverLimits)
 	host.P2PServer.serve()
 
 	host.registerAddress = registerAddress
 	host.registerProtocol = registerProtocol
-	host.register = new(HippoRegister)
-	host.register.New(host.ctx, host.registerAddress, host.registerProtocol)
+	// The register is optional: without it, peers come from the seeds,
+	// the address book and the peer exchange.
+	var register Register
+	if host.registerAddress != "" {
+		host.register = new(HippoRegister)
+		host.register.New(host.ctx, host.registerAddress, host.registerProtocol)
+		register = host.register
+		infoLogger.Info("register: create")
+	}
 
-	infoLogger.Info("register: create")
+	host.addressBook = new(HippoAddressBook)
+	host.addressBook.New(host.addressBookPath, DefaultAddressBookCapacity)
+	host.addressBook.Load()
 
 	host.networkClient = new(HippoNetworkClient)
 	host.networkClient.New(host.ctx, host.address, host.protocol,
-		maxNeighbors, host.register, updateTimeBase,
+		maxNeighbors, register, updateTimeBase,
 		updateTimeRand, host.P2PClientTemplate, host.blockTemplate)
+	if len(host.seedPeers) > 0 {
+		host.networkClient.AddDiscovery(&SeedDiscovery{Seeds: host.seedPeers})
+	}
+	host.networkClient.SetAddressBook(host.addressBook)
+	host.networkClient.SetPeerScores(host.peerScores)
+	host.networkClient.SetTransport(host.transport)
+	host.networkClient.SetPoolConfig(host.poolConfig)
+	if host.maxInbound > 0 {
+		host.networkClient.SetMaxInbound(host.maxInbound)
+	}
+	host.peerScores.SetBanHandler(host.networkClient.DisconnectPeer)
+	host.networkClient.SetHandshake(host.localHandshake)
 	host.broadcastQueue.SetNetworkClient(host.networkClient)
+	host.P2PServer.setNetworkClient(host.networkClient)
+	host.storage.SetOrphanHandler(host.fetchParent)
 	infoLogger.Info("network client: created")
 }
 
+// localHandshake ...
+func (host *HippoHost) localHandshake() HandshakeInfo {
+	height := -1
+	if top := host.storage.GetTopBlock(); top != nil {
+		height = top.GetLevel()
+	}
+	genesis := host.genesisHash
+	if locator := host.storage.GetLocator(); genesis == "" && len(locator) > 0 {
+		genesis = locator[len(locator)-1]
+	}
+	nodeID := ""
+	if host.transport != nil {
+		nodeID = host.transport.NodeID()
+	}
+	return HandshakeInfo{
+		Version:  ProtocolVersion,
+		ChainID:  host.chainID,
+		Genesis:  genesis,
+		Curve:    host.curve.Params().Name,
+		Height:   height,
+		Features: SupportedFeatures,
+		Address:  host.address,
+		NodeID:   nodeID,
+	}
+}
+
+// knownNeighbors ...
+// The neighbors shared with other peers.
+func (host *HippoHost) knownNeighbors() []string {
+	if host.networkClient == nil {
+		return nil
+	}
+	return host.networkClient.GetNeighbors()
+}
+
+// fetchParent ...
+// Fetch the parent of an orphan block from its sender if it is a known neighbor,
+// or else from the other neighbors.
+func (host *HippoHost) fetchParent(parentHash string, sender string) {
+	addresses := host.networkClient.GetNeighbors()
+	if sender != "" && host.networkClient.IsKnownNeighbor(sender) {
+		addresses = append([]string{sender}, addresses...)
+	}
+	for _, address := range addresses {
+		if address == host.address {
+			continue
+		}
+		if block := host.networkClient.QueryByHash(address, parentHash); block != nil &&
+			block.Hash() == parentHash {
+			infoLogger.Info("fetch parent:", parentHash, "from", address)
+			host.storage.AddFrom(block, address)
+			return
+		}
+	}
+	infoLogger.Warn("fetch parent failed:", parentHash)
+}
+
 // Run ...
 // Use `go host.Run()`
 func (host *HippoHost) Run() {
@@ -251,9 +423,12 @@ func (host *HippoHost) Run() {
 
 	host.networkClient.StartSyncBlocks(host.storage)
 
-	genesisBlock := CreateGenesisBlock(host.hashFunction,
-		host.curve, host.key)
-	host.mining.Mine(&genesisBlock)
+	// With a fixed genesis block, it comes from the peers.
+	if host.genesisHash == "" {
+		genesisBlock := CreateGenesisBlock(host.hashFunction,
+			host.curve, host.key)
+		host.mining.Mine(&genesisBlock)
+	}
 
 	go watchStorageBalance(host.storage, host.balance,
 		20)
@@ -271,10 +446,141 @@ func (host *HippoHost) New(debug bool, debugFile string,
 	host.infoFile = infoFile
 }
 
+// SetStorageConfig ...
+// Call it before InitLocals.
+func (host *HippoHost) SetStorageConfig(backend string, path string) {
+	host.storageBackend, host.storagePath = backend, path
+}
+
+type timestampConfig struct {
+	medianWindow           int
+	blockFutureDrift       int64
+	transactionFutureDrift int64
+	transactionMaxAge      int64
+}
+
+// SetTimestampConfig ...
+// Call it before InitLocals.
+func (host *HippoHost) SetTimestampConfig(medianWindow int, blockFutureDrift,
+	transactionFutureDrift, transactionMaxAge int64) {
+	host.timestampConfig = timestampConfig{
+		medianWindow:           medianWindow,
+		blockFutureDrift:       blockFutureDrift,
+		transactionFutureDrift: transactionFutureDrift,
+		transactionMaxAge:      transactionMaxAge,
+	}
+}
+
+// SetChainConfig ...
+// Peers with another chainID, or another genesis block, are refused.
+// Call it before InitLocals.
+func (host *HippoHost) SetChainConfig(chainID string, genesisHash string) {
+	if chainID == "" {
+		chainID = DefaultChainID
+	}
+	host.chainID, host.genesisHash = chainID, genesisHash
+}
+
+// SetHeaderDifficulty ...
+// The difficulty rule given to InitLocals, on headers. The headers of a sync are checked by it.
+// Call it before InitLocals.
+func (host *HippoHost) SetHeaderDifficulty(headerDifficulty HeaderDifficultyFunc) {
+	host.headerDifficulty = headerDifficulty
+}
+
+// SetPeerConfig ...
+// seedPeers are always tried. Known peers are saved to addressBookPath,
+// or kept in memory if it is empty.
+// Call it before InitNetwork.
+func (host *HippoHost) SetPeerConfig(seedPeers []string, addressBookPath string) {
+	host.seedPeers, host.addressBookPath = seedPeers, addressBookPath
+}
+
+// SetBanDuration ...
+// Seconds to ban a misbehaving peer. Call it before InitNetwork.
+func (host *HippoHost) SetBanDuration(seconds int64) { host.banDuration = seconds }
+
+// SetNodeKeyPath ...
+// The node key authenticates the tls protocol. It is created if the file does not exist.
+// Call it before InitNetwork.
+func (host *HippoHost) SetNodeKeyPath(path string) { host.nodeKeyPath = path }
+
+// SetNodePinsPath ...
+// The node IDs of the tls peers, pinned on the first connection and saved there.
+// Expected node IDs can be written to the file beforehand. Call it before InitNetwork.
+func (host *HippoHost) SetNodePinsPath(path string) { host.nodePinsPath = path }
+
+// SetPoolConfig ...
+// Zero fields take the defaults. Call it before InitNetwork.
+func (host *HippoHost) SetPoolConfig(config PoolConfig) { host.poolConfig = config }
+
+// SetMaxInbound ...
+// The maximum number of peers that connect to us. Call it before InitNetwork.
+func (host *HippoHost) SetMaxInbound(n int) { host.maxInbound = n }
+
+// SetExternalAddress ...
+// Advertise address to peers instead of the outbound IP, e.g. behind a NAT
+// with port forwarding. Without a port, the listener port is taken.
+// Call it before InitNetwork.
+func (host *HippoHost) SetExternalAddress(address string) { host.externalAddress = address }
+
+// SetServerLimits ...
+// The request size and rate limits of the P2P server. Zero fields take the defaults.
+// Call it before InitNetwork.
+func (host *HippoHost) SetServerLimits(limits ServerLimits) { host.serverLimits = limits }
+
+// externalAddress ...
+func externalAddress(external, listenAddress string) string {
+	if _, _, err := net.SplitHostPort(external); err == nil {
+		return external
+	}
+	_, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return external
+	}
+	return net.JoinHostPort(external, port)
+}
+
+// initTransport ...
+func (host *HippoHost) initTransport() {
+	var (
+		nodeKey *ecdsa.PrivateKey
+		err     error
+	)
+	if host.protocol == ProtocolTLS {
+		if host.nodeKeyPath == "" {
+			host.nodeKeyPath = DefaultNodeKeyPath
+		}
+		if nodeKey, err = LoadNodeKey(host.nodeKeyPath); err != nil {
+			infoLogger.Fatal("node key:", err)
+		}
+	}
+	if host.transport, err = NewTransport(host.protocol, nodeKey); err != nil {
+		infoLogger.Fatal("transport:", err)
+	}
+	if tlsTransport, ok := host.transport.(*TLSTransport); ok {
+		if host.nodePinsPath == "" {
+			host.nodePinsPath = DefaultNodePinsPath
+		}
+		if err = tlsTransport.SetPinsPath(host.nodePinsPath); err != nil {
+			infoLogger.Fatal("node pins:", err)
+		}
+	}
+	infoLogger.Info("transport:", host.transport.Protocol(), "node ID:", host.transport.NodeID())
+}
+
 // Close ...
 func (host *HippoHost) Close() {
 	infoLogger.Info("host: closed")
 	host.cancel()
+	if host.addressBook != nil {
+		if err := host.addressBook.Save(); err != nil {
+			infoLogger.Error("address book: save:", err)
+		}
+	}
+	if host.storage != nil {
+		host.storage.Close()
+	}
 }
 
 // Hash ...
@@ -318,6 +624,71 @@ func (host *HippoHost) GetBalance() map[string]uint64 {
 	return host.balance.AllBalance()
 }
 
+// GetSupply ...
+// The coins issued on the main chain, and the cap of the reward schedule.
+func (host *HippoHost) GetSupply() (supply uint64, maxSupply uint64) {
+	for _, value := range host.balance.AllBalance() {
+		supply += value
+	}
+	return supply, GetRewardSchedule().MaxSupply
+}
+
+// GetSyncProgress ...
+func (host *HippoHost) GetSyncProgress() SyncProgress {
+	return host.networkClient.GetSyncProgress()
+}
+
+// GetRejectedPeers ...
+// Peers refused by the handshake and the reasons.
+func (host *HippoHost) GetRejectedPeers() map[string]string {
+	return host.networkClient.GetRejectedPeers()
+}
+
+// GetKnownPeers ...
+func (host *HippoHost) GetKnownPeers() []AddressEntry {
+	if host.addressBook == nil {
+		return nil
+	}
+	return host.addressBook.Entries()
+}
+
+// GetBannedPeers ...
+func (host *HippoHost) GetBannedPeers() []BannedPeer {
+	if host.peerScores == nil {
+		return nil
+	}
+	return host.peerScores.GetBanned()
+}
+
+// GetPoolStats ...
+// The connections to peers.
+func (host *HippoHost) GetPoolStats() []PeerPoolStats {
+	if host.networkClient == nil {
+		return nil
+	}
+	return host.networkClient.GetPoolStats()
+}
+
+// GetInboundPeers ...
+func (host *HippoHost) GetInboundPeers() []string {
+	if host.networkClient == nil {
+		return nil
+	}
+	return host.networkClient.GetInboundNeighbors()
+}
+
+// GetNonce ...
+// The nonce for the next transaction sent by address.
+func (host *HippoHost) GetNonce(address string) uint64 {
+	return host.transactionPool.NextNonce(address)
+}
+
+// GetMainChain ...
+// The blocks of the main chain from the genesis block.
+func (host *HippoHost) GetMainChain() []Block {
+	return host.storage.GetMainChain()
+}
+
 // GetLoggers ...
 func (host *HippoHost) GetLoggers() (*log.Logger, *log.Logger) {
 	return debugLogger, infoLogger
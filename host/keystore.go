@@ -0,0 +1,297 @@
+package host
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keystore files keep a private key encrypted by a password:
+// - The password is stretched by scrypt with a random salt into an AES-256 key.
+// - The DER private key is sealed by AES-GCM with a random nonce.
+// - The address is kept in clear, so that a keystore can be listed without the password.
+// Files are written with mode 0600.
+
+// KeystoreVersion ...
+const KeystoreVersion = 1
+
+const (
+	keystoreKDF    = "scrypt"
+	keystoreCipher = "aes-256-gcm"
+	keystoreKeyLen = 32
+)
+
+// ScryptParams ...
+type ScryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// DefaultScryptParams ...
+// About 100ms and 32MB on a laptop.
+var DefaultScryptParams = ScryptParams{N: 1 << 15, R: 8, P: 1}
+
+var (
+	// ErrWrongPassword ...
+	ErrWrongPassword = errors.New("keystore: wrong password")
+	// ErrKeystoreExists ...
+	ErrKeystoreExists = errors.New("keystore: file exists")
+	// ErrEmptyPassword ...
+	// New keystores are not encrypted by the empty password.
+	ErrEmptyPassword = errors.New("keystore: a password is required")
+)
+
+// EncryptedData ...
+// Data sealed by a password.
+type EncryptedData struct {
+	KDF        string       `json:"kdf"`
+	KDFParams  ScryptParams `json:"kdfparams"`
+	Salt       string       `json:"salt"`
+	Cipher     string       `json:"cipher"`
+	Nonce      string       `json:"nonce"`
+	Ciphertext string       `json:"ciphertext"`
+}
+
+// KeystoreFile ...
+type KeystoreFile struct {
+	Version int    `json:"version"`
+	Address string `json:"address"`
+	EncryptedData
+}
+
+// EncryptData ...
+// additionalData is not encrypted, but authenticated with data.
+func EncryptData(data []byte, additionalData []byte, password string,
+	params ScryptParams) (EncryptedData, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return EncryptedData{}, err
+	}
+	aead, err := keystoreAEAD(password, salt, params)
+	if err != nil {
+		return EncryptedData{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return EncryptedData{}, err
+	}
+	return EncryptedData{
+		KDF:        keystoreKDF,
+		KDFParams:  params,
+		Salt:       hex.EncodeToString(salt),
+		Cipher:     keystoreCipher,
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, data, additionalData)),
+	}, nil
+}
+
+// DecryptData ...
+// Return ErrWrongPassword if the password does not open the data.
+func DecryptData(d EncryptedData, additionalData []byte, password string) ([]byte, error) {
+	if d.KDF != keystoreKDF || d.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("keystore: unsupported %s, %s", d.KDF, d.Cipher)
+	}
+	salt, err := hex.DecodeString(d.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(d.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := hex.DecodeString(d.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keystoreAEAD(password, salt, d.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("keystore: bad nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return plaintext, nil
+}
+
+// EncryptKey ...
+func EncryptKey(key *ecdsa.PrivateKey, password string, params ScryptParams) (KeystoreFile, error) {
+	if key == nil {
+		return KeystoreFile{}, errors.New("keystore: no key")
+	}
+	address := publicKeyToString(key.PublicKey)
+	data, err := EncryptData(keyToByte(key), []byte(address), password, params)
+	if err != nil {
+		return KeystoreFile{}, err
+	}
+	return KeystoreFile{Version: KeystoreVersion, Address: address, EncryptedData: data}, nil
+}
+
+// DecryptKey ...
+// Return ErrWrongPassword if the password does not open the file.
+func DecryptKey(file KeystoreFile, password string) (*ecdsa.PrivateKey, error) {
+	if file.Version != KeystoreVersion {
+		return nil, fmt.Errorf("keystore: unsupported version %d", file.Version)
+	}
+	plaintext, err := DecryptData(file.EncryptedData, []byte(file.Address), password)
+	if err != nil {
+		return nil, err
+	}
+	return byteToKey(plaintext)
+}
+
+func keystoreAEAD(password string, salt []byte, params ScryptParams) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(password), salt, params.N, params.R, params.P, keystoreKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReadKeystore ...
+func ReadKeystore(path string) (file KeystoreFile, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return file, err
+	}
+	err = json.Unmarshal(data, &file)
+	return file, err
+}
+
+// WriteKeystore ...
+func WriteKeystore(path string, file KeystoreFile) error {
+	return WriteSecretFile(path, file)
+}
+
+// WriteSecretFile ...
+// Write v as JSON with mode 0600. Replace path atomically, so that a crash never
+// leaves a broken file.
+func WriteSecretFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadKeystore ...
+func LoadKeystore(path string, password string) (*ecdsa.PrivateKey, error) {
+	file, err := ReadKeystore(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptKey(file, password)
+}
+
+// SaveKeystore ...
+// Encrypt key with the default scrypt parameters and write it to path.
+func SaveKeystore(path string, key *ecdsa.PrivateKey, password string) error {
+	file, err := EncryptKey(key, password, DefaultScryptParams)
+	if err != nil {
+		return err
+	}
+	return WriteKeystore(path, file)
+}
+
+// CreateKeystore ...
+// Generate a key of curve and save it at path. An existing file is not overwritten,
+// and the password cannot be empty.
+func CreateKeystore(path string, curve elliptic.Curve, password string) (*ecdsa.PrivateKey, error) {
+	if password == "" {
+		return nil, ErrEmptyPassword
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrKeystoreExists
+	}
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return key, SaveKeystore(path, key, password)
+}
+
+// ImportKeystore ...
+// Create a keystore at path from a hex private key, as shown by the web client.
+// An existing file is not overwritten, and the password cannot be empty.
+func ImportKeystore(path string, priString string, password string) (*ecdsa.PrivateKey, error) {
+	if password == "" {
+		return nil, ErrEmptyPassword
+	}
+	key, err := HexStringToKey(priString)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(path); err == nil {
+		return nil, ErrKeystoreExists
+	}
+	return key, SaveKeystore(path, key, password)
+}
+
+// ExportKeystore ...
+// Return the hex private key of the keystore at path.
+func ExportKeystore(path string, password string) (string, error) {
+	key, err := LoadKeystore(path, password)
+	if err != nil {
+		return "", err
+	}
+	return KeyToHexString(key), nil
+}
+
+// ChangeKeystorePassword ...
+// Encrypt the key again with a new salt and nonce.
+func ChangeKeystorePassword(path string, oldPassword string, newPassword string) error {
+	if newPassword == "" {
+		return ErrEmptyPassword
+	}
+	file, err := ReadKeystore(path)
+	if err != nil {
+		return err
+	}
+	key, err := DecryptKey(file, oldPassword)
+	if err != nil {
+		return err
+	}
+	file, err = EncryptKey(key, newPassword, file.KDFParams)
+	if err != nil {
+		return err
+	}
+	return WriteKeystore(path, file)
+}
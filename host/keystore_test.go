@@ -0,0 +1,79 @@
+package host
+
+import (
+	"crypto/elliptic"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	initTest(0)
+	defer func(params ScryptParams) { DefaultScryptParams = params }(DefaultScryptParams)
+	DefaultScryptParams = ScryptParams{N: 1 << 10, R: 8, P: 1}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys", "key.json")
+
+	_, err := CreateKeystore(path, elliptic.P224(), "")
+	assertT(err == ErrEmptyPassword, t)
+	key, err := CreateKeystore(path, elliptic.P224(), "secret")
+	assertT(err == nil, t)
+	info, err := os.Stat(path)
+	assertT(err == nil && info.Mode().Perm() == 0600, t)
+	_, err = CreateKeystore(path, elliptic.P224(), "secret")
+	assertT(err == ErrKeystoreExists, t)
+
+	loaded, err := LoadKeystore(path, "secret")
+	assertT(err == nil && loaded.D.Cmp(key.D) == 0 && loaded.Curve == elliptic.P224(), t)
+	_, err = LoadKeystore(path, "wrong")
+	assertT(err == ErrWrongPassword, t)
+	file, err := ReadKeystore(path)
+	assertT(err == nil && file.Address == publicKeyToString(key.PublicKey), t)
+
+	// The address is authenticated with the key.
+	file.Address = publicKeyToString(GenerateKey(elliptic.P224()).PublicKey)
+	_, err = DecryptKey(file, "secret")
+	assertT(err == ErrWrongPassword, t)
+
+	assertT(ChangeKeystorePassword(path, "wrong", "new") == ErrWrongPassword, t)
+	assertT(ChangeKeystorePassword(path, "secret", "") == ErrEmptyPassword, t)
+	assertT(ChangeKeystorePassword(path, "secret", "new") == nil, t)
+	_, err = LoadKeystore(path, "secret")
+	assertT(err == ErrWrongPassword, t)
+	priString, err := ExportKeystore(path, "new")
+	assertT(err == nil && priString == KeyToHexString(key), t)
+
+	imported := filepath.Join(dir, "imported.json")
+	_, err = ImportKeystore(imported, priString, "other")
+	assertT(err == nil, t)
+	loaded, err = LoadKeystore(imported, "other")
+	assertT(err == nil && loaded.D.Cmp(key.D) == 0, t)
+	_, err = ImportKeystore(imported, priString, "other")
+	assertT(err == ErrKeystoreExists, t)
+}
+
+func TestHostKeyFile(t *testing.T) {
+	initTest(0)
+	defer func(params ScryptParams) { DefaultScryptParams = params }(DefaultScryptParams)
+	DefaultScryptParams = ScryptParams{N: 1 << 10, R: 8, P: 1}
+	path := filepath.Join(t.TempDir(), "key.json")
+
+	// The key is created on the first start and kept on the next ones,
+	// but not without a password.
+	host := new(HippoHost)
+	host.InitKey(elliptic.P224())
+	assertT(host.SetKeyFile(path, "") == ErrEmptyPassword, t)
+	_, err := os.Stat(path)
+	assertT(os.IsNotExist(err), t)
+	assertT(host.SetKeyFile(path, "secret") == nil, t)
+	address := host.PublicKey()
+	restarted := new(HippoHost)
+	restarted.InitKey(elliptic.P224())
+	assertT(restarted.PublicKey() != address, t)
+	assertT(restarted.SetKeyFile(path, "secret") == nil && restarted.PublicKey() == address, t)
+
+	assertT(restarted.SetKeyFile(path, "wrong") == ErrWrongPassword, t)
+	other := new(HippoHost)
+	other.InitKey(elliptic.P256())
+	assertT(other.SetKeyFile(path, "secret") != nil, t)
+}
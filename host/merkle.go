@@ -0,0 +1,254 @@
+package host
+
+import "math/big"
+
+// Merkle tree over the transactions of a block.
+// Leaves and inner nodes are hashed with different prefixes, and an odd node
+// is promoted to the next level as it is, so that no two transaction lists share a root.
+
+const (
+	merkleLeafPrefix  = 0
+	merkleInnerPrefix = 1
+)
+
+// MerkleProof ...
+// Siblings are hex strings from the leaf level up to the root.
+// Count is the number of leaves, which tells where odd nodes are promoted.
+type MerkleProof struct {
+	Index    int      `json:"index"`
+	Count    int      `json:"count"`
+	Siblings []string `json:"siblings"`
+}
+
+func merkleLeaf(leaf []byte, hashFunction HashFunction) []byte {
+	return hashFunction(append([]byte{merkleLeafPrefix}, leaf...))
+}
+
+func merkleInner(left, right []byte, hashFunction HashFunction) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleInnerPrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	return hashFunction(data)
+}
+
+// merkleLevels ...
+// Return all levels of the tree, from the hashed leaves to the root.
+func merkleLevels(leaves [][]byte, hashFunction HashFunction) [][][]byte {
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf, hashFunction)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleInner(level[i], level[i+1], hashFunction))
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleRoot ...
+// The root of an empty list is the hash of nothing.
+func MerkleRoot(leaves [][]byte, hashFunction HashFunction) []byte {
+	if len(leaves) == 0 {
+		return hashFunction([]byte{})
+	}
+	levels := merkleLevels(leaves, hashFunction)
+	return levels[len(levels)-1][0]
+}
+
+// BuildMerkleProof ...
+// Build the inclusion proof of leaves[index].
+func BuildMerkleProof(leaves [][]byte, index int, hashFunction HashFunction) (MerkleProof, bool) {
+	if index < 0 || index >= len(leaves) {
+		return MerkleProof{}, false
+	}
+	proof := MerkleProof{
+		Index:    index,
+		Count:    len(leaves),
+		Siblings: make([]string, 0),
+	}
+	levels := merkleLevels(leaves, hashFunction)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, ByteToHexString(level[sibling]))
+		}
+		index /= 2
+	}
+	return proof, true
+}
+
+// VerifyMerkleProof ...
+// Check that leaf is at proof.Index of a tree with the given root.
+func VerifyMerkleProof(leaf []byte, proof MerkleProof, root []byte,
+	hashFunction HashFunction) bool {
+	if proof.Index < 0 || proof.Index >= proof.Count {
+		return false
+	}
+	var (
+		node     = merkleLeaf(leaf, hashFunction)
+		index    = proof.Index
+		count    = proof.Count
+		siblings = proof.Siblings
+	)
+	for count > 1 {
+		sibling := index ^ 1
+		if sibling < count {
+			if len(siblings) == 0 {
+				return false
+			}
+			siblingBytes, err := StringToByte(siblings[0])
+			if err != nil {
+				return false
+			}
+			siblings = siblings[1:]
+			if index%2 == 0 {
+				node = merkleInner(node, siblingBytes, hashFunction)
+			} else {
+				node = merkleInner(siblingBytes, node, hashFunction)
+			}
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+	return len(siblings) == 0 && ByteToHexString(node) == ByteToHexString(root)
+}
+
+// TransactionProof ...
+// The proof that a transaction is included in a block.
+// Leaf is the transaction's HashSignatures.
+type TransactionProof struct {
+	TransactionHash string      `json:"transactionHash"`
+	Leaf            string      `json:"leaf"`
+	BlockHash       string      `json:"blockHash"`
+	Level           int         `json:"level"`
+	Proof           MerkleProof `json:"proof"`
+}
+
+// HeaderChainRules ...
+// What a header chain is trusted by.
+// Anchor is the hash of a trusted header, such as the genesis or a checkpoint.
+// The chain contains it, or starts right after it if Ancestor finds it.
+// The headers before the anchor are trusted through their links to it.
+// Every header after the anchor must have the NumBytes given by Difficulty,
+// the rule of full nodes with their BaseInterval, and together at least MinWork work.
+// Ancestor finds trusted headers before the chain, such as stored blocks. It can be nil.
+type HeaderChainRules struct {
+	Anchor       string
+	Difficulty   HeaderDifficultyFunc
+	BaseInterval int64
+	MinWork      *big.Int
+	Ancestor     func(hashKey string) (BlockHeader, bool)
+}
+
+// HeadersWork ...
+// The total work of headers.
+func HeadersWork(headers []BlockHeader) *big.Int {
+	work := big.NewInt(0)
+	for _, header := range headers {
+		work.Add(work, BlockWork(header.NumBytes))
+	}
+	return work
+}
+
+// VerifyHeaderChain ...
+// Check that headers link from one to the next, and that they contain or follow
+// the anchor of rules. The headers after it need a valid nonce and difficulty,
+// and at least the minimum work.
+func VerifyHeaderChain(headers []BlockHeader, rules HeaderChainRules,
+	hashFunction HashFunction) bool {
+	if len(headers) == 0 || rules.Anchor == "" || rules.Difficulty == nil {
+		debugLogger.Debug("header chain: no headers, anchor or difficulty rule")
+		return false
+	}
+	byHash := make(map[string]BlockHeader, len(headers))
+	anchor := -1
+	for i, header := range headers {
+		if i > 0 && (header.Level != headers[i-1].Level+1 ||
+			ByteToHexString(header.PreviousHash) != headers[i-1].Hash(hashFunction)) {
+			debugLogger.Debug("header chain: broken link:", i)
+			return false
+		}
+		h := header.Hash(hashFunction)
+		byHash[h] = header
+		if h == rules.Anchor {
+			anchor = i
+		}
+	}
+	ancestor := func(hashKey string) (BlockHeader, bool) {
+		if header, has := byHash[hashKey]; has {
+			return header, true
+		}
+		if rules.Ancestor == nil {
+			return BlockHeader{}, false
+		}
+		return rules.Ancestor(hashKey)
+	}
+
+	var parent BlockHeader
+	if anchor == -1 {
+		var has bool
+		parent, has = ancestor(rules.Anchor)
+		if !has || ByteToHexString(headers[0].PreviousHash) != rules.Anchor ||
+			headers[0].Level != parent.Level+1 {
+			debugLogger.Debug("header chain: not anchored at", rules.Anchor)
+			return false
+		}
+	}
+	for i := anchor + 1; i < len(headers); i++ {
+		header := headers[i]
+		if i > 0 {
+			parent = headers[i-1]
+		}
+		if !header.CheckNonce(hashFunction) {
+			debugLogger.Debug("header chain: nonce check failed:", i)
+			return false
+		}
+		expected, ok := rules.Difficulty(parent, ancestor, rules.BaseInterval)
+		if !ok || header.NumBytes != expected {
+			debugLogger.Debug("header chain: difficulty check failed:", i, header.NumBytes, expected)
+			return false
+		}
+	}
+	if rules.MinWork != nil && HeadersWork(headers[anchor+1:]).Cmp(rules.MinWork) < 0 {
+		debugLogger.Debug("header chain: not enough work")
+		return false
+	}
+	return true
+}
+
+// VerifyTransactionProof ...
+// For light clients: check that tr is included in one block of the header chain,
+// which is verified by rules.
+func VerifyTransactionProof(tr Transaction, proof TransactionProof,
+	headers []BlockHeader, rules HeaderChainRules, hashFunction HashFunction) bool {
+	if tr.HashSignatures() != proof.Leaf || tr.Hash() != proof.TransactionHash {
+		return false
+	}
+	if !VerifyHeaderChain(headers, rules, hashFunction) {
+		return false
+	}
+	for _, header := range headers {
+		if header.Level != proof.Level {
+			continue
+		}
+		if header.Hash(hashFunction) != proof.BlockHash {
+			return false
+		}
+		root, err := StringToByte(header.TransactionRoot)
+		if err != nil {
+			return false
+		}
+		return VerifyMerkleProof(tr.HashSignaturesBytes(), proof.Proof, root, hashFunction)
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package host
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	initTest(1)
+	infoLogger.Debug("test merkle proof ==============================")
+	for n := 1; n <= 9; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			leaves[i] = testHashfunction([]byte(fmt.Sprintf("leaf-%d", i)))
+		}
+		root := MerkleRoot(leaves, testHashfunction)
+		for i := range leaves {
+			proof, ok := BuildMerkleProof(leaves, i, testHashfunction)
+			assertT(ok, t)
+			assertT(VerifyMerkleProof(leaves[i], proof, root, testHashfunction), t)
+
+			// Wrong leaf or wrong position.
+			assertT(!VerifyMerkleProof(leaves[(i+1)%n], proof, root, testHashfunction) || n == 1, t)
+			proof.Index = (proof.Index + 1) % n
+			assertT(!VerifyMerkleProof(leaves[i], proof, root, testHashfunction) || n == 1, t)
+		}
+		_, ok := BuildMerkleProof(leaves, n, testHashfunction)
+		assertT(!ok, t)
+	}
+
+	// An odd node is promoted, not duplicated.
+	a, b, c := []byte{1}, []byte{2}, []byte{3}
+	assertT(ByteToHexString(MerkleRoot([][]byte{a, b, c}, testHashfunction)) !=
+		ByteToHexString(MerkleRoot([][]byte{a, b, c, c}, testHashfunction)), t)
+}
+
+func TestTransactionProof(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test transaction proof ==============================")
+	initBalance()
+	initStorage()
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	assertT(testStorage.Add(genesis), t)
+
+	trs := make([]Transaction, 3)
+	for i := range trs {
+		tr := new(HippoTransaction)
+		tr.New(testHashfunction, testCurve)
+		tr.SetSender([]string{testKeys[0].ToAddress()}, []uint64{uint64(i + 1)})
+		tr.SetNonces([]uint64{uint64(i)})
+		tr.SetReceiver([]string{testKeys[i].ToAddress()}, []uint64{uint64(i + 1)})
+		tr.UpdateFee()
+		tr.Sign(testKeys[0])
+		trs[i] = tr
+	}
+	block := mineTestBlock(genesis, 250, testKeys[1], trs)
+	assertT(block.CheckTransactionRoot(), t)
+	assertT(testStorage.Add(block), t)
+
+	headers := make([]BlockHeader, 0)
+	for _, b := range testStorage.GetMainChain() {
+		headers = append(headers, b.GetHeader())
+	}
+	rules := HeaderChainRules{Anchor: genesis.Hash(), Difficulty: StaticHeaderDifficulty}
+	for _, tr := range trs {
+		proof, ok := testStorage.GetTransactionProof(tr.Hash())
+		assertT(ok, t)
+		assertT(proof.BlockHash == block.Hash(), t)
+		assertT(VerifyTransactionProof(tr, proof, headers, rules, testHashfunction), t)
+		assertT(!VerifyTransactionProof(trs[(proof.Proof.Index+1)%3], proof, headers, rules, testHashfunction), t)
+	}
+
+	// The headers after the anchor need enough work.
+	proof, _ := testStorage.GetTransactionProof(trs[0].Hash())
+	rules.MinWork = BlockWork(250)
+	assertT(VerifyTransactionProof(trs[0], proof, headers, rules, testHashfunction), t)
+	rules.MinWork = new(big.Int).Add(BlockWork(250), big.NewInt(1))
+	assertT(!VerifyTransactionProof(trs[0], proof, headers, rules, testHashfunction), t)
+	rules.MinWork = nil
+
+	// Without a trusted anchor, nothing is proved.
+	assertT(!VerifyTransactionProof(trs[0], proof, headers,
+		HeaderChainRules{Anchor: "unknown", Difficulty: StaticHeaderDifficulty}, testHashfunction), t)
+	assertT(!VerifyTransactionProof(trs[0], proof, headers[1:], rules, testHashfunction), t)
+
+	// A cheap header with a forged root is rejected by the difficulty rule.
+	forged := headers[1]
+	forged.NumBytes = 255
+	for !forged.CheckNonce(testHashfunction) {
+		forged.Nonce++
+	}
+	assertT(!VerifyHeaderChain([]BlockHeader{headers[0], forged}, rules, testHashfunction), t)
+
+	// A header chain with a tampered root is rejected.
+	headers[1].TransactionRoot = headers[0].TransactionRoot
+	assertT(!VerifyTransactionProof(trs[0], proof, headers, rules, testHashfunction), t)
+
+	_, ok := testStorage.GetTransactionProof("unknown")
+	assertT(!ok, t)
+}
@@ -36,11 +36,15 @@ func (m *SingleMiningFunction) SetSeed(seed int64) {
 // Solve ...
 func (m *SingleMiningFunction) Solve(ctx context.Context,
 	block HippoBlock) (result bool, newBlock HippoBlock) {
-	found, nonce := mineBase(ctx, block.HashSignatureBytes(), block.NumBytes,
+	found, nonce := mineBase(ctx, block.HeaderBytes(), block.NumBytes,
 		m.hashFunction, block.Level, m.seed, 0)
 	infoLogger.Info("mining result:", nonce, found)
 	if found {
 		block.Nonce = nonce
+		if !block.CheckNonce() {
+			infoLogger.Error("mining: nonce does not match the header")
+			return false, HippoBlock{}
+		}
 		return true, block
 	}
 	return false, HippoBlock{}
@@ -76,12 +80,13 @@ func (m *MultipleMiningFunction) Solve(ctx context.Context, block HippoBlock) (r
 	var once sync.Once
 	var totalNonce uint32
 
+	header := block.HeaderBytes()
 	miningContext, miningCancel := context.WithCancel(ctx)
 	defer miningCancel()
 	for i := 0; i < m.threads; i++ {
 		go func(ctx context.Context, cancel context.CancelFunc, i int) {
 			debugLogger.Debug("start thread:", i)
-			found, nonce := mineBase(ctx, block.HashSignatureBytes(), block.NumBytes,
+			found, nonce := mineBase(ctx, header, block.NumBytes,
 				m.hashFunction, block.Level, (m.seed+int64(i))%math.MaxInt64, i)
 			if found {
 				once.Do(func() {
@@ -97,6 +102,10 @@ func (m *MultipleMiningFunction) Solve(ctx context.Context, block HippoBlock) (r
 	infoLogger.Info("multiple mining solved:", totalNonce, result)
 	if result {
 		block.Nonce = totalNonce
+		if !block.CheckNonce() {
+			infoLogger.Error("multiple mining: nonce does not match the header")
+			return false, HippoBlock{}
+		}
 		return true, block
 	}
 	return false, HippoBlock{}
@@ -105,9 +114,13 @@ func (m *MultipleMiningFunction) Solve(ctx context.Context, block HippoBlock) (r
 // HashFunction ...
 type HashFunction func([]byte) []byte
 
-func hashWithNonce(previousHash []byte, nonce uint32, hash HashFunction) []byte {
-	full := append(previousHash, Uint32ToBytes(nonce)...)
-	return Hash(full)
+// hashWithNonce ...
+// header is the hash of the block header, see HippoBlock.HeaderBytes.
+func hashWithNonce(header []byte, nonce uint32, hash HashFunction) []byte {
+	full := make([]byte, 0, len(header)+4)
+	full = append(full, header...)
+	full = append(full, Uint32ToBytes(nonce)...)
+	return hash(full)
 }
 
 // Check if the nonce hash satisfies the difficulty requirement.
@@ -119,16 +132,16 @@ func compareHashLen(hash []byte, numBytes uint) bool {
 	return result
 }
 
-func checkNonce(previousHash []byte, nonce uint32, numBytes uint, hash HashFunction) bool {
-	sum := hashWithNonce(previousHash, nonce, hash)
+func checkNonce(header []byte, nonce uint32, numBytes uint, hash HashFunction) bool {
+	sum := hashWithNonce(header, nonce, hash)
 	sb := sha256.Sum256([]byte(sum))
 	sumBytes := sb[:]
 	result := compareHashLen(sumBytes, numBytes)
 	return result
 }
 
-func checkNonceShow(previousHash []byte, nonce uint32, numBytes uint, hash HashFunction) {
-	sum := hashWithNonce(previousHash, nonce, hash)
+func checkNonceShow(header []byte, nonce uint32, numBytes uint, hash HashFunction) {
+	sum := hashWithNonce(header, nonce, hash)
 	sb := sha256.Sum256([]byte(sum))
 	sumBytes := sb[:]
 	debugLogger.Debug("check nonce show:", ByteToNumDigits(sumBytes), numBytes)
@@ -87,7 +87,7 @@ func (m *MiningQueue) main() {
 			debugLogger.Debug("new block to mining queue:", block.Hash())
 			m.cancel()
 			m.context, m.cancel = context.WithCancel(m.queueContext)
-			m.storage.SetMiningCancel(m.cancel)
+			m.storage.SetMiningCancel(m.cancel, block.ParentHash())
 
 			// m.miningFunc.New(m.context, m.hashFunction, m.threads)
 			result, newBlock := m.miningFunc.Solve(m.context, block)
@@ -169,9 +169,8 @@ func MiningCallbackBroadcastSave(has bool, block Block, storage Storage, bq Broa
 		// go func() {
 		if bq != nil {
 			broadcastBlock := BroadcastBlock{
-				block:     block,
-				Level:     0,
-				Addresses: make(map[string]bool),
+				block: block,
+				Level: 0,
 			}
 			bq.Add(broadcastBlock)
 		} else {
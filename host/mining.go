@@ -75,7 +75,7 @@ func (m *HippoMining) SetStorage(storage Storage) { m.storage = storage }
 func (m *HippoMining) SetBroadcastQueue(bq BroadcastQueue) { m.broadcastQueue = bq }
 
 // Fetch ...
-// Fetch transactions into a block.
+// Fetch transactions into a block, after the coinbase paying the reward and the fees.
 func (m *HippoMining) Fetch(b Block) Block {
 	currentTime := time.Now().Unix()
 	transactions := m.transactionPool.Fetch(m.blockCapacity, func(t Transaction) bool {
@@ -88,7 +88,13 @@ func (m *HippoMining) Fetch(b Block) Block {
 		}
 		return true
 	})
-	b.SetTransactions(transactions)
+	var fees uint64
+	for _, t := range transactions {
+		fees += t.GetFee()
+	}
+	coinbase := CreateCoinbaseTransaction(b.GetHashFunction(), b.GetCurve(),
+		m.key.ToAddress(), Reward(b)+fees, b.GetLevel())
+	b.SetTransactions(append([]Transaction{coinbase}, transactions...))
 	return b
 }
 
@@ -117,6 +123,7 @@ func (m *HippoMining) WatchSendNewBlock() {
 
 				var block Block
 				block = new(HippoBlock)
+				// Mine on top of the chain with the most work.
 				var prevBlock Block
 				for prevBlock = m.storage.GetTopBlock(); prevBlock == nil; prevBlock = m.storage.GetTopBlock() {
 					infoLogger.Error("no top block")
@@ -126,6 +133,9 @@ func (m *HippoMining) WatchSendNewBlock() {
 					m.miningInterval)
 				block.New(prevBlock.HashBytes(), newDifficulty, prevBlock.GetHashFunction(),
 					prevBlock.GetLevel()+1, prevBlock.GetBalance(), prevBlock.GetCurve())
+				if mtp := m.storage.MedianTimePast(prevBlock); block.GetTimestamp() <= mtp {
+					block.SetTimestamp(mtp + 1)
+				}
 				block = m.Fetch(block)
 
 				block.Sign(m.key)
@@ -0,0 +1,334 @@
+package host
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Multisig addresses:
+// - An M-of-N address is "multisig:M:N:hash", where hash is the SHA-256 of M and
+//   the N public keys (addresses) in sorted order. There is no script.
+// - A transaction spends from it with a MultisigWitness at the position of the
+//   sender: M, the N public keys, and the signatures of the keys that approved.
+// - The witness is not in the digest, so the signers sign the same hash whatever
+//   the others did. CheckSignatures wants at least M valid signatures.
+// A PartiallySignedTransaction is passed between the signers until it is complete.
+
+const (
+	// MultisigPrefix ...
+	MultisigPrefix = "multisig:"
+	// MaxMultisigKeys ...
+	MaxMultisigKeys = 16
+)
+
+// PartiallySignedVersion ...
+const PartiallySignedVersion = 1
+
+var (
+	// ErrBadMultisig ...
+	ErrBadMultisig = errors.New("multisig: bad keys or threshold")
+	// ErrTransactionMismatch ...
+	ErrTransactionMismatch = errors.New("multisig: not the same transaction")
+)
+
+// MultisigWitness ...
+// The keys and signatures of a multisig sender. Signatures[i] is the signature of
+// PublicKeys[i], or "" if it has not signed.
+type MultisigWitness struct {
+	M          int      `json:"m"`
+	PublicKeys []string `json:"publicKeys"`
+	Signatures []string `json:"signatures"`
+}
+
+// MultisigAddress ...
+// The address of m of the publicKeys, in any order.
+func MultisigAddress(m int, publicKeys []string, curve elliptic.Curve) (string, error) {
+	keys, err := sortMultisigKeys(m, publicKeys, curve)
+	if err != nil {
+		return "", err
+	}
+	return multisigAddress(m, keys), nil
+}
+
+// sortMultisigKeys ...
+// Check m and the keys, and return them sorted.
+func sortMultisigKeys(m int, publicKeys []string, curve elliptic.Curve) ([]string, error) {
+	n := len(publicKeys)
+	if m < 1 || m > n || n > MaxMultisigKeys {
+		return nil, ErrBadMultisig
+	}
+	keys := append([]string(nil), publicKeys...)
+	sort.Strings(keys)
+	for i, key := range keys {
+		if !ValidAddress(key, curve) || IsMultisigAddress(key) || (i > 0 && key == keys[i-1]) {
+			return nil, ErrBadMultisig
+		}
+	}
+	return keys, nil
+}
+
+func multisigAddress(m int, sortedKeys []string) string {
+	sum := sha256.Sum256([]byte(strconv.Itoa(m) + "|" + strings.Join(sortedKeys, "|")))
+	return fmt.Sprintf("%s%d:%d:%s", MultisigPrefix, m, len(sortedKeys), hex.EncodeToString(sum[:]))
+}
+
+// IsMultisigAddress ...
+func IsMultisigAddress(address string) bool {
+	return strings.HasPrefix(address, MultisigPrefix)
+}
+
+// ParseMultisigAddress ...
+// Return M and N of a multisig address.
+func ParseMultisigAddress(address string) (m int, n int, ok bool) {
+	if !IsMultisigAddress(address) {
+		return 0, 0, false
+	}
+	parts := strings.Split(strings.TrimPrefix(address, MultisigPrefix), ":")
+	if len(parts) != 3 || len(parts[2]) != 2*sha256.Size || !isLowerHex(parts[2]) {
+		return 0, 0, false
+	}
+	m, errM := strconv.Atoi(parts[0])
+	n, errN := strconv.Atoi(parts[1])
+	if errM != nil || errN != nil || m < 1 || m > n || n > MaxMultisigKeys {
+		return 0, 0, false
+	}
+	return m, n, true
+}
+
+// NewMultisigWitness ...
+// An unsigned witness of m of the publicKeys.
+func NewMultisigWitness(m int, publicKeys []string, curve elliptic.Curve) (MultisigWitness, error) {
+	keys, err := sortMultisigKeys(m, publicKeys, curve)
+	if err != nil {
+		return MultisigWitness{}, err
+	}
+	return MultisigWitness{M: m, PublicKeys: keys, Signatures: make([]string, len(keys))}, nil
+}
+
+// Address ...
+// The address of the witness, or "" if its keys are not sorted.
+func (w MultisigWitness) Address() string {
+	if !sort.StringsAreSorted(w.PublicKeys) {
+		return ""
+	}
+	return multisigAddress(w.M, w.PublicKeys)
+}
+
+// Signed ...
+// The number of signatures, valid or not.
+func (w MultisigWitness) Signed() int {
+	signed := 0
+	for _, signature := range w.Signatures {
+		if signature != "" {
+			signed++
+		}
+	}
+	return signed
+}
+
+// findKey ...
+func (w MultisigWitness) findKey(publicKey string) int {
+	for i, key := range w.PublicKeys {
+		if key == publicKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkPartial ...
+// Whether the keys of the witness hash to address and every signature so far is valid.
+func (w MultisigWitness) checkPartial(address string, hash string, curve elliptic.Curve) bool {
+	if len(w.Signatures) != len(w.PublicKeys) {
+		return false
+	}
+	if _, err := sortMultisigKeys(w.M, w.PublicKeys, curve); err != nil || w.Address() != address {
+		return false
+	}
+	var key Key
+	for i, signature := range w.Signatures {
+		if signature == "" {
+			continue
+		}
+		key.LoadAddress(w.PublicKeys[i], curve)
+		if !key.CheckSignString(hash, signature) {
+			return false
+		}
+	}
+	return true
+}
+
+// check ...
+// Whether the witness unlocks address: checkPartial, with at least M signatures.
+func (w MultisigWitness) check(address string, hash string, curve elliptic.Curve) bool {
+	return w.checkPartial(address, hash, curve) && w.Signed() >= w.M
+}
+
+// ==============================================================
+
+// PartiallySignedTransaction ...
+// A transaction passed between the signers of its senders, as JSON.
+// Steps:
+// 1. Build the transaction, then SetMultisig() for each multisig sender.
+// 2. NewPartiallySignedTransaction(), Encode() and send it to the signers.
+// 3. Each signer DecodePartiallySignedTransaction(), Sign() and sends it back.
+// 4. Combine() the copies; once Complete(), submit the Transaction.
+type PartiallySignedTransaction struct {
+	Version     int               `json:"version"`
+	Hash        string            `json:"hash"`
+	Transaction *HippoTransaction `json:"transaction"`
+}
+
+// NewPartiallySignedTransaction ...
+func NewPartiallySignedTransaction(tr *HippoTransaction) *PartiallySignedTransaction {
+	return &PartiallySignedTransaction{
+		Version:     PartiallySignedVersion,
+		Hash:        tr.Hash(),
+		Transaction: tr,
+	}
+}
+
+// Encode ...
+func (p *PartiallySignedTransaction) Encode() []byte {
+	bytes, err := json.Marshal(p)
+	if err != nil {
+		infoLogger.Error("encode partially signed transaction:", err)
+		return nil
+	}
+	return bytes
+}
+
+// DecodePartiallySignedTransaction ...
+// Check the version, the hash and the signatures already there.
+func DecodePartiallySignedTransaction(data []byte, hash HashFunction,
+	curve elliptic.Curve) (*PartiallySignedTransaction, error) {
+	p := new(PartiallySignedTransaction)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if p.Version != PartiallySignedVersion {
+		return nil, fmt.Errorf("multisig: unsupported version %d", p.Version)
+	}
+	tr := p.Transaction
+	if tr == nil || tr.IsCoinbase() {
+		return nil, errors.New("multisig: no transaction")
+	}
+	tr.hashFunction, tr.curve = hash, curve
+	if err := tr.checkShape(); err != nil {
+		return nil, err
+	}
+	if tr.Hash() != p.Hash {
+		return nil, ErrTransactionMismatch
+	}
+	for pos, address := range tr.SenderAddresses {
+		if !tr.checkPartialSignature(pos) {
+			return nil, fmt.Errorf("multisig: bad signature of %s", address)
+		}
+	}
+	return p, nil
+}
+
+// Sign ...
+// Add the signatures of key, as a sender or a key of a multisig sender.
+func (p *PartiallySignedTransaction) Sign(key Key) bool {
+	return p.Transaction.Sign(key)
+}
+
+// SetSignature ...
+// Add a signature made elsewhere by address, a sender or a key of a multisig sender.
+func (p *PartiallySignedTransaction) SetSignature(address string, signature string) bool {
+	return p.Transaction.SetSignature(address, signature)
+}
+
+// Combine ...
+// Add the signatures of another decoded copy of the same transaction.
+func (p *PartiallySignedTransaction) Combine(other *PartiallySignedTransaction) error {
+	t, o := p.Transaction, other.Transaction
+	if other.Hash != p.Hash || o.Hash() != t.Hash() || len(o.SenderMultisig) != len(t.SenderMultisig) {
+		return ErrTransactionMismatch
+	}
+	for pos := range t.SenderAddresses {
+		if t.SenderSignatures[pos] == "" {
+			t.SenderSignatures[pos] = o.SenderSignatures[pos]
+		}
+		if pos >= len(t.SenderMultisig) || t.SenderMultisig[pos].M == 0 {
+			continue
+		}
+		mine, theirs := t.SenderMultisig[pos], o.SenderMultisig[pos]
+		if theirs.Address() != mine.Address() || len(theirs.Signatures) != len(mine.Signatures) {
+			return ErrTransactionMismatch
+		}
+		for i, signature := range theirs.Signatures {
+			if mine.Signatures[i] == "" {
+				mine.Signatures[i] = signature
+			}
+		}
+	}
+	return nil
+}
+
+// Missing ...
+// The senders without enough signatures yet.
+func (p *PartiallySignedTransaction) Missing() []string {
+	t := p.Transaction
+	h := t.Hash()
+	missing := make([]string, 0)
+	for pos, address := range t.SenderAddresses {
+		if !t.checkSender(pos, h) {
+			missing = append(missing, address)
+		}
+	}
+	return missing
+}
+
+// SignerStatus ...
+// The signatures of a sender. Keys is the sender itself, or the keys of a multisig sender.
+type SignerStatus struct {
+	Address  string   `json:"address"`
+	Required int      `json:"required"`
+	Signed   []string `json:"signed"`
+	Unsigned []string `json:"unsigned"`
+	Complete bool     `json:"complete"`
+}
+
+// Status ...
+// The status of each sender, in order.
+func (p *PartiallySignedTransaction) Status() []SignerStatus {
+	t := p.Transaction
+	h := t.Hash()
+	status := make([]SignerStatus, len(t.SenderAddresses))
+	for pos, address := range t.SenderAddresses {
+		s := SignerStatus{
+			Address:  address,
+			Required: 1,
+			Signed:   make([]string, 0),
+			Unsigned: make([]string, 0),
+			Complete: t.checkSender(pos, h),
+		}
+		keys, signatures := []string{address}, []string{t.SenderSignatures[pos]}
+		if witness := t.multisigWitness(pos); witness != nil {
+			s.Required, keys, signatures = witness.M, witness.PublicKeys, witness.Signatures
+		}
+		for i, key := range keys {
+			if signatures[i] == "" {
+				s.Unsigned = append(s.Unsigned, key)
+			} else {
+				s.Signed = append(s.Signed, key)
+			}
+		}
+		status[pos] = s
+	}
+	return status
+}
+
+// Complete ...
+func (p *PartiallySignedTransaction) Complete() bool {
+	return p.Transaction.CheckSignatures()
+}
@@ -0,0 +1,135 @@
+package host
+
+import (
+	"testing"
+)
+
+func TestMultisigAddress(t *testing.T) {
+	initTest(4)
+	keys := []string{testKeys[0].ToAddress(), testKeys[1].ToAddress(), testKeys[2].ToAddress()}
+	address, err := MultisigAddress(2, keys, testCurve)
+	assertT(err == nil && IsMultisigAddress(address) && ValidAddress(address, testCurve), t)
+	m, n, ok := ParseMultisigAddress(address)
+	assertT(ok && m == 2 && n == 3, t)
+
+	// The order of the keys does not matter, the threshold and the keys do.
+	same, _ := MultisigAddress(2, []string{keys[2], keys[0], keys[1]}, testCurve)
+	assertT(same == address, t)
+	other, _ := MultisigAddress(3, keys, testCurve)
+	assertT(other != address, t)
+	other, _ = MultisigAddress(2, append(keys[:2:2], testKeys[3].ToAddress()), testCurve)
+	assertT(other != address, t)
+
+	_, err = MultisigAddress(4, keys, testCurve)
+	assertT(err == ErrBadMultisig, t)
+	_, err = MultisigAddress(0, keys, testCurve)
+	assertT(err == ErrBadMultisig, t)
+	_, err = MultisigAddress(1, []string{keys[0], keys[0]}, testCurve)
+	assertT(err == ErrBadMultisig, t)
+	_, err = MultisigAddress(1, []string{keys[0], "bad"}, testCurve)
+	assertT(err == ErrBadMultisig, t)
+	_, _, ok = ParseMultisigAddress(MultisigPrefix + "2:3:abc")
+	assertT(!ok && !ValidAddress(MultisigPrefix+"2:3:abc", testCurve), t)
+}
+
+func newMultisigTestTransaction(t *testing.T) (*HippoTransaction, string) {
+	keys := []string{testKeys[0].ToAddress(), testKeys[1].ToAddress(), testKeys[2].ToAddress()}
+	address, err := MultisigAddress(2, keys, testCurve)
+	assertT(err == nil, t)
+	tr := new(HippoTransaction)
+	tr.New(testHashfunction, testCurve)
+	tr.SetSender([]string{address, testKeys[3].ToAddress()}, []uint64{10, 5})
+	tr.SetReceiver([]string{testKeys[0].ToAddress()}, []uint64{12})
+	tr.UpdateFee()
+	assertT(!tr.SetMultisig(address, 1, keys), t)
+	assertT(tr.SetMultisig(address, 2, keys), t)
+	return tr, address
+}
+
+func TestMultisigTransaction(t *testing.T) {
+	initTest(4)
+	tr, address := newMultisigTestTransaction(t)
+	hash := tr.Hash()
+
+	assertT(tr.Sign(testKeys[3]) && tr.Sign(testKeys[0]), t)
+	assertT(!tr.CheckSignatures(), t)
+	assertT(tr.Hash() == hash, t)
+	assertT(tr.Sign(testKeys[2]) && tr.CheckSignatures(), t)
+
+	balance := new(HippoBalance)
+	balance.New()
+	balance.Store(address, 10)
+	balance.Store(testKeys[3].ToAddress(), 5)
+	assertT(tr.Check(balance), t)
+	change := tr.GetBalanceChange()
+	assertT(change[address] == -10 && change[testKeys[0].ToAddress()] == 12, t)
+
+	// A signature of a key outside the witness, or of another hash, is rejected.
+	var outsider Key
+	outsider.New(testCurve)
+	outsider.GenerateKey()
+	assertT(!tr.Sign(outsider), t)
+	forged := *tr
+	forged.SenderMultisig = []MultisigWitness{tr.SenderMultisig[0], {}}
+	forged.SenderMultisig[0].Signatures = []string{tr.SenderMultisig[0].Signatures[0], "", ""}
+	forged.SenderMultisig[0].Signatures[1] = tr.SenderSignatures[1]
+	assertT(!forged.CheckSignatures(), t)
+
+	// The witnesses survive both encodings.
+	decoded := DecodeTransaction(tr.Encode(), testHashfunction, testCurve)
+	assertT(decoded != nil && decoded.CheckSignatures(), t)
+	decoded = DecodeTransaction(EncodeTransactionBinary(tr), testHashfunction, testCurve)
+	assertT(decoded != nil && decoded.CheckSignatures(), t)
+	assertT(decoded.HashSignatures() == tr.HashSignatures(), t)
+
+	// A multisig sender without witness cannot be signed.
+	bare := new(HippoTransaction)
+	bare.New(testHashfunction, testCurve)
+	bare.SetSender([]string{address}, []uint64{10})
+	bare.SetReceiver([]string{testKeys[0].ToAddress()}, []uint64{10})
+	assertT(!bare.Sign(testKeys[0]) && !bare.CheckSignatures(), t)
+}
+
+func TestPartiallySignedTransaction(t *testing.T) {
+	initTest(4)
+	tr, address := newMultisigTestTransaction(t)
+	pst := NewPartiallySignedTransaction(tr)
+	data := pst.Encode()
+
+	// Two signers sign their own copies.
+	first, err := DecodePartiallySignedTransaction(data, testHashfunction, testCurve)
+	assertT(err == nil && len(first.Missing()) == 2, t)
+	assertT(first.Sign(testKeys[0]) && first.Sign(testKeys[3]), t)
+	second, err := DecodePartiallySignedTransaction(data, testHashfunction, testCurve)
+	assertT(err == nil && second.Sign(testKeys[1]), t)
+	missing := first.Missing()
+	assertT(len(missing) == 1 && missing[0] == address && !first.Complete(), t)
+	status := first.Status()
+	assertT(len(status) == 2 && status[0].Required == 2 && !status[0].Complete, t)
+	assertT(len(status[0].Signed) == 1 && status[0].Signed[0] == testKeys[0].ToAddress(), t)
+	assertT(len(status[0].Unsigned) == 2 && status[1].Complete, t)
+
+	// A signature made elsewhere is checked before it is added.
+	third, _ := DecodePartiallySignedTransaction(data, testHashfunction, testCurve)
+	signature, _ := testKeys[2].SignString(third.Transaction.HashBytes())
+	assertT(!third.SetSignature(testKeys[1].ToAddress(), signature), t)
+	assertT(third.SetSignature(testKeys[2].ToAddress(), signature), t)
+	assertT(len(third.Status()[0].Signed) == 1, t)
+
+	received, err := DecodePartiallySignedTransaction(second.Encode(), testHashfunction, testCurve)
+	assertT(err == nil && first.Combine(received) == nil, t)
+	assertT(len(first.Missing()) == 0 && first.Complete(), t)
+
+	// Another transaction, a wrong version or a bad signature are rejected.
+	other, _ := newMultisigTestTransaction(t)
+	other.Timestamp++
+	assertT(first.Combine(NewPartiallySignedTransaction(other)) == ErrTransactionMismatch, t)
+	bad := NewPartiallySignedTransaction(other)
+	bad.Version = 2
+	_, err = DecodePartiallySignedTransaction(bad.Encode(), testHashfunction, testCurve)
+	assertT(err != nil, t)
+	bad = NewPartiallySignedTransaction(other)
+	other.SenderMultisig[0].Signatures[0] = first.Transaction.SenderSignatures[1]
+	_, err = DecodePartiallySignedTransaction(bad.Encode(), testHashfunction, testCurve)
+	assertT(err != nil, t)
+}
@@ -2,90 +2,335 @@ package host
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 )
 
+// PoolConfig ...
+// Zero fields take the values of DefaultPoolConfig.
+// A failed dial or health check delays the next dial to the peer by
+// BackoffBase, doubled on every further failure up to BackoffMax.
+type PoolConfig struct {
+	CallTimeout       time.Duration
+	MaxInFlight       int
+	HealthCheckPeriod time.Duration
+	BackoffBase       time.Duration
+	BackoffMax        time.Duration
+}
+
+// DefaultPoolConfig ...
+var DefaultPoolConfig = PoolConfig{
+	CallTimeout:       DefaultCallTimeout,
+	MaxInFlight:       8,
+	HealthCheckPeriod: 30 * time.Second,
+	BackoffBase:       time.Second,
+	BackoffMax:        time.Minute,
+}
+
+func (config PoolConfig) withDefaults() PoolConfig {
+	if config.CallTimeout <= 0 {
+		config.CallTimeout = DefaultPoolConfig.CallTimeout
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = DefaultPoolConfig.MaxInFlight
+	}
+	if config.HealthCheckPeriod <= 0 {
+		config.HealthCheckPeriod = DefaultPoolConfig.HealthCheckPeriod
+	}
+	if config.BackoffBase <= 0 {
+		config.BackoffBase = DefaultPoolConfig.BackoffBase
+	}
+	if config.BackoffMax < config.BackoffBase {
+		config.BackoffMax = config.BackoffBase
+	}
+	return config
+}
+
+// backoff ...
+// The delay after failures consecutive failures.
+func (config PoolConfig) backoff(failures int) time.Duration {
+	delay := config.BackoffBase
+	for i := 1; i < failures && delay < config.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > config.BackoffMax {
+		delay = config.BackoffMax
+	}
+	return delay
+}
+
+// PeerPoolStats ...
+// The call counters add up all connections to the peer.
+// RetryAt is zero unless the peer is backing off.
+type PeerPoolStats struct {
+	Address   string
+	Connected bool
+	ClientStats
+	Dials          uint64
+	DialFailures   uint64
+	HealthFailures uint64
+	RetryAt        time.Time
+}
+
+// poolPeer ...
+// The state of one address. lock is held while dialing.
+type poolPeer struct {
+	lock           sync.Mutex
+	failures       int
+	retryAt        time.Time
+	dials          uint64
+	dialFailures   uint64
+	healthFailures uint64
+	// closed: the stats of the closed connections
+	closed ClientStats
+}
+
 // NetworkPool ...
+// One connection per address, with per-call timeouts, an in-flight cap,
+// health checks and reconnect backoff.
 type NetworkPool struct {
 	data           sync.Map
 	clientTemplate P2PClientInterface
 	parentCtx      context.Context
 	protocol       string
 	tempalteBlock  Block
+	// codecs: address -> codec negotiated with the peer
+	codecs    sync.Map
+	transport Transport
+
+	configLock sync.Mutex
+	config     PoolConfig
+	// peers: address -> *poolPeer
+	peers sync.Map
 }
 
 // New ...
+// Health checks run until ctx is done.
 func (n *NetworkPool) New(ctx context.Context, clientTemplate P2PClientInterface,
 	protocol string, templateBlock Block) {
 	n.clientTemplate = clientTemplate.Empty()
 	n.parentCtx = ctx
 	n.protocol = protocol
 	n.tempalteBlock = templateBlock
+	n.config = DefaultPoolConfig
+	go n.healthCheckLoop()
+}
+
+// SetConfig ...
+// It applies to new connections.
+func (n *NetworkPool) SetConfig(config PoolConfig) {
+	n.configLock.Lock()
+	defer n.configLock.Unlock()
+	n.config = config.withDefaults()
+}
+
+func (n *NetworkPool) getConfig() PoolConfig {
+	n.configLock.Lock()
+	defer n.configLock.Unlock()
+	return n.config
+}
+
+func (n *NetworkPool) peer(address string) *poolPeer {
+	p, _ := n.peers.LoadOrStore(address, new(poolPeer))
+	return p.(*poolPeer)
 }
 
 // Get ...
+// Return the connection to address, or dial it.
+// Return nil if the dial fails or the peer is backing off.
 func (n *NetworkPool) Get(address string) P2PClientInterface {
 	debugLogger.Info("networkPool get:", address)
 
-	var clientInterface interface{}
-	var client P2PClientInterface
-	var has bool
-	var err error
-	clientInterface, has = n.data.Load(address)
-	if !has {
-		client = n.clientTemplate.Empty()
-		err = client.New(n.parentCtx, n.protocol, address)
-		client.SetTemplateBlock(n.tempalteBlock)
-		if err == nil {
-			n.data.Store(address, client)
-			infoLogger.Info("networkPool store:", address)
-		} else {
-			client = nil
-			infoLogger.Error("networkPool get:", err)
+	if clientInterface, has := n.data.Load(address); has {
+		client := clientInterface.(P2PClientInterface)
+		if !client.Stats().Broken {
+			return client
 		}
-	} else {
-		client = clientInterface.(P2PClientInterface)
+		infoLogger.Warn("networkPool: broken connection:", address)
 	}
-	return client
+	return n.dial(address, false)
 }
 
 // Update ...
+// Close the connection to address and dial it again.
 func (n *NetworkPool) Update(address string) P2PClientInterface {
 	infoLogger.Warn("networkPool update:", address)
+	return n.dial(address, true)
+}
 
-	var clientInterface interface{}
-	var client P2PClientInterface
-	var has bool
-	var err error
-	clientInterface, has = n.data.Load(address)
-	if has {
-		client = clientInterface.(P2PClientInterface)
-		infoLogger.Warn("going to close client:", address)
-
-		client.Close()
-		infoLogger.Warn("close client:", address)
+// dial ...
+// Replace the connection to address if it is broken, or always if force.
+func (n *NetworkPool) dial(address string, force bool) P2PClientInterface {
+	p := n.peer(address)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if clientInterface, has := n.data.Load(address); has {
+		client := clientInterface.(P2PClientInterface)
+		if !force && !client.Stats().Broken {
+			// Dialed by another goroutine.
+			return client
+		}
+		n.retireUnsafe(address, p, client)
+	}
+	if time.Now().Before(p.retryAt) {
+		debugLogger.Debug("networkPool: backing off:", address, p.retryAt)
+		return nil
 	}
 
-	client = n.clientTemplate.Empty()
-	err = client.New(n.parentCtx, n.protocol, address)
+	config := n.getConfig()
+	client := n.clientTemplate.Empty()
+	client.SetTransport(n.transport)
+	client.SetLimits(config.CallTimeout, config.MaxInFlight)
+	p.dials++
+	err := client.New(n.parentCtx, n.protocol, address)
+	if err != nil {
+		p.dialFailures++
+		n.failUnsafe(address, p, config)
+		infoLogger.Error("networkPool get:", err)
+		return nil
+	}
 	client.SetTemplateBlock(n.tempalteBlock)
+	client.SetCodec(n.codec(address))
+	p.failures, p.retryAt = 0, time.Time{}
+	n.data.Store(address, client)
+	infoLogger.Info("networkPool store:", address)
+	return client
+}
 
-	if err == nil {
-		n.data.Store(address, client)
-	} else {
-		client = nil
-		infoLogger.Error("networkPool get:", err)
+// retireUnsafe ...
+// Close client and keep its stats. Require p.lock.
+func (n *NetworkPool) retireUnsafe(address string, p *poolPeer, client P2PClientInterface) {
+	stats := client.Stats()
+	p.closed.Calls += stats.Calls
+	p.closed.Failures += stats.Failures
+	p.closed.Timeouts += stats.Timeouts
+	p.closed.Rejected += stats.Rejected
+	client.Close()
+	n.data.Delete(address)
+}
+
+// failUnsafe ...
+// Back off from address. Require p.lock.
+func (n *NetworkPool) failUnsafe(address string, p *poolPeer, config PoolConfig) {
+	p.failures++
+	delay := config.backoff(p.failures)
+	p.retryAt = time.Now().Add(delay)
+	infoLogger.Warn("networkPool: retry", address, "in", delay)
+}
+
+// SetTransport ...
+// New connections are made with transport.
+func (n *NetworkPool) SetTransport(transport Transport) { n.transport = transport }
+
+// SetCodec ...
+// Use codec with address from now on.
+func (n *NetworkPool) SetCodec(address string, codec string) {
+	n.codecs.Store(address, codec)
+	if clientInterface, has := n.data.Load(address); has {
+		clientInterface.(P2PClientInterface).SetCodec(codec)
 	}
+}
 
-	return client
+func (n *NetworkPool) codec(address string) string {
+	if codec, has := n.codecs.Load(address); has {
+		return codec.(string)
+	}
+	return CodecJSON
+}
+
+// Delete ...
+// Close and remove the client of address.
+func (n *NetworkPool) Delete(address string) {
+	if clientInterface, has := n.data.Load(address); has {
+		p := n.peer(address)
+		p.lock.Lock()
+		n.retireUnsafe(address, p, clientInterface.(P2PClientInterface))
+		p.lock.Unlock()
+		infoLogger.Warn("networkPool delete:", address)
+	}
 }
 
 // Reset ...
 func (n *NetworkPool) Reset() {
 	n.data.Range(func(k, v interface{}) bool {
-		client := v.(P2PClientInterface)
-		client.Close()
+		n.Delete(k.(string))
+		return true
+	})
+}
+
+// HealthCheck ...
+// Ping every connection. A connection that fails is closed and the peer backs off.
+func (n *NetworkPool) HealthCheck() {
+	config := n.getConfig()
+	var wg sync.WaitGroup
+	n.data.Range(func(k, v interface{}) bool {
+		wg.Add(1)
+		go func(address string, client P2PClientInterface) {
+			defer wg.Done()
+			var reply string
+			err := client.Ping("", &reply)
+			if err == nil || err == ErrTooManyInFlight {
+				return
+			}
+			infoLogger.Warn("networkPool: health check failed:", address, err)
+			p := n.peer(address)
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			if current, has := n.data.Load(address); has && current == client {
+				n.retireUnsafe(address, p, client)
+			}
+			p.healthFailures++
+			n.failUnsafe(address, p, config)
+		}(k.(string), v.(P2PClientInterface))
+		return true
+	})
+	wg.Wait()
+}
+
+func (n *NetworkPool) healthCheckLoop() {
+	for {
+		select {
+		case <-n.parentCtx.Done():
+			return
+		case <-time.After(n.getConfig().HealthCheckPeriod):
+			n.HealthCheck()
+		}
+	}
+}
+
+// Stats ...
+// Sorted by address.
+func (n *NetworkPool) Stats() []PeerPoolStats {
+	stats := make([]PeerPoolStats, 0)
+	n.peers.Range(func(k, v interface{}) bool {
+		address, p := k.(string), v.(*poolPeer)
+		p.lock.Lock()
+		s := PeerPoolStats{
+			Address:        address,
+			ClientStats:    p.closed,
+			Dials:          p.dials,
+			DialFailures:   p.dialFailures,
+			HealthFailures: p.healthFailures,
+			RetryAt:        p.retryAt,
+		}
+		p.lock.Unlock()
+		if clientInterface, has := n.data.Load(address); has {
+			current := clientInterface.(P2PClientInterface).Stats()
+			s.Connected = !current.Broken
+			s.Calls += current.Calls
+			s.Failures += current.Failures
+			s.Timeouts += current.Timeouts
+			s.Rejected += current.Rejected
+			s.InFlight = current.InFlight
+		}
+		if time.Now().After(s.RetryAt) {
+			s.RetryAt = time.Time{}
+		}
+		stats = append(stats, s)
 		return true
 	})
-	n.data = sync.Map{}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Address < stats[j].Address })
+	return stats
 }
@@ -2,16 +2,14 @@ package host
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"sort"
 	"sync"
 	"time"
-
-	registerlib "github.com/XieGuochao/HippoCoinRegister/lib"
 )
 
 // Include network listener and network client.
@@ -19,13 +17,14 @@ import (
 // NetworkListener ...
 // Steps:
 // 1. New(ctx, ip, protocol)
-// 1.1 SetConfigPort(port)
+// 1.1 SetConfigPort(port)  SetTransport(transport)
 // 2. Listen()   It will generate port.
 // 3. Stop()
 // 4. SetIP(ip) SetPort(port) NetworkAddress()
 type NetworkListener interface {
 	New(ctx context.Context, ip, protocol string)
 	SetConfigPort(int)
+	SetTransport(transport Transport)
 	Listen()
 	Listener() net.Listener
 	SetIP(ip string)
@@ -44,6 +43,7 @@ type HippoNetworkListener struct {
 	cancel       context.CancelFunc
 	listener     net.Listener
 	p2pServer    P2PServiceInterface
+	transport    Transport
 }
 
 // New ...
@@ -55,6 +55,10 @@ func (l *HippoNetworkListener) New(ctx context.Context, ip, protocol string) {
 // SetConfigPort ...
 func (l *HippoNetworkListener) SetConfigPort(port int) { l.configPort = port }
 
+// SetTransport ...
+// Without a transport, Listen takes plain connections of protocol.
+func (l *HippoNetworkListener) SetTransport(transport Transport) { l.transport = transport }
+
 // SetIP ...
 func (l *HippoNetworkListener) SetIP(ip string) {
 	l.ip = ip
@@ -78,7 +82,11 @@ func (l *HippoNetworkListener) Stop() {
 // Listen ...
 func (l *HippoNetworkListener) Listen() {
 	var err error
-	l.listener, err = net.Listen(l.protocol, fmt.Sprintf(":%d", l.configPort))
+	if l.transport != nil {
+		l.listener, err = l.transport.Listen(fmt.Sprintf(":%d", l.configPort))
+	} else {
+		l.listener, err = net.Listen(l.protocol, fmt.Sprintf(":%d", l.configPort))
+	}
 	if err != nil {
 		infoLogger.Fatal(err, l.protocol)
 	}
@@ -94,8 +102,10 @@ func (l *HippoNetworkListener) Listener() net.Listener {
 // NetworkClient ...
 // Steps:
 // 1. New(ctx, address, protocol, maxNeighbors, register, updateTimeBase, updateTimeRand, p2pClient)
-// p2pClient is only a template.
+// p2pClient is only a template. register can be nil.
 // 1.(1) SetMaxPing(int64)
+// 1.(2) AddDiscovery(discovery)  SetAddressBook(book)  SetPeerScores(scores)  SetTransport(t)
+// 1.(3) SetPoolConfig(config)  SetMaxInbound(n)
 // 2. SyncNeighbors()
 // 3. StopSyncNeighbors()
 // 4. CountNeighbors()  UpdateNeighbors()  Ping(address)
@@ -112,17 +122,41 @@ type NetworkClient interface {
 	SyncNeighbors()
 	StopSyncNeighbors()
 	GetAddress() string
+	AddDiscovery(d Discovery)
+	SetAddressBook(book AddressBook)
+	GetPeers(address string, max int) ([]string, bool)
+	SetPeerScores(scores PeerScores)
+	SetTransport(transport Transport)
+	SetPoolConfig(config PoolConfig)
+	GetPoolStats() []PeerPoolStats
+	SetMaxInbound(n int)
+	AddInbound(remoteAddress string, remote HandshakeInfo, conn io.Closer) bool
+	RemoveInbound(remoteAddress string)
+	GetInboundNeighbors() []string
+	IsKnownNeighbor(address string) bool
+	Disconnect(address string)
+	DisconnectPeer(peer string)
 
 	TryUpdateNeighbors()
+	SetHandshake(f HandshakeFunc)
+	Handshake(address string) (HandshakeInfo, error)
+	GetPeerInfo(address string) (HandshakeInfo, bool)
+	GetRejectedPeers() map[string]string
 	Ping(address string) (int64, bool)
 	BroadcastBlock(address string, broadcastBlock BroadcastBlock, reply *string) error
 	BroadcastTransaction(address string, transactionBlock BroadcastTransaction, reply *string) error
+	Inv(address string, inv Inventory, reply *string) error
+	QueryTransactions(address string, hashes []string) []Transaction
 
 	QueryLevel(address string, level0, level1 int, reply *[]string) error
 	QueryByHash(address string, hashValue string) Block
 	QueryHashes(address string, hashes []string) (blocks []Block)
+	QueryTransactionProof(address string, transactionHash string) (TransactionProof, bool)
+	GetTip(address string) (TipInfo, bool)
+	GetHeaders(address string, locator []string, max int) ([]BlockHeader, bool)
 	SyncBlocks(address string, storage Storage)
 	SyncAddressesN(n int, storage Storage)
+	GetSyncProgress() SyncProgress
 
 	SetSyncBlockCount(count int)
 	StartSyncBlocks(storage Storage)
@@ -131,13 +165,23 @@ type NetworkClient interface {
 	SetSyncPeriod(int64)
 }
 
+// inboundPeer ...
+// An inbound connection and what it sent in the handshake.
+type inboundPeer struct {
+	info HandshakeInfo
+	conn io.Closer
+}
+
 // HippoNetworkClient ...
 type HippoNetworkClient struct {
 	ctx                 context.Context
 	address, protocol   string
 	neighbors           sync.Map
 	maxNeighbors        int
-	register            Register
+	maxInbound          int
+	discoveries         []Discovery
+	addressBook         AddressBook
+	peerScores          PeerScores
 	syncNeighborsCtx    context.Context
 	syncNeighborsCancel context.CancelFunc
 	syncBlockCtx        context.Context
@@ -151,8 +195,23 @@ type HippoNetworkClient struct {
 	syncBlockPeriod int64
 
 	networkPool NetworkPool
+	transport   Transport
 
 	templateBlock Block
+
+	syncLock         sync.Mutex
+	syncing          bool
+	syncProgressLock sync.Mutex
+	syncProgress     SyncProgress
+
+	handshake HandshakeFunc
+	// peerInfo: address -> HandshakeInfo with the common features.
+	peerInfo sync.Map
+	// rejected: address -> reason
+	rejected sync.Map
+	// inbound: remote address of a connection that handshaked with us -> inboundPeer
+	inbound     sync.Map
+	inboundLock sync.Mutex
 }
 
 // New ...
@@ -162,8 +221,11 @@ func (c *HippoNetworkClient) New(ctx context.Context, address string, protocol s
 	c.ctx = ctx
 	c.syncNeighborsCtx, c.syncNeighborsCancel = context.WithCancel(ctx)
 	c.address, c.protocol = address, protocol
-	c.register = register
+	if register != nil {
+		c.discoveries = append(c.discoveries, &RegisterDiscovery{Register: register})
+	}
 	c.maxNeighbors = maxNeighbors
+	c.maxInbound = DefaultMaxInbound
 	c.updateTimeBase, c.updateTimeRand = updateTimeBase, updateTimeRand
 	c.p2pClient = p2pClient
 	c.maxPing = 1e4 // 10 seconds
@@ -188,33 +250,341 @@ func (c *HippoNetworkClient) CountNeighbors() (count int) {
 	return count
 }
 
+// AddDiscovery ...
+func (c *HippoNetworkClient) AddDiscovery(d Discovery) {
+	c.discoveries = append(c.discoveries, d)
+}
+
+// SetAddressBook ...
+func (c *HippoNetworkClient) SetAddressBook(book AddressBook) { c.addressBook = book }
+
+// SetPeerScores ...
+// Banned peers are never connected, and neighbors with low scores are evicted first.
+func (c *HippoNetworkClient) SetPeerScores(scores PeerScores) { c.peerScores = scores }
+
+// SetTransport ...
+func (c *HippoNetworkClient) SetTransport(transport Transport) {
+	c.transport = transport
+	c.networkPool.SetTransport(transport)
+}
+
+// SetPoolConfig ...
+func (c *HippoNetworkClient) SetPoolConfig(config PoolConfig) {
+	c.networkPool.SetConfig(config)
+}
+
+// GetPoolStats ...
+func (c *HippoNetworkClient) GetPoolStats() []PeerPoolStats { return c.networkPool.Stats() }
+
+// Disconnect ...
+// Drop the neighbor and close the connection.
+func (c *HippoNetworkClient) Disconnect(address string) {
+	infoLogger.Warn("disconnect:", address)
+	c.removeNeighbor(address)
+	c.peerInfo.Delete(address)
+	c.networkPool.Delete(address)
+}
+
+// DisconnectPeer ...
+// Disconnect the neighbors and drop the inbound connections of a PeerKey,
+// e.g. when it is banned.
+func (c *HippoNetworkClient) DisconnectPeer(peer string) {
+	for _, address := range c.GetNeighbors() {
+		if PeerKey(address) == peer {
+			c.Disconnect(address)
+		}
+	}
+	for _, address := range c.GetInboundNeighbors() {
+		if PeerKey(address) == peer {
+			c.RemoveInbound(address)
+		}
+	}
+}
+
+// removeNeighbor ...
+func (c *HippoNetworkClient) removeNeighbor(address string) {
+	c.neighbors.Delete(address)
+}
+
+// SetMaxInbound ...
+// The maximum number of inbound neighbors. The outbound ones are limited by maxNeighbors.
+func (c *HippoNetworkClient) SetMaxInbound(n int) { c.maxInbound = n }
+
+// countInbound ...
+func (c *HippoNetworkClient) countInbound() (count int) {
+	c.inbound.Range(func(k, v interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// countOutbound ...
+// The neighbors are the peers dialed by us.
+func (c *HippoNetworkClient) countOutbound() int {
+	return c.CountNeighbors()
+}
+
+// GetInboundNeighbors ...
+func (c *HippoNetworkClient) GetInboundNeighbors() (neighbors []string) {
+	c.inbound.Range(func(k, v interface{}) bool {
+		neighbors = append(neighbors, k.(string))
+		return true
+	})
+	return neighbors
+}
+
+// IsKnownNeighbor ...
+// Whether address is an outbound neighbor, or the address announced by an inbound one.
+func (c *HippoNetworkClient) IsKnownNeighbor(address string) (known bool) {
+	if _, known = c.neighbors.Load(address); known {
+		return true
+	}
+	c.inbound.Range(func(k, v interface{}) bool {
+		known = v.(inboundPeer).info.Address == address
+		return !known
+	})
+	return known
+}
+
+// AddInbound ...
+// Register a connection that handshaked with the P2P server as an inbound neighbor,
+// if an inbound slot is free. The connection is the peer: it is never dialed back,
+// so peers behind a NAT are registered too. The address it claims is only a known
+// neighbor that the server may pull announced data from.
+// conn is closed when the neighbor is removed. The server refuses the connection
+// if it is not registered.
+func (c *HippoNetworkClient) AddInbound(remoteAddress string, remote HandshakeInfo,
+	conn io.Closer) bool {
+	if c.isBanned(remoteAddress) {
+		return false
+	}
+	c.inboundLock.Lock()
+	defer c.inboundLock.Unlock()
+	if _, has := c.inbound.Load(remoteAddress); has {
+		return true
+	}
+	if c.countInbound() >= c.maxInbound {
+		debugLogger.Debug("inbound slots are full:", remoteAddress)
+		return false
+	}
+	c.inbound.Store(remoteAddress, inboundPeer{info: remote, conn: conn})
+	infoLogger.Info("inbound neighbor:", remoteAddress, remote.Address)
+	return true
+}
+
+// RemoveInbound ...
+// Close an inbound connection and free its slot.
+func (c *HippoNetworkClient) RemoveInbound(remoteAddress string) {
+	if v, has := c.inbound.LoadAndDelete(remoteAddress); has && v.(inboundPeer).conn != nil {
+		v.(inboundPeer).conn.Close()
+	}
+}
+
+func (c *HippoNetworkClient) isBanned(address string) bool {
+	return c.peerScores != nil && c.peerScores.IsBanned(address)
+}
+
+func (c *HippoNetworkClient) score(address string) int {
+	if c.peerScores == nil {
+		return DefaultPeerScore
+	}
+	return c.peerScores.Score(address)
+}
+
 // UpdateNeighbors ...
+// Try the discovered peers until there are enough outbound neighbors.
+// Peers from new address groups are tried first.
 func (c *HippoNetworkClient) UpdateNeighbors() {
-	var reply []byte
-	registerClient := c.register.Client()
-	defer registerClient.Close()
-	err := registerClient.AddressesRefresh(registerlib.RefreshStruct{
-		Number:  c.maxNeighbors,
-		Address: c.address,
-	}, &reply)
-	// defer registerClient.Close()
+	candidates := diversify(c.discover(), c.GetNeighbors())
+	debugLogger.Info("update neighbor:", candidates)
+	for _, n := range candidates {
+		if c.countOutbound() >= c.maxNeighbors {
+			break
+		}
+		if _, has := c.neighbors.Load(n); has || c.isBanned(n) {
+			continue
+		}
+		if _, err := c.Handshake(n); err == nil {
+			c.Ping(n)
+		}
+		if c.addressBook == nil {
+			continue
+		}
+		if _, has := c.neighbors.Load(n); has {
+			c.addressBook.MarkGood(n)
+		} else {
+			c.addressBook.MarkFailed(n)
+		}
+	}
+	if c.addressBook != nil {
+		if err := c.addressBook.Save(); err != nil {
+			infoLogger.Error("address book: save:", err)
+		}
+	}
+}
+
+// discover ...
+// Collect peer addresses from the discovery sources, the address book and
+// the neighbors, without duplicates. New ones are added to the address book.
+func (c *HippoNetworkClient) discover() []string {
+	seen := map[string]bool{c.address: true}
+	candidates := make([]string, 0)
+	add := func(addresses []string, source string) {
+		for _, address := range addresses {
+			if address == "" || seen[address] {
+				continue
+			}
+			seen[address] = true
+			candidates = append(candidates, address)
+			if c.addressBook != nil {
+				c.addressBook.Add(address, source)
+			}
+		}
+	}
+
+	for _, d := range c.discoveries {
+		add(d.Discover(c.address, c.maxNeighbors), d.Name())
+	}
+	if c.addressBook != nil {
+		add(c.addressBook.Addresses(c.maxNeighbors), "book")
+	}
+	for _, n := range c.GetNeighbors() {
+		if peers, ok := c.GetPeers(n, c.maxNeighbors); ok {
+			add(peers, "peer")
+		}
+	}
+	return candidates
+}
+
+// GetPeers ...
+// Peer exchange: ask address for its neighbors.
+func (c *HippoNetworkClient) GetPeers(address string, max int) (peers []string, ok bool) {
+	var p2pClient P2PClientInterface
+	debugLogger.Debug("netowrk client: get peers", address)
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing))
+	done := make(chan error, 1)
+
+	defer cancel()
+	ok = false
+
+	go func(done chan error) {
+		p2pClient = c.networkPool.Get(address)
+		if p2pClient != nil {
+			peers, ok = p2pClient.GetPeers(max)
+		}
+		done <- nil
+	}(done)
+
+	select {
+	case <-done:
+		debugLogger.Debug("netowrk client: get peers finished.")
+		return peers, ok
+	case <-ctx.Done():
+		debugLogger.Debug("netowrk client: get peers timeout")
+		return nil, false
+	}
+}
+
+// SetHandshake ...
+// Without it, peers are not checked.
+func (c *HippoNetworkClient) SetHandshake(f HandshakeFunc) { c.handshake = f }
+
+// Handshake ...
+// Exchange HandshakeInfo with address. An incompatible peer is recorded with the reason.
+func (c *HippoNetworkClient) Handshake(address string) (remote HandshakeInfo, err error) {
+	if c.isBanned(address) {
+		return remote, errors.New("banned")
+	}
+	if c.handshake == nil {
+		return remote, nil
+	}
+	local := c.handshake()
+	debugLogger.Debug("netowrk client: handshake", address)
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing))
+	done := make(chan error, 1)
+	defer cancel()
+
+	go func(done chan error) {
+		p2pClient := c.networkPool.Get(address)
+		if p2pClient == nil {
+			done <- errors.New("cannot connect")
+			return
+		}
+		var err error
+		remote, err = p2pClient.Handshake(local)
+		if isMissingMethod(err) {
+			err = ErrNoHandshake
+		}
+		if err == nil {
+			err = CheckHandshake(local, remote)
+		}
+		if err == nil && c.transport != nil {
+			// The transport pinned the node ID of the certificate when it dialed.
+			if pinned, ok := c.transport.PinnedNodeID(address); ok && pinned != remote.NodeID {
+				err = ErrNodeIDMismatch
+			}
+		}
+		done <- err
+	}(done)
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("handshake timeout")
+	}
 	if err != nil {
-		infoLogger.Error("update neighbor error:", err)
-		return
+		infoLogger.Warn("handshake: reject", address, err)
+		c.rejected.Store(address, err.Error())
+		c.peerInfo.Delete(address)
+		c.removeNeighbor(address)
+		return remote, err
+	}
+	c.rejected.Delete(address)
+	remote = c.setPeerInfo(address, local, remote)
+	return remote, nil
+}
+
+// setPeerInfo ...
+// Keep the common features of remote, and take the codec they allow.
+func (c *HippoNetworkClient) setPeerInfo(address string, local, remote HandshakeInfo) HandshakeInfo {
+	remote.Features = CommonFeatures(local, remote)
+	c.peerInfo.Store(address, remote)
+	if remote.HasFeature(FeatureBinaryCodec2) {
+		c.networkPool.SetCodec(address, CodecBinary)
+	} else {
+		c.networkPool.SetCodec(address, CodecJSON)
 	}
+	return remote
+}
 
-	var neighbors []string
-	json.Unmarshal(reply, &neighbors)
-	debugLogger.Info("update neighbor:", neighbors)
-	for _, n := range neighbors {
-		c.Ping(n)
+// GetPeerInfo ...
+// The HandshakeInfo of a peer, with the features both sides support.
+func (c *HippoNetworkClient) GetPeerInfo(address string) (HandshakeInfo, bool) {
+	info, has := c.peerInfo.Load(address)
+	if !has {
+		return HandshakeInfo{}, false
 	}
+	return info.(HandshakeInfo), true
+}
+
+// GetRejectedPeers ...
+// Peers refused by the handshake and the reasons.
+func (c *HippoNetworkClient) GetRejectedPeers() map[string]string {
+	rejected := make(map[string]string)
+	c.rejected.Range(func(key, value interface{}) bool {
+		rejected[key.(string)] = value.(string)
+		return true
+	})
+	return rejected
 }
 
 // TryUpdateNeighbors ...
 // Count the number of neighbors and update.
 func (c *HippoNetworkClient) TryUpdateNeighbors() {
-	if c.CountNeighbors() >= c.maxNeighbors {
+	if c.countOutbound() >= c.maxNeighbors {
 		return
 	}
 	c.UpdateNeighbors()
@@ -266,7 +636,7 @@ func (c *HippoNetworkClient) Ping(address string) (t int64, ok bool) {
 	if ok {
 		c.neighbors.Store(address, t)
 	} else {
-		c.neighbors.Delete(address)
+		c.removeNeighbor(address)
 		infoLogger.Warn("neighbor deleted:", address)
 	}
 	return t, true
@@ -356,6 +726,70 @@ func (c *HippoNetworkClient) BroadcastTransaction(address string, tr BroadcastTr
 	}
 }
 
+// Inv ...
+// Announce inventory to address.
+func (c *HippoNetworkClient) Inv(address string, inv Inventory, reply *string) error {
+	var p2pClient P2PClientInterface
+	var err error
+	debugLogger.Debug("netowrk client: inv", address, inv.Type, len(inv.Hashes))
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing))
+	done := make(chan error, 1)
+
+	defer cancel()
+
+	go func(done chan error) {
+		p2pClient = c.networkPool.Get(address)
+		if p2pClient == nil {
+			done <- errors.New("cannot connect")
+			return
+		}
+		err = p2pClient.Inv(inv, reply)
+		done <- err
+	}(done)
+
+	select {
+	case err = <-done:
+		if err != nil {
+			infoLogger.Error("inv:", address, err)
+		}
+		return err
+	case <-ctx.Done():
+		debugLogger.Debug("netowrk client: inv timeout")
+		return errors.New("netowrk client: inv timeout")
+	}
+}
+
+// QueryTransactions ...
+// Pull transactions from the transaction pool of address.
+func (c *HippoNetworkClient) QueryTransactions(address string,
+	hashes []string) (transactions []Transaction) {
+	var p2pClient P2PClientInterface
+	debugLogger.Debug("netowrk client: query transactions", address, len(hashes))
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing))
+	done := make(chan error, 1)
+
+	defer cancel()
+
+	go func(done chan error) {
+		p2pClient = c.networkPool.Get(address)
+		if p2pClient != nil {
+			transactions = p2pClient.QueryTransactions(hashes)
+		}
+		done <- nil
+	}(done)
+
+	select {
+	case <-done:
+		debugLogger.Debug("netowrk client: query transactions finished.")
+		return transactions
+	case <-ctx.Done():
+		debugLogger.Debug("netowrk client: query transactions timeout")
+		return nil
+	}
+}
+
 // QueryLevel ...
 func (c *HippoNetworkClient) QueryLevel(address string, level0,
 	level1 int, reply *[]string) error {
@@ -484,47 +918,160 @@ func (c *HippoNetworkClient) QueryHashes(address string, hashes []string) (block
 	}
 }
 
-// SyncBlocks ...
-// Sync blocks from the first level up to the latest one.
-func (c *HippoNetworkClient) SyncBlocks(address string, storage Storage) {
-	level0, level1 := 0, 4
-	var hashes []string
-	var err error
-	var newBlocks []Block
-	for {
-		debugLogger.Infof("sync blocks %s %d-%d", address, level0, level1)
-		err = c.QueryLevel(address, level0, level1, &hashes)
-		if err != nil {
-			infoLogger.Error("sync block error:", err)
-			return
+// QueryTransactionProof ...
+func (c *HippoNetworkClient) QueryTransactionProof(address string,
+	transactionHash string) (proof TransactionProof, ok bool) {
+	var p2pClient P2PClientInterface
+	debugLogger.Debug("netowrk client: query transaction proof", address, transactionHash)
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing))
+	done := make(chan error, 1)
+
+	defer cancel()
+	ok = false
+
+	go func(done chan error) {
+		p2pClient = c.networkPool.Get(address)
+		if p2pClient != nil {
+			proof, ok = p2pClient.QueryTransactionProof(transactionHash)
 		}
-		if len(hashes) == 0 {
-			infoLogger.Infof("syncBlocks %s done", address)
-			break
+		done <- nil
+	}(done)
+
+	select {
+	case <-done:
+		debugLogger.Debug("netowrk client: query transaction proof finished.")
+		return proof, ok
+	case <-ctx.Done():
+		debugLogger.Debug("netowrk client: query transaction proof timeout")
+		return TransactionProof{}, false
+	}
+}
+
+// GetTip ...
+func (c *HippoNetworkClient) GetTip(address string) (tip TipInfo, ok bool) {
+	var p2pClient P2PClientInterface
+	debugLogger.Debug("netowrk client: get tip", address)
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing))
+	done := make(chan error, 1)
+
+	defer cancel()
+	ok = false
+
+	go func(done chan error) {
+		p2pClient = c.networkPool.Get(address)
+		if p2pClient != nil {
+			tip, ok = p2pClient.GetTip()
 		}
-		hashes = storage.FilterNewHashes(hashes)
-		newBlocks = c.QueryHashes(address, hashes)
-		if len(newBlocks) == 0 {
-			infoLogger.Infof("syncBlocks %s done", address)
-			break
+		done <- nil
+	}(done)
+
+	select {
+	case <-done:
+		debugLogger.Debug("netowrk client: get tip finished.")
+		return tip, ok
+	case <-ctx.Done():
+		debugLogger.Debug("netowrk client: get tip timeout")
+		return TipInfo{}, false
+	}
+}
+
+// GetHeaders ...
+func (c *HippoNetworkClient) GetHeaders(address string, locator []string,
+	max int) (headers []BlockHeader, ok bool) {
+	var p2pClient P2PClientInterface
+	debugLogger.Debug("netowrk client: get headers", address)
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Millisecond*time.Duration(c.maxPing*5))
+	done := make(chan error, 1)
+
+	defer cancel()
+	ok = false
+
+	go func(done chan error) {
+		p2pClient = c.networkPool.Get(address)
+		if p2pClient != nil {
+			headers, ok = p2pClient.GetHeaders(locator, max)
+		}
+		done <- nil
+	}(done)
+
+	select {
+	case <-done:
+		debugLogger.Debug("netowrk client: get headers finished.")
+		return headers, ok
+	case <-ctx.Done():
+		debugLogger.Debug("netowrk client: get headers timeout")
+		return nil, false
+	}
+}
+
+// SyncBlocks ...
+// Sync blocks headers-first from address if it has more work.
+// Bodies are fetched from the other neighbors as well.
+// Only one sync runs at a time.
+func (c *HippoNetworkClient) SyncBlocks(address string, storage Storage) {
+	c.syncLock.Lock()
+	if c.syncing {
+		c.syncLock.Unlock()
+		return
+	}
+	c.syncing = true
+	c.syncLock.Unlock()
+	defer func() {
+		c.syncLock.Lock()
+		c.syncing = false
+		c.syncLock.Unlock()
+	}()
+
+	if !headersFirstSync(c, address, c.GetNeighbors(), storage,
+		c.templateBlock.GetHashFunction(), c.setSyncProgress) {
+		infoLogger.Warn("sync blocks: invalid chain from", address)
+		if c.peerScores != nil {
+			c.peerScores.Penalize(address, PenaltyInvalidHeaders, "invalid chain")
 		}
-		storage.AddBlocks(newBlocks)
-		level0, level1 = level1+1, level1+5
 	}
 }
 
 // SyncAddressesN ...
+// Sync from the one with the most work among n neighbors.
 func (c *HippoNetworkClient) SyncAddressesN(n int, storage Storage) {
 	addresses := c.GetNeighbors()
 	if n > len(addresses) {
 		n = len(addresses)
 	}
 	addresses = addresses[:n]
+
+	var (
+		best     string
+		bestWork = localTip(storage).GetWork()
+	)
 	for _, address := range addresses {
-		go c.SyncBlocks(address, storage)
+		tip, ok := c.GetTip(address)
+		if ok && tip.GetWork().Cmp(bestWork) > 0 {
+			best, bestWork = address, tip.GetWork()
+		}
+	}
+	if best != "" {
+		c.SyncBlocks(best, storage)
 	}
 }
 
+// GetSyncProgress ...
+func (c *HippoNetworkClient) GetSyncProgress() SyncProgress {
+	c.syncProgressLock.Lock()
+	defer c.syncProgressLock.Unlock()
+	return c.syncProgress
+}
+
+func (c *HippoNetworkClient) setSyncProgress(progress SyncProgress) {
+	c.syncProgressLock.Lock()
+	defer c.syncProgressLock.Unlock()
+	c.syncProgress = progress
+	debugLogger.Debugf("sync progress: %+v", progress)
+}
+
 // SetSyncBlockCount ...
 func (c *HippoNetworkClient) SetSyncBlockCount(count int) { c.syncBlockCount = count }
 
@@ -553,30 +1100,56 @@ func (c *HippoNetworkClient) StopSyncBlocks() { c.syncBlockCancel() }
 type NeighborPing struct {
 	Address string
 	Ping    int64
+	Score   int
 }
 
 // EvictNeighbors ...
-// Evict neighbors based on the ping time.
+// Evict banned neighbors. Then, for inbound and outbound neighbors separately,
+// keep the diverse ones and evict those with low scores, then the slow ones.
+// Neighbors are scored by their PeerKey.
 func (c *HippoNetworkClient) EvictNeighbors() {
-	neighbors := make([]NeighborPing, 0)
+	inbound := make([]NeighborPing, 0)
+	outbound := make([]NeighborPing, 0)
 	c.neighbors.Range(func(k, v interface{}) bool {
-		neighbors = append(neighbors, NeighborPing{
-			Address: k.(string),
+		address := k.(string)
+		if c.isBanned(address) {
+			c.Disconnect(address)
+			return true
+		}
+		outbound = append(outbound, NeighborPing{
+			Address: address,
 			Ping:    v.(int64),
+			Score:   c.score(address),
 		})
 		return true
 	})
-	debugLogger.Debug(neighbors)
-	if len(neighbors) < c.maxNeighbors {
+	c.inbound.Range(func(k, v interface{}) bool {
+		address := k.(string)
+		if c.isBanned(address) {
+			c.RemoveInbound(address)
+			return true
+		}
+		inbound = append(inbound, NeighborPing{Address: address, Score: c.score(address)})
+		return true
+	})
+	debugLogger.Debug("outbound:", outbound, "inbound:", inbound)
+	c.evict(outbound, c.maxNeighbors, c.removeNeighbor)
+	c.evict(inbound, c.maxInbound, c.RemoveInbound)
+}
+
+func (c *HippoNetworkClient) evict(neighbors []NeighborPing, keep int, remove func(string)) {
+	if len(neighbors) <= keep {
 		return
 	}
 	sort.Slice(neighbors, func(i, j int) bool {
+		if neighbors[i].Score != neighbors[j].Score {
+			return neighbors[i].Score > neighbors[j].Score
+		}
 		return neighbors[i].Ping < neighbors[j].Ping
 	})
-
-	// Delete those slow neighbors
-	for _, n := range neighbors[c.maxNeighbors:] {
-		c.neighbors.Delete(n.Address)
+	for _, n := range keepDiverse(neighbors, keep) {
+		infoLogger.Info("evict neighbor:", n.Address)
+		remove(n.Address)
 	}
 }
 
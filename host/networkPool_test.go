@@ -0,0 +1,127 @@
+package host
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSlowService ...
+// Ping "slow", or any Ping once hung, blocks until release is closed.
+type testSlowService struct {
+	release chan struct{}
+	hung    int32
+}
+
+func (s *testSlowService) Ping(request string, reply *string) error {
+	if request == "slow" || atomic.LoadInt32(&s.hung) == 1 {
+		<-s.release
+	}
+	*reply = "pong"
+	return nil
+}
+
+func newTestSlowServer(t *testing.T) (*testSlowService, net.Listener) {
+	service := &testSlowService{release: make(chan struct{})}
+	server := rpc.NewServer()
+	server.RegisterName(P2PServiceName, service)
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assertT(err == nil, t)
+	go http.Serve(listener, mux)
+	return service, listener
+}
+
+func TestP2PClientLimits(t *testing.T) {
+	initTest(0)
+	service, listener := newTestSlowServer(t)
+	defer listener.Close()
+
+	client := new(P2PClient)
+	client.SetTransport(new(TCPTransport))
+	client.SetLimits(100*time.Millisecond, 1)
+	assertT(client.New(context.Background(), ProtocolTCP, listener.Addr().String()) == nil, t)
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		var reply string
+		done <- client.Ping("slow", &reply)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	var reply string
+	assertT(client.Ping("", &reply) == ErrTooManyInFlight, t)
+	assertT(<-done == ErrCallTimeout, t)
+
+	close(service.release)
+	assertT(client.Ping("", &reply) == nil && reply == "pong", t)
+	stats := client.Stats()
+	assertT(stats.Calls == 2 && stats.Failures == 1 && stats.Timeouts == 1, t)
+	assertT(stats.Rejected == 1 && stats.InFlight == 0 && !stats.Broken, t)
+}
+
+func TestNetworkPoolBackoff(t *testing.T) {
+	initTest(0)
+	service, listener := newTestSlowServer(t)
+	defer listener.Close()
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	assertT(err == nil, t)
+	downAddress := down.Addr().String()
+	down.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var pool NetworkPool
+	pool.New(ctx, new(P2PClient), ProtocolTCP, nil)
+	pool.SetTransport(new(TCPTransport))
+	pool.SetConfig(PoolConfig{
+		CallTimeout: 100 * time.Millisecond,
+		BackoffBase: 50 * time.Millisecond,
+		BackoffMax:  100 * time.Millisecond,
+	})
+
+	// A failed dial backs off, and Get does not dial again until the retry time.
+	assertT(pool.Get(downAddress) == nil, t)
+	assertT(pool.Get(downAddress) == nil, t)
+	stats := pool.Stats()
+	assertT(len(stats) == 1 && stats[0].Dials == 1 && stats[0].DialFailures == 1, t)
+	assertT(!stats[0].RetryAt.IsZero() && !stats[0].Connected, t)
+	time.Sleep(60 * time.Millisecond)
+	assertT(pool.Get(downAddress) == nil, t)
+	stats = pool.Stats()
+	assertT(stats[0].Dials == 2, t)
+	assertT(time.Until(stats[0].RetryAt) > 60*time.Millisecond, t)
+
+	// A hung peer fails the health check: the connection is closed and the peer backs off.
+	address := listener.Addr().String()
+	client := pool.Get(address)
+	assertT(client != nil && pool.Get(address) == client, t)
+	var reply string
+	assertT(client.Ping("", &reply) == nil, t)
+	atomic.StoreInt32(&service.hung, 1)
+	pool.HealthCheck()
+	assertT(pool.Get(address) == nil, t)
+	for _, s := range pool.Stats() {
+		if s.Address == address {
+			assertT(s.HealthFailures == 1 && !s.Connected && !s.RetryAt.IsZero(), t)
+			assertT(s.Calls == 2 && s.Failures == 1 && s.Timeouts == 1, t)
+		}
+	}
+	atomic.StoreInt32(&service.hung, 0)
+	close(service.release)
+
+	time.Sleep(60 * time.Millisecond)
+	client = pool.Get(address)
+	assertT(client != nil, t)
+	pool.Reset()
+	for _, s := range pool.Stats() {
+		if s.Address == address {
+			assertT(s.Dials == 2 && !s.Connected && s.RetryAt.IsZero(), t)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package host
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOrphanCapacity ...
+	DefaultOrphanCapacity = 100
+	// DefaultOrphanTTL ...
+	// Seconds to keep an orphan.
+	DefaultOrphanTTL = 600
+	// MaxOrphansPerPeer ...
+	// The orphans kept from one PeerKey. A peer over it replaces its oldest orphan.
+	MaxOrphansPerPeer = 10
+	// OrphanFetchRetry ...
+	// Seconds before the parent of an orphan is requested again.
+	OrphanFetchRetry = 30
+	// OrphanDifficultySlack ...
+	// How much easier than the top block an orphan may be, in NumBytes: the
+	// difficulty rule moves NumBytes by at most 2 at a retarget.
+	OrphanDifficultySlack = 2
+)
+
+// OrphanHandler ...
+// Called when a block arrives before its parent.
+// sender is the address of the peer that sent the block, or "" for a local one.
+type OrphanHandler func(parentHash string, sender string)
+
+// OrphanPool ...
+// Blocks waiting for their parents.
+// Steps:
+// 1. New(capacity, TTL)
+// 2. Add(block, sender)
+// 3. TakeChildren(parentHash) once the parent is stored.
+type OrphanPool interface {
+	New(capacity int, TTL int64)
+	Add(block Block, sender string) (added bool, fetchParent bool)
+	Has(hashKey string) bool
+	TakeChildren(parentHash string) []Block
+	Len() int
+}
+
+type orphanEntry struct {
+	block    Block
+	sender   string
+	received int64
+}
+
+// HippoOrphanPool ...
+// HippoOrphanPool is thread-safe.
+// When it is full, the oldest orphan is evicted. A peer keeps at most
+// MaxOrphansPerPeer orphans, so that it cannot fill the pool alone.
+type HippoOrphanPool struct {
+	lock     sync.Mutex
+	capacity int
+	TTL      int64
+
+	orphans map[string]orphanEntry
+	// byParent: parent hash -> orphan hashes
+	byParent map[string][]string
+	// requested: parent hash -> when it was last requested
+	requested map[string]int64
+}
+
+// New ...
+func (p *HippoOrphanPool) New(capacity int, TTL int64) {
+	p.capacity, p.TTL = capacity, TTL
+	p.orphans = make(map[string]orphanEntry)
+	p.byParent = make(map[string][]string)
+	p.requested = make(map[string]int64)
+}
+
+// Add ...
+// fetchParent tells whether the parent should be requested: it is requested
+// again by an orphan of it arriving OrphanFetchRetry seconds after the last
+// request, in case that request failed.
+func (p *HippoOrphanPool) Add(block Block, sender string) (added bool, fetchParent bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	h := block.Hash()
+	parentHash := block.ParentHash()
+	if _, has := p.orphans[h]; has {
+		return false, p.requestUnsafe(parentHash)
+	}
+	p.expireUnsafe()
+	if p.capacity <= 0 {
+		return false, false
+	}
+	peer := PeerKey(sender)
+	for p.countPeerUnsafe(peer) >= MaxOrphansPerPeer {
+		p.evictOldestUnsafe(true, peer)
+	}
+	for len(p.orphans) >= p.capacity {
+		p.evictOldestUnsafe(false, "")
+	}
+
+	p.orphans[h] = orphanEntry{
+		block:    block,
+		sender:   sender,
+		received: time.Now().Unix(),
+	}
+	p.byParent[parentHash] = append(p.byParent[parentHash], h)
+	debugLogger.Debug("orphan pool: add", h, "parent:", parentHash)
+	return true, p.requestUnsafe(parentHash)
+}
+
+// requestUnsafe ...
+// Whether parentHash is to be requested now, which is recorded.
+func (p *HippoOrphanPool) requestUnsafe(parentHash string) bool {
+	now := time.Now().Unix()
+	if last, has := p.requested[parentHash]; has && now-last < OrphanFetchRetry {
+		return false
+	}
+	p.requested[parentHash] = now
+	return true
+}
+
+// countPeerUnsafe ...
+func (p *HippoOrphanPool) countPeerUnsafe(peer string) (count int) {
+	for _, entry := range p.orphans {
+		if PeerKey(entry.sender) == peer {
+			count++
+		}
+	}
+	return count
+}
+
+// Has ...
+func (p *HippoOrphanPool) Has(hashKey string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, has := p.orphans[hashKey]
+	return has
+}
+
+// TakeChildren ...
+// Remove and return the orphans of parentHash.
+func (p *HippoOrphanPool) TakeChildren(parentHash string) []Block {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	blocks := make([]Block, 0)
+	for _, h := range p.byParent[parentHash] {
+		if entry, has := p.orphans[h]; has {
+			blocks = append(blocks, entry.block)
+			delete(p.orphans, h)
+		}
+	}
+	delete(p.byParent, parentHash)
+	delete(p.requested, parentHash)
+	return blocks
+}
+
+// Len ...
+func (p *HippoOrphanPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.orphans)
+}
+
+func (p *HippoOrphanPool) removeUnsafe(h string) {
+	entry, has := p.orphans[h]
+	if !has {
+		return
+	}
+	delete(p.orphans, h)
+	parentHash := entry.block.ParentHash()
+	children := p.byParent[parentHash]
+	for i, child := range children {
+		if child == h {
+			children = append(children[:i], children[i+1:]...)
+			break
+		}
+	}
+	if len(children) == 0 {
+		delete(p.byParent, parentHash)
+		delete(p.requested, parentHash)
+	} else {
+		p.byParent[parentHash] = children
+	}
+}
+
+func (p *HippoOrphanPool) expireUnsafe() {
+	if p.TTL <= 0 {
+		return
+	}
+	deadline := time.Now().Unix() - p.TTL
+	for h, entry := range p.orphans {
+		if entry.received < deadline {
+			infoLogger.Warn("orphan pool: expire", h)
+			p.removeUnsafe(h)
+		}
+	}
+}
+
+// evictOldestUnsafe ...
+// Evict the oldest orphan, of peer only if ofPeer.
+func (p *HippoOrphanPool) evictOldestUnsafe(ofPeer bool, peer string) {
+	var (
+		oldest   string
+		received int64
+	)
+	for h, entry := range p.orphans {
+		if ofPeer && PeerKey(entry.sender) != peer {
+			continue
+		}
+		if oldest == "" || entry.received < received ||
+			(entry.received == received && h < oldest) {
+			oldest, received = h, entry.received
+		}
+	}
+	infoLogger.Warn("orphan pool: full, evict", oldest)
+	p.removeUnsafe(oldest)
+}
@@ -0,0 +1,57 @@
+package host
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOrphanPool(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test orphan pool ==============================")
+	initBalance()
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[0], nil)
+	b1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	a2 := mineTestBlock(a1, 250, testKeys[0], nil)
+
+	pool := new(HippoOrphanPool)
+	pool.New(2, 60)
+	added, fetch := pool.Add(a1, "peer")
+	assertT(added && fetch, t)
+	added, fetch = pool.Add(b1, "peer")
+	assertT(added && !fetch, t)
+	added, fetch = pool.Add(b1, "peer")
+	assertT(!added && !fetch, t)
+
+	// The parent is requested again once the last request is old.
+	pool.requested[genesis.Hash()] = 1
+	added, fetch = pool.Add(b1, "peer")
+	assertT(!added && fetch, t)
+
+	// The oldest one is evicted when the pool is full.
+	pool.orphans[a1.Hash()] = orphanEntry{block: a1, received: 1}
+	added, fetch = pool.Add(a2, "peer")
+	assertT(added && fetch, t)
+	assertT(pool.Len() == 2 && !pool.Has(a1.Hash()), t)
+
+	// Expired orphans are dropped.
+	pool.orphans[b1.Hash()] = orphanEntry{block: b1, received: 1}
+	pool.Add(a1, "peer")
+	assertT(!pool.Has(b1.Hash()) && pool.Has(a1.Hash()), t)
+
+	children := pool.TakeChildren(genesis.Hash())
+	assertT(len(children) == 1 && children[0].Hash() == a1.Hash(), t)
+	assertT(pool.Len() == 1 && pool.Has(a2.Hash()), t)
+
+	// A peer keeps at most MaxOrphansPerPeer orphans, whatever its port.
+	pool.New(DefaultOrphanCapacity, 60)
+	block := a2
+	for i := 0; i <= MaxOrphansPerPeer; i++ {
+		block = mineTestBlock(block, 250, testKeys[0], nil)
+		pool.Add(block, fmt.Sprintf("10.0.0.1:%d", i))
+	}
+	assertT(pool.Len() == MaxOrphansPerPeer, t)
+	added, _ = pool.Add(b1, "10.0.0.2:1")
+	assertT(added && pool.Len() == MaxOrphansPerPeer+1, t)
+}
@@ -0,0 +1,268 @@
+package host
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPeerScore ...
+	// The score of a new peer, which is also the highest score.
+	DefaultPeerScore = 100
+	// DefaultBanThreshold ...
+	// A peer whose score drops to it is banned.
+	DefaultBanThreshold = 0
+	// DefaultBanDuration ...
+	// Seconds to ban a peer.
+	DefaultBanDuration = 3600
+	// MaxPeerScores ...
+	// The number of scores kept. Beyond it, the scores closest to DefaultPeerScore
+	// are forgotten first.
+	MaxPeerScores = 4096
+)
+
+// Penalties ...
+const (
+	PenaltyInvalidBlock   = 50
+	PenaltyInvalidHeaders = 50
+	PenaltyBadSignature   = 30
+	PenaltyUndecodable    = 20
+	PenaltySpam           = 5
+)
+
+// BannedPeer ...
+// Until is a unix time in seconds.
+type BannedPeer struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+	Until   int64  `json:"until"`
+}
+
+// BanHandler ...
+// Called with the PeerKey of a banned peer, e.g. to disconnect it.
+type BanHandler func(peer string)
+
+// PeerKey ...
+// The identity a peer is scored and banned by: the host of its address.
+// The address it listens on, its inbound connections and its reconnections
+// have the same key. Nodes behind one IP address, such as several nodes on one
+// machine, share it too.
+func PeerKey(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// PeerScores ...
+// Scores of misbehaving peers.
+// Steps:
+// 1. New(threshold, banDuration)
+// 2. SetBanHandler(handler)
+// 3. Penalize(address, penalty, reason)  Reward(address, points)
+// 4. IsBanned(address)
+type PeerScores interface {
+	New(threshold int, banDuration int64)
+	SetBanHandler(handler BanHandler)
+
+	Penalize(address string, penalty int, reason string) (banned bool)
+	Reward(address string, points int)
+	Score(address string) int
+	Scores() map[string]int
+
+	Ban(address string, reason string)
+	Unban(address string)
+	IsBanned(address string) bool
+	GetBanned() []BannedPeer
+}
+
+// HippoPeerScores ...
+// HippoPeerScores is thread-safe. Addresses are scored by their PeerKey.
+// A ban expires after banDuration, and the peer starts again from DefaultPeerScore.
+// Peers back at DefaultPeerScore are forgotten.
+type HippoPeerScores struct {
+	lock        sync.Mutex
+	threshold   int
+	banDuration int64
+	scores      map[string]int
+	banned      map[string]BannedPeer
+	banHandler  BanHandler
+}
+
+// New ...
+func (p *HippoPeerScores) New(threshold int, banDuration int64) {
+	p.threshold, p.banDuration = threshold, banDuration
+	p.scores = make(map[string]int)
+	p.banned = make(map[string]BannedPeer)
+}
+
+// SetBanHandler ...
+func (p *HippoPeerScores) SetBanHandler(handler BanHandler) { p.banHandler = handler }
+
+// Penalize ...
+// Return true if the peer gets banned.
+func (p *HippoPeerScores) Penalize(address string, penalty int, reason string) (banned bool) {
+	if address == "" {
+		return false
+	}
+	peer := PeerKey(address)
+	p.lock.Lock()
+	if p.isBannedUnsafe(peer) {
+		p.lock.Unlock()
+		return false
+	}
+	score := p.scoreUnsafe(peer) - penalty
+	p.setScoreUnsafe(peer, score)
+	infoLogger.Warnf("peer score: %s %d (%s)", peer, score, reason)
+	if score > p.threshold {
+		p.lock.Unlock()
+		return false
+	}
+	p.banUnsafe(peer, reason)
+	p.lock.Unlock()
+
+	if p.banHandler != nil {
+		p.banHandler(peer)
+	}
+	return true
+}
+
+// Reward ...
+// The score never exceeds DefaultPeerScore.
+func (p *HippoPeerScores) Reward(address string, points int) {
+	if address == "" {
+		return
+	}
+	peer := PeerKey(address)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.setScoreUnsafe(peer, p.scoreUnsafe(peer)+points)
+}
+
+// Score ...
+func (p *HippoPeerScores) Score(address string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.scoreUnsafe(PeerKey(address))
+}
+
+// Scores ...
+// Scores of the peers below DefaultPeerScore, by PeerKey.
+func (p *HippoPeerScores) Scores() map[string]int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	scores := make(map[string]int, len(p.scores))
+	for address, score := range p.scores {
+		scores[address] = score
+	}
+	return scores
+}
+
+// Ban ...
+func (p *HippoPeerScores) Ban(address string, reason string) {
+	peer := PeerKey(address)
+	p.lock.Lock()
+	p.banUnsafe(peer, reason)
+	p.lock.Unlock()
+
+	if p.banHandler != nil {
+		p.banHandler(peer)
+	}
+}
+
+// Unban ...
+func (p *HippoPeerScores) Unban(address string) {
+	peer := PeerKey(address)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.banned, peer)
+	delete(p.scores, peer)
+}
+
+// IsBanned ...
+func (p *HippoPeerScores) IsBanned(address string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.isBannedUnsafe(PeerKey(address))
+}
+
+// GetBanned ...
+// Sorted by PeerKey.
+func (p *HippoPeerScores) GetBanned() []BannedPeer {
+	p.lock.Lock()
+	banned := make([]BannedPeer, 0, len(p.banned))
+	for address := range p.banned {
+		if p.isBannedUnsafe(address) {
+			banned = append(banned, p.banned[address])
+		}
+	}
+	p.lock.Unlock()
+	sort.Slice(banned, func(i, j int) bool { return banned[i].Address < banned[j].Address })
+	return banned
+}
+
+func (p *HippoPeerScores) scoreUnsafe(address string) int {
+	if score, has := p.scores[address]; has {
+		return score
+	}
+	return DefaultPeerScore
+}
+
+// setScoreUnsafe ...
+// The score never exceeds DefaultPeerScore, where the peer is forgotten.
+func (p *HippoPeerScores) setScoreUnsafe(peer string, score int) {
+	if score >= DefaultPeerScore {
+		delete(p.scores, peer)
+		return
+	}
+	p.scores[peer] = score
+	if len(p.scores) > MaxPeerScores {
+		p.pruneUnsafe(peer)
+	}
+}
+
+// pruneUnsafe ...
+// Drop the expired bans, then the best scores beyond MaxPeerScores but the one of keep.
+// Banned peers keep their scores until the ban expires.
+func (p *HippoPeerScores) pruneUnsafe(keep string) {
+	for peer := range p.banned {
+		p.isBannedUnsafe(peer)
+	}
+	peers := make([]string, 0, len(p.scores))
+	for peer := range p.scores {
+		if _, banned := p.banned[peer]; !banned && peer != keep {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return p.scores[peers[i]] > p.scores[peers[j]] })
+	for i := 0; i < len(peers) && len(p.scores) > MaxPeerScores; i++ {
+		delete(p.scores, peers[i])
+	}
+}
+
+func (p *HippoPeerScores) banUnsafe(address string, reason string) {
+	infoLogger.Warn("peer score: ban", address, "for", p.banDuration, "seconds:", reason)
+	p.banned[address] = BannedPeer{
+		Address: address,
+		Reason:  reason,
+		Until:   time.Now().Unix() + p.banDuration,
+	}
+}
+
+// isBannedUnsafe ...
+// An expired ban is removed.
+func (p *HippoPeerScores) isBannedUnsafe(address string) bool {
+	ban, has := p.banned[address]
+	if !has {
+		return false
+	}
+	if time.Now().Unix() < ban.Until {
+		return true
+	}
+	delete(p.banned, address)
+	delete(p.scores, address)
+	return false
+}
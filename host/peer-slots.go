@@ -0,0 +1,96 @@
+package host
+
+import (
+	"net"
+)
+
+// Neighbor slots:
+// - Outbound neighbors are dialed by UpdateNeighbors, up to maxOutbound.
+// - Inbound neighbors are peers that handshake with the P2P server, up to maxInbound.
+// - Half of the kept neighbors of each direction come from different address groups,
+//   so that a cluster of nearby nodes cannot take all the slots.
+
+// DefaultMaxInbound ...
+const DefaultMaxInbound = 8
+
+// addressGroup ...
+// Peers in one group are likely run by the same party: the /16 of an IPv4
+// address, the /32 of an IPv6 address, or the host name.
+// Loopback addresses are groups of their own, so that local networks are not limited.
+func addressGroup(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if host == "localhost" {
+		return address
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.IsLoopback():
+		return address
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(16, 32)).String() + "/16"
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String() + "/32"
+}
+
+// diversify ...
+// Reorder candidates so that the first one of each group not in neighbors comes first.
+// Otherwise the order is kept.
+func diversify(candidates []string, neighbors []string) []string {
+	groups := make(map[string]bool)
+	for _, n := range neighbors {
+		groups[addressGroup(n)] = true
+	}
+	first := make([]string, 0, len(candidates))
+	rest := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if g := addressGroup(c); !groups[g] {
+			groups[g] = true
+			first = append(first, c)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	return append(first, rest...)
+}
+
+// keepDiverse ...
+// sorted is ordered from the best neighbor. Keep the best one of each group up to
+// half of keep, then the best of the rest. Return the ones to evict.
+func keepDiverse(sorted []NeighborPing, keep int) (evicted []NeighborPing) {
+	if len(sorted) <= keep {
+		return nil
+	}
+	kept := make([]bool, len(sorted))
+	groups := make(map[string]bool)
+	count := 0
+	for i, n := range sorted {
+		if count >= (keep+1)/2 {
+			break
+		}
+		if g := addressGroup(n.Address); !groups[g] {
+			groups[g] = true
+			kept[i] = true
+			count++
+		}
+	}
+	for i := range sorted {
+		if count >= keep {
+			break
+		}
+		if !kept[i] {
+			kept[i] = true
+			count++
+		}
+	}
+	for i, n := range sorted {
+		if !kept[i] {
+			evicted = append(evicted, n)
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,82 @@
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestPeerScores(t *testing.T) {
+	initTest(1)
+	scores := new(HippoPeerScores)
+	scores.New(DefaultBanThreshold, 3600)
+	disconnected := make([]string, 0)
+	scores.SetBanHandler(func(address string) { disconnected = append(disconnected, address) })
+
+	assertT(scores.Score("a:1") == DefaultPeerScore, t)
+	assertT(!scores.Penalize("a:1", PenaltyBadSignature, "bad signature"), t)
+	assertT(scores.Score("a:1") == DefaultPeerScore-PenaltyBadSignature, t)
+	scores.Reward("a:1", 1000)
+	assertT(scores.Score("a:1") == DefaultPeerScore, t)
+
+	assertT(!scores.Penalize("a:1", PenaltyInvalidBlock, "invalid block"), t)
+	assertT(scores.Penalize("a:1", PenaltyInvalidBlock, "invalid block"), t)
+	assertT(scores.IsBanned("a:1") && !scores.IsBanned("b:1"), t)
+	assertT(len(disconnected) == 1 && disconnected[0] == "a", t)
+	banned := scores.GetBanned()
+	assertT(len(banned) == 1 && banned[0].Reason == "invalid block", t)
+
+	// No more penalties while banned.
+	assertT(!scores.Penalize("a:1", PenaltyInvalidBlock, "invalid block"), t)
+	scores.Unban("a:1")
+	assertT(!scores.IsBanned("a:1") && scores.Score("a:1") == DefaultPeerScore, t)
+
+	// A peer is the same on any port, and forgotten once back at the default score.
+	assertT(!scores.Penalize("10.0.0.1:9000", PenaltySpam, "spam"), t)
+	assertT(scores.Score("10.0.0.1:50000") == DefaultPeerScore-PenaltySpam, t)
+	assertT(scores.Scores()["10.0.0.1"] == DefaultPeerScore-PenaltySpam, t)
+	scores.Ban("10.0.0.1:50000", "test")
+	assertT(scores.IsBanned("10.0.0.1:9000") && disconnected[1] == "10.0.0.1", t)
+	scores.Unban("10.0.0.1")
+	scores.Reward("127.0.0.1:1", 1)
+	assertT(!scores.Penalize("127.0.0.1:50000", PenaltySpam, "spam"), t)
+	scores.Reward("127.0.0.1:50001", PenaltySpam)
+	assertT(len(scores.Scores()) == 0, t)
+
+	// Scores are pruned, and the last penalty is kept.
+	for i := 0; i <= MaxPeerScores; i++ {
+		scores.Penalize(fmt.Sprintf("10.1.%d.%d:1", i/256, i%256), 1+i%3, "spam")
+	}
+	assertT(len(scores.Scores()) == MaxPeerScores, t)
+	last := fmt.Sprintf("10.1.%d.%d", MaxPeerScores/256, MaxPeerScores%256)
+	assertT(scores.Scores()[last] == DefaultPeerScore-1-MaxPeerScores%3, t)
+
+	// An expired ban is lifted.
+	expiring := new(HippoPeerScores)
+	expiring.New(DefaultBanThreshold, 0)
+	expiring.Ban("b:1", "test")
+	assertT(!expiring.IsBanned("b:1") && len(expiring.GetBanned()) == 0, t)
+}
+
+func TestPeerScoresBroadcastBlock(t *testing.T) {
+	initTest(1)
+	scores := new(HippoPeerScores)
+	scores.New(DefaultBanThreshold, 3600)
+	blockTemplate := new(HippoBlock)
+	blockTemplate.New([]byte{}, 0, testHashfunction, 0, testBalance, testCurve)
+
+	s := new(P2PServer)
+	s.setBlockTemplate(blockTemplate)
+	s.setPeerScores(scores)
+
+	// The connection is penalized, not the sender claimed in the payload.
+	data, _ := json.Marshal(BroadcastBlock{Data: []byte("garbage"), Sender: "a:1"})
+	for i := 0; i*PenaltyUndecodable < DefaultPeerScore-DefaultBanThreshold; i++ {
+		var reply string
+		assertT(s.broadcastBlock("c:1", data, &reply) == nil && reply == "decode fail", t)
+	}
+	assertT(scores.IsBanned("c:1") && scores.Score("a:1") == DefaultPeerScore, t)
+	var reply string
+	assertT(s.broadcastBlock("c:1", data, &reply) == errBanned, t)
+	assertT(s.broadcastBlock("d:1", data, &reply) == nil, t)
+}
@@ -0,0 +1,133 @@
+package host
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestAddressGroup(t *testing.T) {
+	assertT(addressGroup("10.1.2.3:9000") == addressGroup("10.1.200.4:9001"), t)
+	assertT(addressGroup("10.1.2.3:9000") != addressGroup("10.2.2.3:9000"), t)
+	assertT(addressGroup("[2001:db8::1]:9000") == addressGroup("[2001:db8:0:1::2]:9000"), t)
+	assertT(addressGroup("127.0.0.1:9000") != addressGroup("127.0.0.1:9001"), t)
+	assertT(addressGroup("localhost:9000") != addressGroup("localhost:9001"), t)
+	assertT(addressGroup("seed.example.com:9000") == "seed.example.com", t)
+
+	candidates := []string{"10.1.0.1:1", "10.1.0.2:1", "10.2.0.1:1", "10.3.0.1:1"}
+	ordered := diversify(candidates, []string{"10.2.0.9:1"})
+	assertT(ordered[0] == "10.1.0.1:1" && ordered[1] == "10.3.0.1:1", t)
+	assertT(ordered[2] == "10.1.0.2:1" && ordered[3] == "10.2.0.1:1", t)
+
+	assertT(externalAddress("1.2.3.4", "192.168.0.2:11001") == "1.2.3.4:11001", t)
+	assertT(externalAddress("1.2.3.4:9000", "192.168.0.2:11001") == "1.2.3.4:9000", t)
+}
+
+func TestKeepDiverse(t *testing.T) {
+	// Best first: a cluster in 10.1/16 and two distant peers.
+	sorted := []NeighborPing{
+		{Address: "10.1.0.1:1"}, {Address: "10.1.0.2:1"}, {Address: "10.1.0.3:1"},
+		{Address: "10.1.0.4:1"}, {Address: "10.2.0.1:1"}, {Address: "10.3.0.1:1"},
+	}
+	// Half of the slots go to the best of each group, the rest to the best others.
+	evicted := keepDiverse(sorted, 4)
+	assertT(len(evicted) == 2, t)
+	assertT(evicted[0].Address == "10.1.0.4:1" && evicted[1].Address == "10.3.0.1:1", t)
+	evicted = keepDiverse(sorted, 5)
+	assertT(len(evicted) == 1 && evicted[0].Address == "10.1.0.4:1", t)
+	assertT(keepDiverse(sorted, 6) == nil, t)
+	assertT(len(keepDiverse(sorted, 0)) == 6, t)
+}
+
+func TestInboundSlots(t *testing.T) {
+	initTest(0)
+	_, second := newTestSlowServer(t)
+	defer second.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scores := new(HippoPeerScores)
+	scores.New(DefaultBanThreshold, 3600)
+	scores.Ban("10.2.0.1", "test")
+	client := new(HippoNetworkClient)
+	client.New(ctx, "127.0.0.1:1", ProtocolTCP, 1, nil, 1, 1, new(P2PClient), nil)
+	client.SetTransport(new(TCPTransport))
+	client.SetPeerScores(scores)
+	client.SetMaxInbound(1)
+
+	// A banned connection is not registered.
+	assertT(!client.AddInbound("10.2.0.1:50000", HandshakeInfo{}, nil), t)
+
+	// The connection is registered, whatever address it claims: it is never dialed.
+	assertT(client.AddInbound("10.0.0.1:50000", HandshakeInfo{Address: "127.0.0.1:1"}, nil), t)
+	assertT(client.countInbound() == 1 && client.CountNeighbors() == 0, t)
+	assertT(client.IsKnownNeighbor("127.0.0.1:1") && !client.IsKnownNeighbor("10.0.0.1:50000"), t)
+
+	// The inbound slot is taken, but the outbound ones are still free.
+	assertT(!client.AddInbound("10.1.0.1:50000", HandshakeInfo{}, nil), t)
+	client.Ping(second.Addr().String())
+	assertT(client.countInbound() == 1 && client.countOutbound() == 1, t)
+
+	client.EvictNeighbors()
+	assertT(client.countInbound() == 1 && client.CountNeighbors() == 1, t)
+	client.SetMaxInbound(0)
+	client.EvictNeighbors()
+	assertT(len(client.GetInboundNeighbors()) == 0, t)
+	assertT(client.CountNeighbors() == 1 && client.GetNeighbors()[0] == second.Addr().String(), t)
+
+	// A closed connection frees its slot.
+	client.SetMaxInbound(1)
+	assertT(client.AddInbound("10.1.0.1:50000", HandshakeInfo{}, nil), t)
+	client.RemoveInbound("10.1.0.1:50000")
+	assertT(client.countInbound() == 0, t)
+
+	// A ban drops the connections of the peer on every port, outbound and inbound.
+	scores.SetBanHandler(client.DisconnectPeer)
+	assertT(client.AddInbound("127.0.0.1:50000", HandshakeInfo{}, nil), t)
+	scores.Ban("127.0.0.1:50001", "test")
+	assertT(client.countInbound() == 0 && client.CountNeighbors() == 0, t)
+	assertT(!client.AddInbound("127.0.0.1:50002", HandshakeInfo{}, nil), t)
+}
+
+func TestInboundSlotsServer(t *testing.T) {
+	initTest(0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assertT(err == nil, t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := new(HippoNetworkClient)
+	network.New(ctx, "127.0.0.1:1", ProtocolTCP, 1, nil, 1, 1, new(P2PClient), nil)
+	network.SetMaxInbound(1)
+
+	s := new(P2PServer)
+	s.new(ctx, listener)
+	defer s.close()
+	s.setHandshake(func() HandshakeInfo { return HandshakeInfo{Version: ProtocolVersion} })
+	s.setNetworkClient(network)
+	s.serve()
+
+	dial := func() *P2PClient {
+		client := new(P2PClient)
+		client.SetTransport(new(TCPTransport))
+		assertT(client.New(ctx, ProtocolTCP, listener.Addr().String()) == nil, t)
+		return client
+	}
+	first, second := dial(), dial()
+	defer first.Close()
+	defer second.Close()
+	var reply string
+	_, err = first.Handshake(HandshakeInfo{Version: ProtocolVersion})
+	assertT(err == nil && network.countInbound() == 1, t)
+
+	// The second inbound connection is refused, then closed.
+	_, err = second.Handshake(HandshakeInfo{Version: ProtocolVersion})
+	assertT(err != nil && err.Error() == ErrInboundFull.Error(), t)
+	assertT(second.Ping("", &reply) != nil, t)
+	assertT(network.countInbound() == 1 && first.Ping("", &reply) == nil, t)
+
+	// An evicted connection is closed.
+	network.SetMaxInbound(0)
+	network.EvictNeighbors()
+	assertT(network.countInbound() == 0, t)
+	assertT(first.Ping("", &reply) != nil, t)
+}
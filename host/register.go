@@ -68,7 +68,8 @@ func (r *HippoRegister) Client() *registerlib.Client {
 	var err error
 	r.client, err = registerlib.CreateClient(r.protocol, r.address)
 	if err != nil {
-		infoLogger.Fatal("new register client error:", err)
+		// The register is only one of the discovery sources.
+		infoLogger.Error("new register client error:", err)
 		return nil
 	}
 	debugLogger.Debug("create register client success", r.client)
@@ -79,7 +80,9 @@ func (r *HippoRegister) Client() *registerlib.Client {
 // Stop ...
 func (r *HippoRegister) Stop() {
 	r.cancel()
-	r.client.Close()
+	if r.client != nil {
+		r.client.Close()
+	}
 }
 
 // Refresh ...
@@ -1,19 +1,94 @@
 package host
 
-import (
-	"math"
+import "sync"
+
+// RewardSchedule ...
+// The block reward starts at Initial and halves every HalvingInterval levels.
+// The total reward never exceeds MaxSupply: the block reaching the cap gets
+// only the rest, and later blocks get nothing but fees.
+type RewardSchedule struct {
+	Initial         uint64
+	HalvingInterval int
+	MaxSupply       uint64
+}
+
+// DefaultRewardSchedule ...
+var DefaultRewardSchedule = RewardSchedule{
+	Initial:         1000,
+	HalvingInterval: 1000,
+	MaxSupply:       2000000,
+}
+
+var (
+	rewardScheduleLock sync.Mutex
+	rewardSchedule     = DefaultRewardSchedule
 )
 
-// RewardFunc ...
-type RewardFunc func(Block) int64
+// SetRewardSchedule ...
+// Every node of a network must use the same schedule.
+func SetRewardSchedule(schedule RewardSchedule) {
+	rewardScheduleLock.Lock()
+	defer rewardScheduleLock.Unlock()
+	rewardSchedule = schedule
+}
+
+// GetRewardSchedule ...
+func GetRewardSchedule() RewardSchedule {
+	rewardScheduleLock.Lock()
+	defer rewardScheduleLock.Unlock()
+	return rewardSchedule
+}
+
+// baseReward ...
+// The reward at level before the cap.
+func (s RewardSchedule) baseReward(level int) uint64 {
+	if s.HalvingInterval <= 0 {
+		return s.Initial
+	}
+	halvings := level / s.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return s.Initial >> uint(halvings)
+}
 
-func basicReward(block Block) int64 {
-	var power = 0
-	if block.GetLevel()/1000 < 3 {
-		power = 3 - int(block.GetLevel()/1000)
+// Supply ...
+// The total reward of levels [0, level).
+func (s RewardSchedule) Supply(level int) uint64 {
+	var supply uint64
+	for start := 0; start < level; {
+		end := level
+		if s.HalvingInterval > 0 && start+s.HalvingInterval-start%s.HalvingInterval < end {
+			end = start + s.HalvingInterval - start%s.HalvingInterval
+		}
+		reward := s.baseReward(start)
+		if reward == 0 {
+			break
+		}
+		if uint64(end-start) > (s.MaxSupply-supply)/reward {
+			return s.MaxSupply
+		}
+		supply += uint64(end-start) * reward
+		start = end
 	}
-	return int64(math.Pow10(power))
+	if supply > s.MaxSupply {
+		return s.MaxSupply
+	}
+	return supply
 }
 
 // Reward ...
-var Reward = basicReward
+// The reward of the block at level.
+func (s RewardSchedule) Reward(level int) uint64 {
+	reward := s.baseReward(level)
+	if rest := s.MaxSupply - s.Supply(level); reward > rest {
+		return rest
+	}
+	return reward
+}
+
+// Reward ...
+// The reward of a block under the current schedule, without fees.
+func Reward(block Block) uint64 {
+	return GetRewardSchedule().Reward(block.GetLevel())
+}
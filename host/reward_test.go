@@ -0,0 +1,26 @@
+package host
+
+import "testing"
+
+func TestRewardSchedule(t *testing.T) {
+	schedule := RewardSchedule{Initial: 100, HalvingInterval: 10, MaxSupply: 1500}
+	assertT(schedule.Reward(0) == 100 && schedule.Reward(9) == 100, t)
+	assertT(schedule.Reward(10) == 50 && schedule.Reward(19) == 50, t)
+	assertT(schedule.Supply(0) == 0 && schedule.Supply(10) == 1000, t)
+	assertT(schedule.Supply(15) == 1250, t)
+
+	// The cap is reached at level 20, where 1500 = 1000 + 500.
+	assertT(schedule.Supply(20) == 1500, t)
+	assertT(schedule.Reward(20) == 0 && schedule.Supply(1000) == 1500, t)
+
+	// A cap in the middle of a block reward.
+	schedule.MaxSupply = 1030
+	assertT(schedule.Reward(10) == 30 && schedule.Reward(11) == 0, t)
+
+	// The total never exceeds the cap.
+	var total uint64
+	for level := 0; level < 100; level++ {
+		total += schedule.Reward(level)
+	}
+	assertT(total == schedule.MaxSupply, t)
+}
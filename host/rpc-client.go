@@ -2,12 +2,39 @@ package host
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/rpc"
+	"sync"
+	"time"
 )
 
 // P2PServiceName ...
 const P2PServiceName = "github.com/XieGuochao/HippoCoin"
 
+// DefaultCallTimeout ...
+const DefaultCallTimeout = 10 * time.Second
+
+var (
+	// ErrCallTimeout ...
+	ErrCallTimeout = errors.New("rpc call timeout")
+	// ErrTooManyInFlight ...
+	ErrTooManyInFlight = errors.New("too many rpc calls in flight")
+)
+
+// ClientStats ...
+// Rejected counts the calls refused by the in-flight cap; they are not in Calls.
+// Broken tells that the connection is closed and has to be dialed again.
+type ClientStats struct {
+	Calls    uint64
+	Failures uint64
+	Timeouts uint64
+	Rejected uint64
+	InFlight int
+	Broken   bool
+}
+
 // ==============================================================
 
 // P2PClientInterface ...
@@ -15,10 +42,15 @@ const P2PServiceName = "github.com/XieGuochao/HippoCoin"
 // One connection should handle multiple queries before close.
 // P2P queries include:
 // - Ping
+// - Handshake
 // - Broadcast
+// - Inv, QueryTransactions: inventory-based gossip
 // - QueryLevel
 // - QueryByHash: require SetTemplateBlock(block)
-// - QueryHashes: require SetTemplateBlock(block)
+// - QueryHashes: require SetTemplateBlock(block). With the binary codec, in one GetBlocks.
+// - QueryTransactionProof
+// - GetTip, GetHeaders: headers-first sync
+// - GetPeers: peer exchange
 type P2PClientInterface interface {
 	Empty() P2PClientInterface
 	New(ctx context.Context, protocol string, address string) error
@@ -26,13 +58,24 @@ type P2PClientInterface interface {
 	Close()
 
 	SetTemplateBlock(b Block)
+	SetCodec(codec string)
+	SetTransport(transport Transport)
+	SetLimits(callTimeout time.Duration, maxInFlight int)
+	Stats() ClientStats
 
 	Ping(request string, reply *string) error
+	Handshake(local HandshakeInfo) (remote HandshakeInfo, err error)
 	BroadcastBlock(data NetworkSendInterface, reply *string) error
 	BroadcastTransaction(data NetworkSendInterface, reply *string) error
+	Inv(inv Inventory, reply *string) error
+	QueryTransactions(hashes []string) (transactions []Transaction)
 	QueryLevel(level0, level1 int, reply *[]string) error
 	QueryByHash(hashValue string) (block Block)
 	QueryHashes(hashes []string) (block []Block)
+	QueryTransactionProof(transactionHash string) (proof TransactionProof, ok bool)
+	GetTip() (tip TipInfo, ok bool)
+	GetHeaders(locator []string, max int) (headers []BlockHeader, ok bool)
+	GetPeers(max int) (peers []string, ok bool)
 }
 
 // P2PClient ...
@@ -44,6 +87,13 @@ type P2PClient struct {
 	protocol      string
 	address       string
 	templateBlock Block
+	codec         string
+	transport     Transport
+
+	callTimeout time.Duration
+	maxInFlight int
+	statsLock   sync.Mutex
+	stats       ClientStats
 }
 
 // Empty ...
@@ -56,7 +106,11 @@ func (c *P2PClient) New(ctx context.Context, protocol string, address string) (e
 	c.protocol, c.address = protocol, address
 	c.ctx, c.cancel = context.WithCancel(ctx)
 	c.parentCtx = ctx
-	c.c, err = rpc.DialHTTP(protocol, address)
+	if c.transport != nil {
+		c.c, err = dialRPC(c.transport, address)
+	} else {
+		c.c, err = rpc.DialHTTP(protocol, address)
+	}
 	if err != nil {
 		infoLogger.Error(err, protocol, address)
 	}
@@ -66,9 +120,12 @@ func (c *P2PClient) New(ctx context.Context, protocol string, address string) (e
 // Copy ...
 func (c *P2PClient) Copy() P2PClientInterface {
 	newClient := new(P2PClient)
+	newClient.transport = c.transport
+	newClient.callTimeout, newClient.maxInFlight = c.callTimeout, c.maxInFlight
 	if newClient.New(c.parentCtx, c.protocol, c.address) != nil {
 		return nil
 	}
+	newClient.codec = c.codec
 	return newClient
 }
 
@@ -85,14 +142,92 @@ func (c *P2PClient) Close() {
 // SetTemplateBlock ...
 func (c *P2PClient) SetTemplateBlock(block Block) { c.templateBlock = block }
 
+// SetCodec ...
+// The codec for broadcasts and GetBlocks. Use CodecBinary only if the peer supports it.
+func (c *P2PClient) SetCodec(codec string) { c.codec = codec }
+
+// SetTransport ...
+// Call it before New. Without a transport, New dials plain net/rpc with protocol.
+func (c *P2PClient) SetTransport(transport Transport) { c.transport = transport }
+
+// SetLimits ...
+// Every call fails with ErrCallTimeout after callTimeout, or DefaultCallTimeout if it is 0.
+// A call is refused with ErrTooManyInFlight if maxInFlight calls are running. 0 means no cap.
+func (c *P2PClient) SetLimits(callTimeout time.Duration, maxInFlight int) {
+	c.callTimeout, c.maxInFlight = callTimeout, maxInFlight
+}
+
+// Stats ...
+func (c *P2PClient) Stats() ClientStats {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	return c.stats
+}
+
+// call ...
+// Call method of the P2P service with a deadline.
+func (c *P2PClient) call(method string, args interface{}, reply interface{}) error {
+	c.statsLock.Lock()
+	if c.maxInFlight > 0 && c.stats.InFlight >= c.maxInFlight {
+		c.stats.Rejected++
+		c.statsLock.Unlock()
+		return ErrTooManyInFlight
+	}
+	c.stats.InFlight++
+	c.statsLock.Unlock()
+
+	timeout := c.callTimeout
+	if timeout <= 0 {
+		timeout = DefaultCallTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	call := c.c.Go(P2PServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-timer.C:
+		err = ErrCallTimeout
+	case <-c.ctx.Done():
+		err = c.ctx.Err()
+	}
+
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	c.stats.InFlight--
+	c.stats.Calls++
+	if err != nil {
+		c.stats.Failures++
+	}
+	switch err {
+	case ErrCallTimeout:
+		c.stats.Timeouts++
+	case rpc.ErrShutdown, io.EOF, io.ErrUnexpectedEOF:
+		c.stats.Broken = true
+	}
+	return err
+}
+
 // Ping ...
 func (c *P2PClient) Ping(request string, reply *string) error {
-	return c.c.Call(P2PServiceName+".Ping", request, reply)
+	return c.call("Ping", request, reply)
+}
+
+// Handshake ...
+func (c *P2PClient) Handshake(local HandshakeInfo) (remote HandshakeInfo, err error) {
+	var reply []byte
+	if err = c.call("Handshake", local, &reply); err != nil {
+		return remote, err
+	}
+	err = json.Unmarshal(reply, &remote)
+	return remote, err
 }
 
 // QueryLevel ...
 func (c *P2PClient) QueryLevel(level0, level1 int, reply *[]string) error {
-	err := c.c.Call(P2PServiceName+".QueryLevel",
+	err := c.call("QueryLevel",
 		QueryLevelStruct{
 			Level0: level0,
 			Level1: level1,
@@ -106,7 +241,7 @@ func (c *P2PClient) QueryLevel(level0, level1 int, reply *[]string) error {
 // QueryByHash ...
 func (c *P2PClient) QueryByHash(hashValue string) (block Block) {
 	var reply []byte
-	err := c.c.Call(P2PServiceName+".QueryByHash",
+	err := c.call("QueryByHash",
 		hashValue, &reply)
 	if err != nil {
 		infoLogger.Error("query by hash: cannot decode block:", err)
@@ -127,6 +262,24 @@ func (c *P2PClient) QueryByHash(hashValue string) (block Block) {
 // QueryHashes ...
 func (c *P2PClient) QueryHashes(hashes []string) (block []Block) {
 	block = make([]Block, 0)
+	if c.codec == CodecBinary && c.templateBlock != nil {
+		// The server refuses more than MaxHashes at once.
+		for len(hashes) > 0 {
+			batch := hashes
+			if len(batch) > DefaultServerLimits.MaxHashes {
+				batch = batch[:DefaultServerLimits.MaxHashes]
+			}
+			var reply []byte
+			err := c.call("GetBlocks",
+				GetBlocksStruct{Hashes: batch, Codec: c.codec}, &reply)
+			if err != nil {
+				infoLogger.Error("get blocks:", err)
+				break
+			}
+			block = append(block, DecodeBlockList(reply, c.templateBlock, c.codec)...)
+			hashes = hashes[len(batch):]
+		}
+	}
 	for _, h := range hashes {
 		if b := c.QueryByHash(h); b != nil {
 			block = append(block, b)
@@ -135,6 +288,100 @@ func (c *P2PClient) QueryHashes(hashes []string) (block []Block) {
 	return block
 }
 
+// QueryTransactions ...
+// Require SetTemplateBlock(block) for the curve and hash function.
+func (c *P2PClient) QueryTransactions(hashes []string) (transactions []Transaction) {
+	var reply []byte
+	transactions = make([]Transaction, 0)
+	if c.templateBlock == nil {
+		infoLogger.Error("query transactions: no template block")
+		return
+	}
+	if err := c.call("QueryTransactions", hashes, &reply); err != nil {
+		infoLogger.Error("query transactions:", err)
+		return
+	}
+	var transactionsBytes [][]byte
+	if err := json.Unmarshal(reply, &transactionsBytes); err != nil {
+		infoLogger.Error("query transactions: cannot decode:", err)
+		return
+	}
+	for _, bytes := range transactionsBytes {
+		tr := DecodeTransaction(bytes, c.templateBlock.GetHashFunction(),
+			c.templateBlock.GetCurve())
+		if tr != nil {
+			transactions = append(transactions, tr)
+		}
+	}
+	return
+}
+
+// QueryTransactionProof ...
+func (c *P2PClient) QueryTransactionProof(transactionHash string) (proof TransactionProof, ok bool) {
+	var reply []byte
+	err := c.call("QueryTransactionProof",
+		transactionHash, &reply)
+	if err != nil {
+		infoLogger.Error("query transaction proof:", err)
+		return proof, false
+	}
+	if len(reply) == 0 {
+		return proof, false
+	}
+	if err = json.Unmarshal(reply, &proof); err != nil {
+		infoLogger.Error("query transaction proof: cannot decode proof:", err)
+		return proof, false
+	}
+	return proof, true
+}
+
+// GetTip ...
+func (c *P2PClient) GetTip() (tip TipInfo, ok bool) {
+	var reply []byte
+	if err := c.call("GetTip", "", &reply); err != nil {
+		infoLogger.Error("get tip:", err)
+		return tip, false
+	}
+	if err := json.Unmarshal(reply, &tip); err != nil {
+		infoLogger.Error("get tip: cannot decode tip:", err)
+		return tip, false
+	}
+	return tip, true
+}
+
+// GetHeaders ...
+func (c *P2PClient) GetHeaders(locator []string, max int) (headers []BlockHeader, ok bool) {
+	var reply []byte
+	err := c.call("GetHeaders",
+		GetHeadersStruct{
+			Locator: locator,
+			Max:     max,
+		}, &reply)
+	if err != nil {
+		infoLogger.Error("get headers:", err)
+		return nil, false
+	}
+	if err = json.Unmarshal(reply, &headers); err != nil {
+		infoLogger.Error("get headers: cannot decode headers:", err)
+		return nil, false
+	}
+	return headers, true
+}
+
+// GetPeers ...
+func (c *P2PClient) GetPeers(max int) (peers []string, ok bool) {
+	var reply []byte
+	if err := c.call("GetPeers", max, &reply); err != nil {
+		infoLogger.Error("get peers:", err)
+		return nil, false
+	}
+	if err := json.Unmarshal(reply, &peers); err != nil {
+		infoLogger.Error("get peers: cannot decode peers:", err)
+		return nil, false
+	}
+	return peers, true
+}
+
 // // BroadcastData ...
 // func (c *P2PClient) BroadcastData(data NetworkSendInterface, reply *string) error {
 // 	return c.c.Call(P2PServiceName+".BroadcastData", data.Encode(), reply)
@@ -143,11 +390,18 @@ func (c *P2PClient) QueryHashes(hashes []string) (block []Block) {
 // BroadcastBlock ...
 func (c *P2PClient) BroadcastBlock(data NetworkSendInterface, reply *string) error {
 	// debugLogger.Debug("broadcastBlock to send", data)
-	return c.c.Call(P2PServiceName+".BroadcastBlock", data.Encode(), reply)
+	data.SetCodec(c.codec)
+	return c.call("BroadcastBlock", data.Encode(), reply)
 }
 
 // BroadcastTransaction ...
 func (c *P2PClient) BroadcastTransaction(data NetworkSendInterface, reply *string) error {
 	// debugLogger.Debug("broadcastBlock to send", data)
-	return c.c.Call(P2PServiceName+".BroadcastTransaction", data.Encode(), reply)
+	data.SetCodec(c.codec)
+	return c.call("BroadcastTransaction", data.Encode(), reply)
+}
+
+// Inv ...
+func (c *P2PClient) Inv(inv Inventory, reply *string) error {
+	return c.call("Inv", inv, reply)
 }
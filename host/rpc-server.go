@@ -1,11 +1,17 @@
 package host
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/rpc"
+	"sync"
+	"time"
 )
 
 // P2PServiceInterface ...
@@ -15,6 +21,7 @@ import (
 // 3. setBroadcastQueue(broadcastQueue)
 // 4. setBlockTemplate(block) : curve, hashFunction, balance
 // 5. serve()
+// 6. close()
 type P2PServiceInterface interface {
 	new(context.Context, net.Listener)
 	setStorage(Storage)
@@ -22,11 +29,27 @@ type P2PServiceInterface interface {
 	setTransactionPool(tp TransactionPool)
 	setBlockTemplate(block Block)
 	setTransactionTemplate(tr Transaction)
+	setHandshake(f HandshakeFunc)
+	setTransport(transport Transport)
+	setPeers(f func() []string)
+	setPeerScores(scores PeerScores)
+	setNetworkClient(networkClient NetworkClient)
+	setLimits(limits ServerLimits)
 	Ping(request string, reply *string) error
+	Handshake(info HandshakeInfo, infoBytes *[]byte) error
 	BroadcastBlock(sendBlockByte []byte, reply *string) error
+	BroadcastTransaction(sendBlockByte []byte, reply *string) error
+	Inv(inv Inventory, reply *string) error
 	QueryLevel(q QueryLevelStruct, reply *[]string) error
 	QueryByHash(h string, blockBytes *[]byte) error
+	QueryTransactionProof(transactionHash string, proofBytes *[]byte) error
+	QueryTransactions(hashes []string, transactionsBytes *[]byte) error
+	GetBlocks(q GetBlocksStruct, blocksBytes *[]byte) error
+	GetTip(request string, tipBytes *[]byte) error
+	GetHeaders(q GetHeadersStruct, headersBytes *[]byte) error
+	GetPeers(max int, peersBytes *[]byte) error
 	serve()
+	close()
 }
 
 // RegisterP2PService ...
@@ -45,27 +68,92 @@ type P2PServer struct {
 
 	blockTemplate       Block
 	transactionTemplate Transaction
+
+	handshake  HandshakeFunc
+	transport  Transport
+	peers      func() []string
+	peerScores PeerScores
+
+	networkClient NetworkClient
+	// seen: inventory type + hash of the data received or being requested.
+	seen SeenCache
+
+	limits  ServerLimits
+	limiter RateLimiter
+
+	// wg: the accept loop and the connections being served.
+	wg        sync.WaitGroup
+	connsLock sync.Mutex
+	conns     map[net.Conn]bool
 }
 
+// errBanned ...
+var errBanned = errors.New("banned")
+
+// errRefused ...
+// The calls on a connection after its handshake is refused.
+var errRefused = errors.New("handshake refused")
+
+// ErrInboundFull ...
+// The handshake of an inbound connection without a free slot.
+var ErrInboundFull = errors.New("no free inbound slot")
+
+// ErrNodeIDMismatch ...
+// The handshake of a peer whose certificate is not of the node ID it announces,
+// or not of the node ID pinned for its address.
+var ErrNodeIDMismatch = errors.New("node ID does not match the certificate")
+
 // new ...
 func (s *P2PServer) new(parentContext context.Context, listener net.Listener) {
 	s.ctx, s.cancel = context.WithCancel(parentContext)
 	s.listener = listener
-	if err := RegisterP2PService(s); err != nil {
-		infoLogger.Error("register p2p server error:", err)
-	}
-	rpc.HandleHTTP()
-	infoLogger.Info("start serving HTTP")
-	go http.Serve(listener, nil)
+	s.conns = make(map[net.Conn]bool)
+	s.seen = new(HippoSeenCache)
+	s.seen.New(DefaultSeenCapacity, DefaultSeenTTL)
+	s.setLimits(DefaultServerLimits)
 }
 
 func (s *P2PServer) setBlockTemplate(block Block) { s.blockTemplate = block }
 
 func (s *P2PServer) setTransactionTemplate(tr Transaction) { s.transactionTemplate = tr }
 
+func (s *P2PServer) setHandshake(f HandshakeFunc) { s.handshake = f }
+
+func (s *P2PServer) setTransport(transport Transport) { s.transport = transport }
+
+func (s *P2PServer) setPeers(f func() []string) { s.peers = f }
+
+func (s *P2PServer) setPeerScores(scores PeerScores) { s.peerScores = scores }
+
+func (s *P2PServer) setNetworkClient(networkClient NetworkClient) {
+	s.networkClient = networkClient
+}
+
+// setLimits ...
+// Zero fields take the defaults.
+func (s *P2PServer) setLimits(limits ServerLimits) {
+	s.limits = limits.withDefaults()
+	s.limiter = new(HippoRateLimiter)
+	s.limiter.New(s.limits.Rate, s.limits.Burst)
+}
+
+// isBanned ...
+func (s *P2PServer) isBanned(address string) bool {
+	return s.peerScores != nil && address != "" && s.peerScores.IsBanned(address)
+}
+
+// penalize ...
+func (s *P2PServer) penalize(address string, penalty int, reason string) {
+	if s.peerScores != nil {
+		s.peerScores.Penalize(address, penalty, reason)
+	}
+}
+
 // serve ...
 func (s *P2PServer) serve() {
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
 		for {
 			select {
 			case <-s.ctx.Done():
@@ -75,15 +163,105 @@ func (s *P2PServer) serve() {
 				conn, err := s.listener.Accept()
 				infoLogger.Warn("p2p server receive conn:", err)
 				if err != nil {
-					infoLogger.Error("p2p server accept error:", err)
-				} else {
-					go rpc.ServeConn(conn)
+					if s.ctx.Err() == nil {
+						infoLogger.Error("p2p server accept error:", err)
+					}
+				} else if s.track(conn) {
+					go func() {
+						defer s.wg.Done()
+						defer s.untrack(conn)
+						s.serveConn(conn)
+					}()
 				}
 			}
 		}
 	}()
 }
 
+// track ...
+// Count conn in wg, or close it if the server is closed.
+func (s *P2PServer) track(conn net.Conn) bool {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	if s.ctx.Err() != nil {
+		conn.Close()
+		return false
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	return true
+}
+
+func (s *P2PServer) untrack(conn net.Conn) {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	delete(s.conns, conn)
+}
+
+// close ...
+// Stop accepting, close the connections being served and wait for them.
+func (s *P2PServer) close() {
+	s.cancel()
+	s.listener.Close()
+	s.connsLock.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsLock.Unlock()
+	s.wg.Wait()
+}
+
+// serveConn ...
+// Answer the HTTP CONNECT of rpc.DialHTTP, then serve the RPCs of conn
+// under the limits of its peer. The TLS handshake and the CONNECT must be
+// done within connectTimeout.
+func (s *P2PServer) serveConn(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	nodeID := ""
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		err := tlsConn.Handshake()
+		if err == nil {
+			nodeID, err = PeerNodeID(tlsConn)
+		}
+		if err != nil {
+			infoLogger.Warn("p2p server: tls handshake:", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+	}
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil || request.Method != "CONNECT" || request.RequestURI != rpc.DefaultRPCPath {
+		io.WriteString(conn, "HTTP/1.0 405 must CONNECT\n\n")
+		conn.Close()
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+	conn.SetDeadline(time.Time{})
+
+	remoteAddress := conn.RemoteAddr().String()
+	c := &p2pConn{s: s, conn: conn, remote: remoteAddress, peer: PeerKey(remoteAddress),
+		nodeID: nodeID}
+	limitReader := newMessageLimitReader(reader, s.limits.withDefaults().MaxMessageSize)
+	limitReader.onTooLarge = func() {
+		c.violation(PenaltyOversized, "message too large")
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(P2PServiceName, c); err != nil {
+		infoLogger.Error("register p2p server error:", err)
+		conn.Close()
+		return
+	}
+	server.ServeConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{limitReader, conn, conn})
+	if s.networkClient != nil {
+		s.networkClient.RemoveInbound(remoteAddress)
+	}
+}
+
 // setStorage ...
 func (s *P2PServer) setStorage(storage Storage) { s.storage = storage }
 
@@ -101,6 +279,51 @@ func (s *P2PServer) Ping(request string, reply *string) error {
 	return nil
 }
 
+// Handshake ...
+// Reply the encoded local HandshakeInfo, or an error if the caller is incompatible.
+func (s *P2PServer) Handshake(info HandshakeInfo, infoBytes *[]byte) error {
+	return s.handshakeFrom("", "", nil, info, infoBytes)
+}
+
+// handshakeFrom ...
+// remoteAddress is the connection conn of the caller, which is registered as an
+// inbound neighbor. The handshake fails if no inbound slot is free.
+// nodeID is the node ID of the caller's certificate, "" without TLS: the caller
+// must announce it, and it must be the one pinned for the announced address.
+func (s *P2PServer) handshakeFrom(remoteAddress, nodeID string, conn io.Closer,
+	info HandshakeInfo, infoBytes *[]byte) error {
+	if s.handshake == nil {
+		return errors.New("handshake not supported")
+	}
+	local := s.handshake()
+	if err := CheckHandshake(local, info); err != nil {
+		infoLogger.Warn("handshake: refuse", info.Address, err)
+		return err
+	}
+	if nodeID != "" {
+		pinned, ok := "", false
+		if s.transport != nil && info.Address != "" {
+			pinned, ok = s.transport.PinnedNodeID(info.Address)
+		}
+		if info.NodeID != nodeID || ok && pinned != nodeID {
+			infoLogger.Warn("handshake: refuse", remoteAddress, info.Address, ErrNodeIDMismatch)
+			return ErrNodeIDMismatch
+		}
+	}
+	if s.networkClient != nil && remoteAddress != "" &&
+		!s.networkClient.AddInbound(remoteAddress, info, conn) {
+		infoLogger.Warn("handshake: refuse", remoteAddress, ErrInboundFull)
+		return ErrInboundFull
+	}
+	bytes, err := json.Marshal(local)
+	if err != nil {
+		infoLogger.Error("handshake:", err)
+		return err
+	}
+	*infoBytes = bytes
+	return nil
+}
+
 // // BroadcastData ...
 // func (s *P2PServer) BroadcastData(sendByte []byte, reply *string) error {
 // 	return nil
@@ -109,19 +332,40 @@ func (s *P2PServer) Ping(request string, reply *string) error {
 // BroadcastBlock ...
 func (s *P2PServer) BroadcastBlock(sendBlockByte []byte,
 	reply *string) error {
-	var receiveBlock BroadcastBlock
+	return s.broadcastBlock("", sendBlockByte, reply)
+}
+
+// broadcastBlock ...
+// peer is the connection the block arrived on, which is the one penalized.
+// Missing parents are pulled from the Sender in the payload only if it is the
+// listening address of peer, see pullSource.
+func (s *P2PServer) broadcastBlock(peer string, sendBlockByte []byte,
+	reply *string) error {
 	var (
 		block Block
 	)
-	debugLogger.Debug("broadcastBlock: receive bytes:", string(sendBlockByte))
+	debugLogger.Debug("broadcastBlock: receive bytes:", len(sendBlockByte))
 	// decode receiveBlock to get the data
-	json.Unmarshal(sendBlockByte, &receiveBlock)
+	receiveBlock, err := DecodeBroadcastBlock(sendBlockByte)
+	if err != nil {
+		*reply = "decode fail"
+		infoLogger.Error("broadcastBlock: cannot decode:", err)
+		return nil
+	}
+	if s.isBanned(peer) {
+		return errBanned
+	}
 
 	debugLogger.Debug("broadcastBlock: decode receiveBlock:", receiveBlock)
 
 	receiveBlock.block = DecodeBlock(receiveBlock.Data, s.blockTemplate)
 	// debugLogger.Debug("receive block:", broadcastBlock)
 	block = receiveBlock.block
+	if block == nil {
+		*reply = "decode fail"
+		s.penalize(peer, PenaltyUndecodable, "undecodable block")
+		return nil
+	}
 
 	debugLogger.Debug("after decode block:", block)
 	infoLogger.Info("rpc-server: receive broadcastBlock:", block.Hash())
@@ -129,16 +373,46 @@ func (s *P2PServer) BroadcastBlock(sendBlockByte []byte,
 	for i, tr := range block.GetTransactions() {
 		debugLogger.Debug("after decode: transaction", i, tr)
 	}
+	receiveBlock.Sender = s.pullSource(peer, receiveBlock.Sender)
+	*reply = s.receiveBlock(receiveBlock, peer)
+	return nil
+}
+
+// pullSource ...
+// The address to pull data from for the connection of peer, a PeerKey, given the
+// address the peer claims as its own. The claim is only a hint: it is used if it is
+// a known neighbor on the host of the connection, and "" is returned otherwise.
+func (s *P2PServer) pullSource(peer, sender string) string {
+	if sender == "" || peer == "" || PeerKey(sender) != peer || s.networkClient == nil ||
+		!s.networkClient.IsKnownNeighbor(sender) {
+		return ""
+	}
+	return sender
+}
+
+// receiveBlock ...
+// Check and store a block from peer, and relay it if it is new.
+// Its missing parents are pulled from receiveBlock.Sender first.
+func (s *P2PServer) receiveBlock(receiveBlock BroadcastBlock, peer string) (reply string) {
+	block := receiveBlock.block
+	if s.seen != nil {
+		s.seen.Add(InventoryBlock + block.Hash())
+	}
 	// Check block
 	if !block.Check() {
-		*reply = "check fail"
 		infoLogger.Error("broadcastBlock: check block failed", block.Hash())
-		return nil
+		s.penalize(peer, PenaltyInvalidBlock, "invalid block "+block.Hash())
+		return "check fail"
 	}
 
 	// If check block ok, add to storage
 	if s.storage != nil {
-		s.storage.Add(block)
+		if !s.storage.AddFrom(block, receiveBlock.Sender) {
+			return "not added"
+		}
+		if s.peerScores != nil {
+			s.peerScores.Reward(peer, 1)
+		}
 	} else {
 		debugLogger.Debug("no storage in rpc server")
 	}
@@ -150,47 +424,196 @@ func (s *P2PServer) BroadcastBlock(sendBlockByte []byte,
 	} else {
 		debugLogger.Debug("no broadcast queue in rpc server")
 	}
-
-	return nil
+	return ""
 }
 
 // BroadcastTransaction ...
 func (s *P2PServer) BroadcastTransaction(sendBlockByte []byte,
 	reply *string) error {
-	var receiveTransaction = BroadcastTransaction{
-		Addresses: make(map[string]bool),
-	}
+	return s.broadcastTransaction("", sendBlockByte, reply)
+}
+
+// broadcastTransaction ...
+// peer is the connection the transaction arrived on, which is the one penalized.
+func (s *P2PServer) broadcastTransaction(peer string, sendBlockByte []byte,
+	reply *string) error {
+	var receiveTransaction BroadcastTransaction
 	receiveTransaction.Data = sendBlockByte
 	receiveTransaction.transaction = s.transactionTemplate.CloneConstants()
-	receiveTransaction.Decode(s.transactionTemplate)
+	err := receiveTransaction.Decode(s.transactionTemplate)
+	if s.isBanned(peer) {
+		return errBanned
+	}
+	if err != nil {
+		*reply = "decode fail"
+		s.penalize(peer, PenaltyUndecodable, "undecodable transaction")
+		return nil
+	}
+	*reply = s.receiveTransaction(receiveTransaction.transaction, peer)
+	return nil
+}
 
+// receiveTransaction ...
+// Check a transaction from peer and add it to the transaction pool,
+// which relays it if it is new.
+func (s *P2PServer) receiveTransaction(tr Transaction, peer string) (reply string) {
+	if s.seen != nil {
+		s.seen.Add(InventoryTransaction + tr.Hash())
+	}
 	// Check transaction
-	if !receiveTransaction.transaction.CheckWithoutBalance() {
-		*reply = "check fail"
-		infoLogger.Error("broadcast transaction received: check fail", receiveTransaction.transaction.Hash())
-		return nil
+	if !tr.CheckWithoutBalance() {
+		infoLogger.Error("broadcast transaction received: check fail", tr.Hash())
+		if !tr.CheckSignatures() {
+			s.penalize(peer, PenaltyBadSignature, "bad signature "+tr.Hash())
+		} else {
+			s.penalize(peer, PenaltySpam, "invalid fee "+tr.Hash())
+		}
+		return "check fail"
 	}
 
 	// If check transaction ok, add to transaction pool
-	if s.transactionPool != nil {
-		s.transactionPool.Push(receiveTransaction.transaction)
-	} else {
+	if s.transactionPool == nil {
 		infoLogger.Error("no transaction pool in rpc server")
+		return ""
+	}
+	if !s.transactionPool.Push(tr) {
+		s.penalize(peer, PenaltySpam, "rejected transaction "+tr.Hash())
+		return "rejected"
 	}
+	return ""
+}
 
-	// and broadcast.
-	if s.broadcastQueue != nil {
-		receiveTransaction.Level++
-		s.broadcastQueue.AddTransaction(receiveTransaction)
-	} else {
-		debugLogger.Debug("no broadcast queue in rpc server")
+// Inv ...
+// Receive announced hashes, and pull the new ones from inv.Sender if it is the
+// caller, see pullSource.
+func (s *P2PServer) Inv(inv Inventory, reply *string) error {
+	return s.inv("", inv, reply)
+}
+
+// inv ...
+// peer is the connection the inventory arrived on, which is the one penalized.
+func (s *P2PServer) inv(peer string, inv Inventory, reply *string) error {
+	if s.isBanned(peer) {
+		return errBanned
+	}
+	if len(inv.Hashes) > MaxInventorySize {
+		s.penalize(peer, PenaltySpam, "oversized inventory")
+		return errors.New("too many hashes")
+	}
+	source := s.pullSource(peer, inv.Sender)
+	if source == "" {
+		debugLogger.Debug("inv: unknown sender", inv.Sender, "from", peer)
+		*reply = "unknown sender"
+		return nil
+	}
+	wanted := s.filterInventory(inv)
+	debugLogger.Debug("inv:", inv.Type, len(inv.Hashes), "wanted:", len(wanted))
+	if len(wanted) > 0 {
+		go s.getData(inv.Type, wanted, source)
 	}
+	*reply = "ok"
+	return nil
+}
 
+// filterInventory ...
+// Return the hashes neither stored nor requested before, and mark them as seen.
+func (s *P2PServer) filterInventory(inv Inventory) []string {
+	var hashes []string
+	switch inv.Type {
+	case InventoryBlock:
+		if s.storage == nil {
+			return nil
+		}
+		hashes = s.storage.FilterNewHashes(inv.Hashes)
+	case InventoryTransaction:
+		if s.transactionPool == nil {
+			return nil
+		}
+		for _, h := range inv.Hashes {
+			if !s.transactionPool.Has(h) {
+				hashes = append(hashes, h)
+			}
+		}
+	default:
+		return nil
+	}
+	wanted := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		if s.seen == nil || s.seen.Add(inv.Type+h) {
+			wanted = append(wanted, h)
+		}
+	}
+	return wanted
+}
+
+// getData ...
+// Pull hashes from sender. Those not received are forgotten, so that they
+// can be pulled from the next peer announcing them. The replies come from the
+// connection dialed to sender, so sender is the one penalized.
+func (s *P2PServer) getData(inventoryType string, hashes []string, sender string) {
+	requested := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		requested[h] = true
+	}
+	received := make(map[string]bool, len(hashes))
+	switch inventoryType {
+	case InventoryBlock:
+		for _, block := range s.networkClient.QueryHashes(sender, hashes) {
+			h := block.Hash()
+			if !requested[h] || received[h] {
+				s.penalize(sender, PenaltySpam, "unrequested block "+h)
+				continue
+			}
+			received[h] = true
+			s.receiveBlock(BroadcastBlock{block: block, Sender: sender}, sender)
+		}
+	case InventoryTransaction:
+		for _, tr := range s.networkClient.QueryTransactions(sender, hashes) {
+			h := tr.Hash()
+			if !requested[h] || received[h] {
+				s.penalize(sender, PenaltySpam, "unrequested transaction "+h)
+				continue
+			}
+			received[h] = true
+			s.receiveTransaction(tr, sender)
+		}
+	}
+	for _, h := range hashes {
+		if !received[h] && s.seen != nil {
+			s.seen.Remove(inventoryType + h)
+		}
+	}
+}
+
+// QueryTransactions ...
+// Reply the encoded transactions in the transaction pool.
+func (s *P2PServer) QueryTransactions(hashes []string, transactionsBytes *[]byte) error {
+	if s.transactionPool == nil {
+		return nil
+	}
+	if len(hashes) > s.limits.withDefaults().MaxHashes {
+		return limitErrorf("%d transaction hashes", len(hashes))
+	}
+	transactions := make([][]byte, 0, len(hashes))
+	for _, h := range hashes {
+		if tr, has := s.transactionPool.Get(h); has {
+			transactions = append(transactions, tr.Encode())
+		}
+	}
+	bytes, err := json.Marshal(transactions)
+	if err != nil {
+		infoLogger.Error("query transactions:", err)
+		return err
+	}
+	*transactionsBytes = bytes
 	return nil
 }
 
 // QueryLevel ...
 func (s *P2PServer) QueryLevel(q QueryLevelStruct, reply *[]string) error {
+	if span := q.Level1 - q.Level0; span < 0 || span > s.limits.withDefaults().MaxLevelSpan {
+		return limitErrorf("level span %d", span)
+	}
 	var hashes []string
 	if s.storage != nil {
 		hashes = s.storage.GetBlocksLevelHash(q.Level0, q.Level1)
@@ -209,3 +632,98 @@ func (s *P2PServer) QueryByHash(h string, blockBytes *[]byte) error {
 	}
 	return nil
 }
+
+// GetBlocks ...
+// Reply the blocks of q.Hashes in q.Codec. Unknown hashes are skipped.
+func (s *P2PServer) GetBlocks(q GetBlocksStruct, blocksBytes *[]byte) error {
+	if s.storage == nil {
+		return nil
+	}
+	if len(q.Hashes) > s.limits.withDefaults().MaxHashes {
+		return limitErrorf("%d block hashes", len(q.Hashes))
+	}
+	blocks := make([]Block, 0, len(q.Hashes))
+	for _, h := range q.Hashes {
+		if block, has := s.storage.Get(h); has {
+			blocks = append(blocks, block)
+		}
+	}
+	bytes, err := EncodeBlockList(blocks, q.Codec)
+	if err != nil {
+		infoLogger.Error("get blocks:", err)
+		return err
+	}
+	*blocksBytes = bytes
+	return nil
+}
+
+// QueryTransactionProof ...
+// Reply the encoded TransactionProof, or nothing if the transaction is not in the main chain.
+func (s *P2PServer) QueryTransactionProof(transactionHash string, proofBytes *[]byte) error {
+	if s.storage == nil {
+		return nil
+	}
+	proof, has := s.storage.GetTransactionProof(transactionHash)
+	if !has {
+		return nil
+	}
+	bytes, err := json.Marshal(proof)
+	if err != nil {
+		infoLogger.Error("query transaction proof:", err)
+		return err
+	}
+	*proofBytes = bytes
+	return nil
+}
+
+// GetTip ...
+// Reply the encoded TipInfo of the main chain.
+func (s *P2PServer) GetTip(request string, tipBytes *[]byte) error {
+	if s.storage == nil {
+		return nil
+	}
+	bytes, err := json.Marshal(localTip(s.storage))
+	if err != nil {
+		infoLogger.Error("get tip:", err)
+		return err
+	}
+	*tipBytes = bytes
+	return nil
+}
+
+// GetHeaders ...
+// Reply the encoded headers of the main chain after the fork point of q.Locator.
+func (s *P2PServer) GetHeaders(q GetHeadersStruct, headersBytes *[]byte) error {
+	if s.storage == nil {
+		return nil
+	}
+	if q.Max <= 0 || q.Max > MaxHeadersPerRequest {
+		q.Max = MaxHeadersPerRequest
+	}
+	bytes, err := json.Marshal(s.storage.GetHeaders(q.Locator, q.Max))
+	if err != nil {
+		infoLogger.Error("get headers:", err)
+		return err
+	}
+	*headersBytes = bytes
+	return nil
+}
+
+// GetPeers ...
+// Reply at most max encoded addresses of the known peers, for peer exchange.
+func (s *P2PServer) GetPeers(max int, peersBytes *[]byte) error {
+	peers := make([]string, 0)
+	if s.peers != nil {
+		peers = s.peers()
+	}
+	if max > 0 && len(peers) > max {
+		peers = peers[:max]
+	}
+	bytes, err := json.Marshal(peers)
+	if err != nil {
+		infoLogger.Error("get peers:", err)
+		return err
+	}
+	*peersBytes = bytes
+	return nil
+}
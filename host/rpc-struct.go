@@ -5,14 +5,13 @@ import "encoding/json"
 // NetworkSendInterface ...
 type NetworkSendInterface interface {
 	Encode() []byte
-	SetAddresses(map[string]bool)
 	SetLevel(uint)
+	SetCodec(codec string)
 }
 
 // NetworkReceiveInterface ...
 type NetworkReceiveInterface interface {
 	Decode(*interface{})
-	GetAddresses() map[string]bool
 	GetLevel() uint
 }
 
@@ -32,12 +31,29 @@ func (q QueryLevelStruct) Encode() []byte {
 	return bytes
 }
 
-// SetAddresses ...
-func (q *QueryLevelStruct) SetAddresses(_ map[string]bool) {}
-
 // SetLevel ...
 func (q *QueryLevelStruct) SetLevel(_ uint) {}
 
+// SetCodec ...
+func (q *QueryLevelStruct) SetCodec(_ string) {}
+
+// ==============================================================
+
+// GetBlocksStruct ...
+// Query blocks by hashes in Codec.
+type GetBlocksStruct struct {
+	Hashes []string
+	Codec  string
+}
+
+// ==============================================================
+
+// GetHeadersStruct ...
+type GetHeadersStruct struct {
+	Locator []string
+	Max     int
+}
+
 // ==============================================================
 
 // QueryResponse ...
@@ -50,20 +66,19 @@ func (r QueryResponse) Decode(data *[]HippoBlock) {
 	json.Unmarshal(r.Data, data)
 }
 
-// GetAddresses ...
-func (r QueryResponse) GetAddresses() map[string]bool { return nil }
-
 // GetLevel ...
 func (r QueryResponse) GetLevel() uint { return 0 }
 
 // ==============================================================
 
 // BroadcastBlock ...
+// Sender is the address of the last node that relayed the block.
 type BroadcastBlock struct {
-	Data      []byte
-	block     Block
-	Level     uint
-	Addresses map[string]bool
+	Data   []byte
+	block  Block
+	Level  uint
+	Sender string
+	codec  string
 }
 
 // Encode ...
@@ -72,26 +87,46 @@ func (b *BroadcastBlock) Encode() []byte {
 		bytes []byte
 		err   error
 	)
+	if b.codec == CodecBinary {
+		w := newBinaryWriter(kindBroadcastBlock)
+		w.uvarint(uint64(b.Level))
+		w.string(b.Sender)
+		w.bytes(EncodeBlockBinary(b.block))
+		return w.Bytes()
+	}
 	b.Data = b.block.Encode()
 	bytes, err = json.Marshal(b)
 	debugLogger.Debug("encode:", err)
 	return bytes
 }
 
-// SetAddresses ...
-func (b *BroadcastBlock) SetAddresses(a map[string]bool) { b.Addresses = a }
+// DecodeBroadcastBlock ...
+// Decode the envelope in either codec. The block is left in Data.
+func DecodeBroadcastBlock(data []byte) (b BroadcastBlock, err error) {
+	if !IsBinary(data) {
+		err = json.Unmarshal(data, &b)
+		return
+	}
+	r := newBinaryReader(data, kindBroadcastBlock)
+	b.Level = uint(r.uvarint())
+	b.Sender = r.string()
+	b.Data = r.bytes()
+	return b, r.end()
+}
 
 // SetLevel ...
 func (b *BroadcastBlock) SetLevel(l uint) { b.Level = l }
 
+// SetCodec ...
+func (b *BroadcastBlock) SetCodec(codec string) { b.codec = codec }
+
 // ===================================================
 
 // ReceiveBlock ...
 type ReceiveBlock struct {
-	Data      []byte
-	block     *HippoBlock
-	addresses map[string]bool
-	level     uint
+	Data  []byte
+	block *HippoBlock
+	level uint
 }
 
 // Decode ...
@@ -122,26 +157,24 @@ func (r *ReceiveBlock) Decode(b *BroadcastBlock) {
 			r.block.transactions[i] = &tr
 		}
 
-		bytes, err = json.Marshal(dataMap["Addresses"])
-		json.Unmarshal(bytes, &r.addresses)
-
 		bytes, err = json.Marshal(dataMap["Level"])
 		json.Unmarshal(bytes, &r.level)
 	}
 	// debugLogger.Debug("decode:", r.block)
 	b.block = r.block
-	b.Addresses = r.addresses
 	b.Level = r.level
 }
 
 // =============================================
 
 // BroadcastTransaction ...
+// Sender is the address of the last node that relayed the transaction.
 type BroadcastTransaction struct {
 	Data        []byte
 	transaction Transaction
-	Addresses   map[string]bool
 	Level       uint
+	Sender      string
+	codec       string
 }
 
 // Encode ...
@@ -150,6 +183,13 @@ func (bt *BroadcastTransaction) Encode() []byte {
 		infoLogger.Error("broadcastTransaction: encode nil")
 		return []byte{}
 	}
+	if bt.codec == CodecBinary {
+		w := newBinaryWriter(kindBroadcastTransaction)
+		w.uvarint(uint64(bt.Level))
+		w.string(bt.Sender)
+		w.bytes(EncodeTransactionBinary(bt.transaction))
+		return w.Bytes()
+	}
 	var (
 		bytes            []byte
 		err              error
@@ -173,7 +213,8 @@ func (bt *BroadcastTransaction) Encode() []byte {
 }
 
 // Decode ...
-func (bt *BroadcastTransaction) Decode(transactionTemplate Transaction) {
+// Data is the envelope in either codec.
+func (bt *BroadcastTransaction) Decode(transactionTemplate Transaction) error {
 	var (
 		tr    HippoTransaction
 		newBt BroadcastTransaction
@@ -181,32 +222,52 @@ func (bt *BroadcastTransaction) Decode(transactionTemplate Transaction) {
 	)
 	bt.transaction = transactionTemplate.CloneConstants()
 
+	if IsBinary(bt.Data) {
+		return bt.decodeBinary()
+	}
 	err = json.Unmarshal(bt.Data, &newBt)
 	if err != nil {
 		infoLogger.Error("broadcast transaction decode:", err)
-		return
+		return err
 	}
 
 	bt.Data = newBt.Data
 	bt.Level = newBt.Level
-	bt.Addresses = newBt.Addresses
+	bt.Sender = newBt.Sender
 	err = json.Unmarshal(bt.Data, &tr)
+	if err != nil {
+		infoLogger.Error("broadcast transaction decode:", err)
+		return err
+	}
 	bt.transaction.CopyVariables(&tr)
-	return
+	return nil
 }
 
-// SetAddresses ...
-func (bt *BroadcastTransaction) SetAddresses(a map[string]bool) { bt.Addresses = a }
+func (bt *BroadcastTransaction) decodeBinary() error {
+	r := newBinaryReader(bt.Data, kindBroadcastTransaction)
+	bt.Level = uint(r.uvarint())
+	bt.Sender = r.string()
+	bt.Data = r.bytes()
+	if err := r.end(); err != nil {
+		infoLogger.Error("broadcast transaction decode:", err)
+		return err
+	}
+	tr, err := decodeTransactionBinary(bt.Data)
+	if err != nil {
+		infoLogger.Error("broadcast transaction decode:", err)
+		return err
+	}
+	bt.transaction.CopyVariables(tr)
+	return nil
+}
 
 // SetLevel ...
 func (bt *BroadcastTransaction) SetLevel(l uint) { bt.Level = l }
 
-// ==============================================
+// SetCodec ...
+func (bt *BroadcastTransaction) SetCodec(codec string) { bt.codec = codec }
 
-// GetAddresses ...
-func (r *ReceiveBlock) GetAddresses() map[string]bool {
-	return r.addresses
-}
+// ==============================================
 
 // GetLevel ...
 func (r *ReceiveBlock) GetLevel() uint {
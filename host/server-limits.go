@@ -0,0 +1,355 @@
+package host
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of the P2P server against a single peer:
+// - The size of one request, checked while it is read, before it is decoded.
+// - The level span of QueryLevel and the hashes of GetBlocks and QueryTransactions.
+// - A token bucket of requests per peer, by its PeerKey.
+// Violations are penalized in the PeerScores of the peer, which takes it out of
+// the neighbors once it is banned.
+
+// ServerLimits ...
+// Rate is the number of requests per second of one peer, with bursts up to Burst.
+type ServerLimits struct {
+	MaxMessageSize int
+	MaxLevelSpan   int
+	MaxHashes      int
+	Rate           float64
+	Burst          int
+}
+
+// DefaultServerLimits ...
+var DefaultServerLimits = ServerLimits{
+	MaxMessageSize: 16 << 20,
+	MaxLevelSpan:   MaxHeadersPerRequest,
+	MaxHashes:      MaxInventorySize,
+	Rate:           200,
+	Burst:          1000,
+}
+
+// PenaltyOversized ...
+const PenaltyOversized = 20
+
+var (
+	// ErrRateLimited ...
+	ErrRateLimited = errors.New("rate limited")
+	// ErrMessageTooLarge ...
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// limitError ...
+// A request over one of the ServerLimits.
+type limitError struct {
+	reason string
+}
+
+func (e *limitError) Error() string { return "limit exceeded: " + e.reason }
+
+func limitErrorf(format string, a ...interface{}) error {
+	return &limitError{reason: fmt.Sprintf(format, a...)}
+}
+
+func (limits ServerLimits) withDefaults() ServerLimits {
+	if limits.MaxMessageSize <= 0 {
+		limits.MaxMessageSize = DefaultServerLimits.MaxMessageSize
+	}
+	if limits.MaxLevelSpan <= 0 {
+		limits.MaxLevelSpan = DefaultServerLimits.MaxLevelSpan
+	}
+	if limits.MaxHashes <= 0 {
+		limits.MaxHashes = DefaultServerLimits.MaxHashes
+	}
+	if limits.Rate <= 0 {
+		limits.Rate = DefaultServerLimits.Rate
+	}
+	if limits.Burst <= 0 {
+		limits.Burst = DefaultServerLimits.Burst
+	}
+	return limits
+}
+
+// RateLimiter ...
+// Steps:
+// 1. New(rate, burst)
+// 2. Allow(peer) before serving each request of peer.
+type RateLimiter interface {
+	New(rate float64, burst int)
+	Allow(peer string) (allowed bool, report bool)
+	Len() int
+}
+
+type tokenBucket struct {
+	tokens     float64
+	last       time.Time
+	lastReport time.Time
+}
+
+// HippoRateLimiter ...
+// One token bucket per peer. HippoRateLimiter is thread-safe.
+type HippoRateLimiter struct {
+	lock    sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*tokenBucket
+}
+
+// New ...
+func (l *HippoRateLimiter) New(rate float64, burst int) {
+	l.rate, l.burst = rate, burst
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+// Allow ...
+// Take a token of peer. report is true for at most one refused request per second,
+// so that a peer is penalized in proportion to how long it floods.
+func (l *HippoRateLimiter) Allow(peer string) (allowed bool, report bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	b, has := l.buckets[peer]
+	if !has {
+		l.pruneUnsafe(now)
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[peer] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, false
+	}
+	if now.Sub(b.lastReport) < time.Second {
+		return false, false
+	}
+	b.lastReport = now
+	return false, true
+}
+
+// Len ...
+func (l *HippoRateLimiter) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.buckets)
+}
+
+// pruneUnsafe ...
+// Forget the peers whose buckets are full again.
+func (l *HippoRateLimiter) pruneUnsafe(now time.Time) {
+	if len(l.buckets) < 1024 {
+		return
+	}
+	for peer, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.buckets, peer)
+		}
+	}
+}
+
+// messageLimitReader ...
+// Read a gob stream, and fail with ErrMessageTooLarge on a message over max bytes.
+// Every gob message starts with its length, so the check is done before the
+// message is buffered.
+type messageLimitReader struct {
+	r          *bufio.Reader
+	max        int
+	remaining  uint64
+	onTooLarge func()
+}
+
+func newMessageLimitReader(r io.Reader, max int) *messageLimitReader {
+	return &messageLimitReader{r: bufio.NewReader(r), max: max}
+}
+
+// Read ...
+// Return at most the rest of the current message.
+func (m *messageLimitReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if m.remaining == 0 {
+		prefix, length, err := m.readLength()
+		if err != nil {
+			return 0, err
+		}
+		if length > uint64(m.max) {
+			if m.onTooLarge != nil {
+				m.onTooLarge()
+			}
+			return 0, ErrMessageTooLarge
+		}
+		m.remaining = length
+		if len(p) <= len(prefix) {
+			// Cannot happen with the buffered gob decoder.
+			return 0, io.ErrShortBuffer
+		}
+		n := copy(p, prefix)
+		k, err := m.read(p[n:])
+		return n + k, err
+	}
+	return m.read(p)
+}
+
+func (m *messageLimitReader) read(p []byte) (int, error) {
+	if uint64(len(p)) > m.remaining {
+		p = p[:m.remaining]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= uint64(n)
+	return n, err
+}
+
+// readLength ...
+// Read the length prefix in the gob encoding of unsigned integers:
+// one byte below 128, or the negated byte count followed by big-endian bytes.
+func (m *messageLimitReader) readLength() (prefix []byte, length uint64, err error) {
+	first, err := m.r.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	prefix = []byte{first}
+	if first < 0x80 {
+		return prefix, uint64(first), nil
+	}
+	n := -int(int8(first))
+	if n > 8 {
+		return nil, 0, ErrMessageTooLarge
+	}
+	for i := 0; i < n; i++ {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		prefix = append(prefix, b)
+		length = length<<8 | uint64(b)
+	}
+	return prefix, length, nil
+}
+
+// p2pConn ...
+// The P2P service of one connection: it checks the limits of the peer before
+// calling P2PServer. peer is the PeerKey of the remote address, which is the
+// one rate limited and penalized, whether the peer has done a handshake or not.
+// Its reconnections share the same token bucket.
+type p2pConn struct {
+	s      *P2PServer
+	conn   io.Closer
+	remote string
+	peer   string
+	// nodeID: the node ID of the peer's certificate, "" without TLS.
+	nodeID string
+	// refused: 1 once the handshake is refused for a full inbound list or
+	// a wrong node ID, then the connection is closed.
+	refused int32
+}
+
+// violation ...
+func (c *p2pConn) violation(penalty int, reason string) {
+	infoLogger.Warn("p2p server:", c.peer, reason)
+	c.s.penalize(c.peer, penalty, reason)
+}
+
+// call ...
+// Take a token of the peer, then call f. Requests over the limits are penalized.
+func (c *p2pConn) call(f func() error) error {
+	if atomic.LoadInt32(&c.refused) == 1 {
+		c.conn.Close()
+		return errRefused
+	}
+	if c.s.isBanned(c.peer) {
+		return errBanned
+	}
+	if allowed, report := c.s.limiter.Allow(c.peer); !allowed {
+		if report {
+			c.violation(PenaltySpam, "rate limited")
+		}
+		return ErrRateLimited
+	}
+	err := f()
+	if e, ok := err.(*limitError); ok {
+		c.violation(PenaltySpam, e.reason)
+	}
+	return err
+}
+
+// Ping ...
+func (c *p2pConn) Ping(request string, reply *string) error {
+	return c.call(func() error { return c.s.Ping(request, reply) })
+}
+
+// Handshake ...
+func (c *p2pConn) Handshake(info HandshakeInfo, infoBytes *[]byte) error {
+	return c.call(func() error {
+		err := c.s.handshakeFrom(c.remote, c.nodeID, c.conn, info, infoBytes)
+		if err == ErrInboundFull || err == ErrNodeIDMismatch {
+			atomic.StoreInt32(&c.refused, 1)
+		}
+		return err
+	})
+}
+
+// BroadcastBlock ...
+func (c *p2pConn) BroadcastBlock(sendBlockByte []byte, reply *string) error {
+	return c.call(func() error { return c.s.broadcastBlock(c.peer, sendBlockByte, reply) })
+}
+
+// BroadcastTransaction ...
+func (c *p2pConn) BroadcastTransaction(sendBlockByte []byte, reply *string) error {
+	return c.call(func() error { return c.s.broadcastTransaction(c.peer, sendBlockByte, reply) })
+}
+
+// Inv ...
+func (c *p2pConn) Inv(inv Inventory, reply *string) error {
+	return c.call(func() error { return c.s.inv(c.peer, inv, reply) })
+}
+
+// QueryTransactions ...
+func (c *p2pConn) QueryTransactions(hashes []string, transactionsBytes *[]byte) error {
+	return c.call(func() error { return c.s.QueryTransactions(hashes, transactionsBytes) })
+}
+
+// QueryLevel ...
+func (c *p2pConn) QueryLevel(q QueryLevelStruct, reply *[]string) error {
+	return c.call(func() error { return c.s.QueryLevel(q, reply) })
+}
+
+// QueryByHash ...
+func (c *p2pConn) QueryByHash(h string, blockBytes *[]byte) error {
+	return c.call(func() error { return c.s.QueryByHash(h, blockBytes) })
+}
+
+// GetBlocks ...
+func (c *p2pConn) GetBlocks(q GetBlocksStruct, blocksBytes *[]byte) error {
+	return c.call(func() error { return c.s.GetBlocks(q, blocksBytes) })
+}
+
+// QueryTransactionProof ...
+func (c *p2pConn) QueryTransactionProof(transactionHash string, proofBytes *[]byte) error {
+	return c.call(func() error { return c.s.QueryTransactionProof(transactionHash, proofBytes) })
+}
+
+// GetTip ...
+func (c *p2pConn) GetTip(request string, tipBytes *[]byte) error {
+	return c.call(func() error { return c.s.GetTip(request, tipBytes) })
+}
+
+// GetHeaders ...
+func (c *p2pConn) GetHeaders(q GetHeadersStruct, headersBytes *[]byte) error {
+	return c.call(func() error { return c.s.GetHeaders(q, headersBytes) })
+}
+
+// GetPeers ...
+func (c *p2pConn) GetPeers(max int, peersBytes *[]byte) error {
+	return c.call(func() error { return c.s.GetPeers(max, peersBytes) })
+}
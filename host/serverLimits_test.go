@@ -0,0 +1,108 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := new(HippoRateLimiter)
+	limiter.New(10, 2)
+	allowed, _ := limiter.Allow("a")
+	assertT(allowed, t)
+	allowed, _ = limiter.Allow("a")
+	assertT(allowed, t)
+	// Only the first refused request of a second is reported.
+	allowed, report := limiter.Allow("a")
+	assertT(!allowed && report, t)
+	allowed, report = limiter.Allow("a")
+	assertT(!allowed && !report, t)
+	allowed, _ = limiter.Allow("b")
+	assertT(allowed && limiter.Len() == 2, t)
+
+	time.Sleep(150 * time.Millisecond)
+	allowed, _ = limiter.Allow("a")
+	assertT(allowed, t)
+}
+
+func TestMessageLimitReader(t *testing.T) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	assertT(encoder.Encode([]byte("small")) == nil, t)
+	assertT(encoder.Encode(make([]byte, 4096)) == nil, t)
+
+	decoder := gob.NewDecoder(newMessageLimitReader(&buffer, 1024))
+	var data []byte
+	assertT(decoder.Decode(&data) == nil && string(data) == "small", t)
+	assertT(decoder.Decode(&data) == ErrMessageTooLarge, t)
+}
+
+func TestServerLimits(t *testing.T) {
+	initTest(0)
+	scores := new(HippoPeerScores)
+	scores.New(DefaultBanThreshold, 3600)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assertT(err == nil, t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := new(P2PServer)
+	s.new(ctx, listener)
+	defer s.close()
+	s.setHandshake(func() HandshakeInfo { return HandshakeInfo{Version: ProtocolVersion} })
+	s.setPeerScores(scores)
+	s.setLimits(ServerLimits{MaxMessageSize: 1024, MaxLevelSpan: 10, Rate: 0.01, Burst: 3})
+	s.serve()
+
+	client := new(P2PClient)
+	client.SetTransport(new(TCPTransport))
+	assertT(client.New(ctx, ProtocolTCP, listener.Addr().String()) == nil, t)
+	defer client.Close()
+	_, err = client.Handshake(HandshakeInfo{Version: ProtocolVersion, Address: "peer:1"})
+	assertT(err == nil, t)
+
+	// The connection is penalized, not the address claimed in the handshake.
+	var levels []string
+	err = client.QueryLevel(0, 100, &levels)
+	assertT(err != nil && strings.Contains(err.Error(), "limit exceeded"), t)
+	assertT(scores.Score("peer:1") == DefaultPeerScore, t)
+	penalized := scores.Scores()
+	assertT(len(penalized) == 1, t)
+	var peer string
+	for peer = range penalized {
+	}
+	assertT(scores.Score(peer) == DefaultPeerScore-PenaltySpam, t)
+
+	// The burst is used up, and the first refused request is penalized.
+	var reply string
+	assertT(client.Ping("", &reply) == nil, t)
+	assertT(client.Ping("", &reply).Error() == ErrRateLimited.Error(), t)
+	assertT(client.Ping("", &reply).Error() == ErrRateLimited.Error(), t)
+	assertT(scores.Score(peer) == DefaultPeerScore-2*PenaltySpam, t)
+
+	// Reconnecting from another port does not refill the bucket.
+	client.Close()
+	again := new(P2PClient)
+	again.SetTransport(new(TCPTransport))
+	assertT(again.New(ctx, ProtocolTCP, listener.Addr().String()) == nil, t)
+	defer again.Close()
+	assertT(again.Ping("", &reply).Error() == ErrRateLimited.Error(), t)
+	assertT(s.limiter.Len() == 1, t)
+
+	// An oversized message closes the connection, even without a handshake.
+	// Another connection from the same host is the same peer.
+	big := new(P2PClient)
+	big.SetTransport(new(TCPTransport))
+	assertT(big.New(ctx, ProtocolTCP, listener.Addr().String()) == nil, t)
+	defer big.Close()
+	assertT(big.call("BroadcastBlock", make([]byte, 4096), &reply) != nil, t)
+	penalized = scores.Scores()
+	assertT(len(penalized) == 1, t)
+	assertT(penalized[peer] == DefaultPeerScore-2*PenaltySpam-PenaltyOversized, t)
+	assertT(big.Ping("", &reply) != nil && big.Stats().Broken, t)
+}
@@ -3,13 +3,17 @@ package host
 import (
 	"context"
 	"encoding/json"
+	"math/big"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Storage ...
 type Storage interface {
 	New()
 	Add(block Block) bool
+	AddFrom(block Block, sender string) bool
 	AddBlocks(blocks []Block)
 	CheckVerified(hashkey string) bool
 	UpdateVerified(hashkey string)
@@ -20,20 +24,52 @@ type Storage interface {
 	MaxLevel() int
 	TryUpdateMaxLevel(level int) int
 	GetTopBlock() Block
+	GetWork(hashKey string) *big.Int
 	GetBlocksLevel(level0, level1 int) []Block
 	GetBlocksLevelHash(level0, level1 int) []string
 	FilterNewHashes(hash []string) (result []string)
 
 	GetLastInterval() int64
+	GetMainChain() []Block
+	GetLocator() []string
+	GetHeaders(locator []string, max int) []BlockHeader
+	IsMainChain(hashKey string) bool
+	GetTransactionProof(transactionHash string) (TransactionProof, bool)
 
 	Count() int
 	AllHashes() []string
 	AllHashesInLevel() map[int][]string
 	AllBlocks() map[string]Block
 
-	SetMiningCancel(cancelFunc context.CancelFunc)
-	CheckMiningCancel(level int) bool
+	SetMiningCancel(cancelFunc context.CancelFunc, parentHash string)
+	CheckMiningCancel(parentHash string) bool
 	SetBalance(Balance)
+	SetOrphanHandler(handler OrphanHandler)
+	SetGenesis(hashKey string)
+	SetDifficulty(difficultyFunction DifficultyFunc, interval int64)
+	SetHeaderDifficulty(headerDifficulty HeaderDifficultyFunc)
+	HeaderRules() HeaderChainRules
+	SetTimestampRules(medianWindow int, maxFutureDrift int64)
+	MedianTimePast(block Block) int64
+
+	Load(templateBlock Block) int
+	Close()
+}
+
+// NewStorage ...
+// Create a storage by its backend name.
+// "memory" (default) keeps blocks in maps only, "file" also persists them to path.
+func NewStorage(backend, path string) Storage {
+	switch backend {
+	case "file":
+		storage := new(HippoFileStorage)
+		storage.SetPath(path)
+		return storage
+	case "", "memory":
+	default:
+		infoLogger.Warn("storage: unknown backend, use memory:", backend)
+	}
+	return new(HippoStorage)
 }
 
 // HippoStorage ...
@@ -55,11 +91,51 @@ type HippoStorage struct {
 	// verified
 	verified sync.Map
 
+	// transactions: transaction hash -> []string of block hashes
+	transactions sync.Map
+
+	// genesis: the only genesis block accepted if it is not empty.
+	genesis string
+
+	// orphans: blocks whose parents have not arrived.
+	orphans       OrphanPool
+	orphanHandler OrphanHandler
+
+	// works: hash -> *big.Int, cumulative work from the genesis block.
+	works sync.Map
+
+	// invalid: hash -> true, blocks whose branch state is invalid, and their descendants.
+	invalid sync.Map
+
+	// best is the verified block with the most cumulative work.
+	bestLock sync.Mutex
+	best     Block
+
 	// mining
+	miningLock   sync.Mutex
 	miningCancel context.CancelFunc
+	miningParent string
 
 	// balance
 	balance Balance
+
+	// difficulty rule for new blocks; nil accepts any difficulty.
+	difficultyFunction DifficultyFunc
+	headerDifficulty   HeaderDifficultyFunc
+	difficultyInterval int64
+
+	// timestamp rules; zero disables a rule.
+	medianWindow   int
+	maxFutureDrift int64
+
+	// ledger
+	// tip is the block whose chain has been applied to balance.
+	tipLock sync.Mutex
+	tip     Block
+
+	// onAdd is called after a new block is stored.
+	// It is used by other storages that build on HippoStorage.
+	onAdd func(block Block)
 }
 
 // New ...
@@ -67,6 +143,8 @@ func (storage *HippoStorage) New() {
 	storage.blocks = make(map[string]Block)
 	storage.levels = make(map[int]map[Block]bool)
 	storage.maxLevel = -1
+	storage.orphans = new(HippoOrphanPool)
+	storage.orphans.New(DefaultOrphanCapacity, DefaultOrphanTTL)
 }
 
 // Locks ========================================
@@ -96,8 +174,95 @@ func (storage *HippoStorage) UnlockLevel() {
 // SetBalance ...
 func (storage *HippoStorage) SetBalance(balance Balance) { storage.balance = balance }
 
+// SetGenesis ...
+// Accept no other genesis block.
+func (storage *HippoStorage) SetGenesis(hashKey string) { storage.genesis = hashKey }
+
+// SetOrphanHandler ...
+// The handler usually fetches the missing parent from the sender.
+func (storage *HippoStorage) SetOrphanHandler(handler OrphanHandler) {
+	storage.orphanHandler = handler
+}
+
+// SetDifficulty ...
+// Blocks must follow the difficulty rule, the same one used for mining.
+func (storage *HippoStorage) SetDifficulty(difficultyFunction DifficultyFunc, interval int64) {
+	storage.difficultyFunction = difficultyFunction
+	storage.difficultyInterval = interval
+}
+
+// SetHeaderDifficulty ...
+// The difficulty rule of SetDifficulty on headers, StaticHeaderDifficulty by default.
+func (storage *HippoStorage) SetHeaderDifficulty(headerDifficulty HeaderDifficultyFunc) {
+	storage.headerDifficulty = headerDifficulty
+}
+
+// HeaderRules ...
+// The rules of header chains that follow the stored blocks.
+// The anchor is the configured genesis, if any.
+func (storage *HippoStorage) HeaderRules() HeaderChainRules {
+	rules := HeaderChainRules{
+		Anchor:       storage.genesis,
+		Difficulty:   storage.headerDifficulty,
+		BaseInterval: storage.difficultyInterval,
+		Ancestor:     storageAncestor(storage),
+	}
+	if rules.Difficulty == nil {
+		rules.Difficulty = StaticHeaderDifficulty
+	}
+	return rules
+}
+
+// SetTimestampRules ...
+// A block should be later than the median timestamp of its last medianWindow ancestors,
+// and at most maxFutureDrift seconds ahead of the local clock.
+func (storage *HippoStorage) SetTimestampRules(medianWindow int, maxFutureDrift int64) {
+	storage.medianWindow = medianWindow
+	storage.maxFutureDrift = maxFutureDrift
+}
+
+// MedianTimePast ...
+// The median timestamp of block and its ancestors, medianWindow blocks at most.
+// A child of block should have a larger timestamp.
+func (storage *HippoStorage) MedianTimePast(block Block) int64 {
+	if storage.medianWindow <= 0 {
+		return 0
+	}
+	timestamps := make([]int64, 0, storage.medianWindow)
+	for ; block != nil && len(timestamps) < storage.medianWindow; block = storage.parentBlock(block) {
+		timestamps = append(timestamps, block.GetTimestamp())
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// checkConsensus ...
+// Check the rules of a block that depend on its parent.
+func (storage *HippoStorage) checkConsensus(block, parent Block) bool {
+	if mtp := storage.MedianTimePast(parent); block.GetTimestamp() <= mtp {
+		infoLogger.Errorf("storage: block %s timestamp %d, not after median time past %d",
+			block.Hash(), block.GetTimestamp(), mtp)
+		return false
+	}
+	if storage.difficultyFunction != nil {
+		expected := storage.difficultyFunction(parent, storage, storage.difficultyInterval)
+		if block.GetNumBytes() != expected {
+			infoLogger.Errorf("storage: block %s difficulty %d, expected %d",
+				block.Hash(), block.GetNumBytes(), expected)
+			return false
+		}
+	}
+	return true
+}
+
 // Add ...
-func (storage *HippoStorage) Add(block Block) bool {
+func (storage *HippoStorage) Add(block Block) bool { return storage.AddFrom(block, "") }
+
+// AddFrom ...
+// Add a block sent by sender.
+// A block whose parent is missing waits in the orphan pool, and it is added
+// once its parent is stored.
+func (storage *HippoStorage) AddFrom(block Block, sender string) bool {
 	block.SetBalance(storage.balance)
 	if !block.Check() {
 		infoLogger.Error("block check failed:", block.Hash())
@@ -107,10 +272,31 @@ func (storage *HippoStorage) Add(block Block) bool {
 	debugLogger.Debug("storage add:", block.Hash(), "level:", block.GetLevel())
 	debugLogger.Debug("storage add:", block)
 
+	if storage.maxFutureDrift > 0 &&
+		block.GetTimestamp() > time.Now().Unix()+storage.maxFutureDrift {
+		infoLogger.Error("storage: block from the future:", block.Hash(), block.GetTimestamp())
+		return false
+	}
+
+	if block.GetLevel() == 0 && storage.genesis != "" && block.Hash() != storage.genesis {
+		infoLogger.Error("storage: unknown genesis block:", block.Hash())
+		return false
+	}
+
+	parentHash := block.ParentHash()
+	if block.GetLevel() > 0 {
+		parent, has := storage.Get(parentHash)
+		if !has {
+			return storage.addOrphan(block, sender)
+		}
+		if !storage.checkConsensus(block, parent) {
+			return false
+		}
+	}
+
 	storage.LockBlock()
 	h := block.Hash()
 
-	parentHash := block.ParentHash()
 	if _, has := storage.blocks[h]; has {
 		// We have stored this block
 		storage.UnlockBlock()
@@ -121,6 +307,10 @@ func (storage *HippoStorage) Add(block Block) bool {
 	storage.blocks[h] = block
 	storage.UnlockBlock()
 
+	if storage.onAdd != nil {
+		storage.onAdd(block)
+	}
+
 	storage.LockLevel()
 	l, has := storage.levels[block.GetLevel()]
 	if !has {
@@ -137,8 +327,15 @@ func (storage *HippoStorage) Add(block Block) bool {
 	child, loaded := storage.child.LoadOrStore(parentHash, newChild)
 	childSlice := child.([]string)
 	if loaded {
-		child = append(childSlice, h)
-		storage.child.Store(parentHash, childSlice)
+		storage.child.Store(parentHash, append(childSlice, h))
+	}
+
+	// Update transaction index
+	for _, tr := range block.GetTransactions() {
+		blockHashes, loaded := storage.transactions.LoadOrStore(tr.Hash(), []string{h})
+		if loaded {
+			storage.transactions.Store(tr.Hash(), append(blockHashes.([]string), h))
+		}
 	}
 
 	// Update child's verification
@@ -151,40 +348,53 @@ func (storage *HippoStorage) Add(block Block) bool {
 		infoLogger.Error("cannot verify block", h)
 	}
 
-	// Update balance from genesis
-	balance := storage.balance
-	if balance != nil {
-		balance := storage.balance
-		balance.Lock()
-		balance.New()
-		mainChain := storage.GetMainChain()
-		debugLogger.Debug("main chain:", mainChain)
-		if mainChain != nil {
-			for _, b := range mainChain {
-				balanceChange := b.GetBalanceChange()
-				for address, value := range balanceChange {
-					balance.UpdateUnsafe(address, value)
-				}
-			}
-		} else {
-			infoLogger.Error("storage: cannot update balance")
-		}
-		balance.Unlock()
-		infoLogger.Info("storage: update balance success")
-	} else {
-		infoLogger.Error("storage: no balance")
-	}
-	debugLogger.Debug("update balance end.")
-	debugLogger.Debug("balance:", storage.balance.AllBalance())
+	// Move the balance to the new top block.
+	storage.updateLedger()
 
-	if storage.miningCancel != nil && storage.CheckMiningCancel(block.GetLevel()+1) {
+	storage.miningLock.Lock()
+	if storage.miningCancel != nil && storage.CheckMiningCancel(storage.miningParent) {
 		infoLogger.Info("storage.add: cancel mining and mine the new")
 		storage.miningCancel()
 		storage.miningCancel = nil
 	}
+	storage.miningLock.Unlock()
+
+	// Connect the orphans waiting for this block.
+	for _, child := range storage.orphans.TakeChildren(h) {
+		infoLogger.Info("storage: connect orphan:", child.Hash())
+		storage.AddFrom(child, sender)
+	}
 	return true
 }
 
+// addOrphan ...
+// Keep the block in the orphan pool and ask for its parent.
+// Its difficulty cannot be checked without the parent, so an orphan much easier
+// than the top block is refused: it must be nearly as costly as a block of the chain.
+func (storage *HippoStorage) addOrphan(block Block, sender string) bool {
+	if top := storage.GetTopBlock(); top != nil &&
+		block.GetNumBytes() > top.GetNumBytes()+OrphanDifficultySlack {
+		infoLogger.Errorf("storage: orphan %s difficulty %d, top block %d",
+			block.Hash(), block.GetNumBytes(), top.GetNumBytes())
+		return false
+	}
+	added, fetchParent := storage.orphans.Add(block, sender)
+	if added {
+		infoLogger.Warn("storage: orphan block:", block.Hash(), "from", sender)
+	}
+	if fetchParent && storage.orphanHandler != nil {
+		go storage.orphanHandler(block.ParentHash(), sender)
+	}
+	return true
+}
+
+// Load ...
+// HippoStorage keeps nothing on disk, so there is nothing to load.
+func (storage *HippoStorage) Load(templateBlock Block) int { return 0 }
+
+// Close ...
+func (storage *HippoStorage) Close() {}
+
 // AddBlocks ...
 func (storage *HippoStorage) AddBlocks(blocks []Block) {
 	for _, b := range blocks {
@@ -193,14 +403,19 @@ func (storage *HippoStorage) AddBlocks(blocks []Block) {
 }
 
 // SetMiningCancel ...
-func (storage *HippoStorage) SetMiningCancel(cancelFunc context.CancelFunc) {
+// parentHash is the parent of the block being mined.
+func (storage *HippoStorage) SetMiningCancel(cancelFunc context.CancelFunc, parentHash string) {
+	storage.miningLock.Lock()
+	defer storage.miningLock.Unlock()
 	storage.miningCancel = cancelFunc
+	storage.miningParent = parentHash
 }
 
 // CheckMiningCancel ...
-func (storage *HippoStorage) CheckMiningCancel(level int) bool {
-	currentTopLevel := storage.MaxLevel()
-	return level > currentTopLevel
+// Mining should be canceled once parentHash is no longer the top block.
+func (storage *HippoStorage) CheckMiningCancel(parentHash string) bool {
+	top := storage.GetTopBlock()
+	return top != nil && top.Hash() != parentHash
 }
 
 // CheckVerified ...
@@ -271,14 +486,27 @@ func (storage *HippoStorage) UpdateChild(hashKey string) {
 	if !has {
 		return
 	}
+	block, has := storage.Get(hashKey)
+	if !has {
+		return
+	}
+	storage.updateWork(block)
+	storage.TryUpdateMaxLevel(block.GetLevel())
+
 	child, has := storage.child.Load(hashKey)
 	if !has {
-		block, _ := storage.Get(hashKey)
-		storage.TryUpdateMaxLevel(block.GetLevel())
 		return
 	}
 	childList := child.([]string)
 	for _, childHash := range childList {
+		if _, invalid := storage.invalid.Load(childHash); invalid {
+			continue
+		}
+		// The child may arrive before its parent, so check it here.
+		if childBlock, has := storage.Get(childHash); !has ||
+			!storage.checkConsensus(childBlock, block) {
+			continue
+		}
 		storage.UpdateVerified(childHash)
 		storage.UpdateChild(childHash)
 	}
@@ -304,26 +532,216 @@ func (storage *HippoStorage) TryUpdateMaxLevel(level int) int {
 }
 
 // GetTopBlock ...
+// The verified block with the most cumulative work.
 func (storage *HippoStorage) GetTopBlock() Block {
-	maxLevel := storage.MaxLevel()
-	for maxLevel >= 0 {
-		debugLogger.Debug("top block:", maxLevel)
-
-		for _, block := range storage.GetAllFromLevel(maxLevel) {
-			debugLogger.Debug("get block:", block.Hash(), block)
-			if block != nil && storage.CheckVerified(block.Hash()) {
-				return block
+	storage.bestLock.Lock()
+	defer storage.bestLock.Unlock()
+	return storage.best
+}
+
+// GetWork ...
+// The cumulative work of a verified block, or nil if unknown.
+func (storage *HippoStorage) GetWork(hashKey string) *big.Int {
+	work, has := storage.works.Load(hashKey)
+	if !has {
+		return nil
+	}
+	return new(big.Int).Set(work.(*big.Int))
+}
+
+// updateWork ...
+// Compute the cumulative work of a verified block and update the best block.
+// Between two tips with the same work, the smaller hash wins,
+// so that every node picks the same one whatever the arrival order is.
+func (storage *HippoStorage) updateWork(block Block) {
+	h := block.Hash()
+	work := BlockWork(block.GetNumBytes())
+	if block.GetLevel() > 0 {
+		parentWork := storage.GetWork(block.ParentHash())
+		if parentWork == nil {
+			infoLogger.Error("storage: no work of parent:", h)
+			return
+		}
+		work.Add(work, parentWork)
+	}
+	storage.works.Store(h, work)
+
+	storage.bestLock.Lock()
+	defer storage.bestLock.Unlock()
+	if storage.best != nil {
+		cmp := work.Cmp(storage.GetWork(storage.best.Hash()))
+		if cmp < 0 || (cmp == 0 && h >= storage.best.Hash()) {
+			return
+		}
+	}
+	storage.best = block
+	debugLogger.Debug("storage: new best block:", h, "work:", work)
+}
+
+// updateLedger ...
+// Move the balance from the current tip to the top block.
+// If the top block extends the tip, only the new blocks are applied.
+// If a side branch gains more work than the main chain, roll back to the common ancestor
+// and apply the new branch.
+// If a block of the new branch is invalid on it, the balance goes back to the old tip,
+// the block and its descendants are dropped, and the next best block is tried.
+func (storage *HippoStorage) updateLedger() {
+	balance := storage.balance
+	if balance == nil {
+		infoLogger.Error("storage: no balance")
+		return
+	}
+	storage.tipLock.Lock()
+	defer storage.tipLock.Unlock()
+
+	for {
+		oldTip, newTip := storage.tip, storage.GetTopBlock()
+		if newTip == nil {
+			return
+		}
+		if oldTip != nil && oldTip.Hash() == newTip.Hash() {
+			return
+		}
+
+		// Walk back from both tips to the common ancestor.
+		var (
+			detach = make([]Block, 0)
+			attach = make([]Block, 0)
+			a, b   = oldTip, newTip
+		)
+		for a != nil || b != nil {
+			if a != nil && b != nil && a.Hash() == b.Hash() {
+				break
+			}
+			if b != nil && (a == nil || b.GetLevel() >= a.GetLevel()) {
+				attach = append(attach, b)
+				b = storage.parentBlock(b)
+			} else {
+				detach = append(detach, a)
+				a = storage.parentBlock(a)
 			}
 		}
-		infoLogger.Error("no top block!", maxLevel)
-		storage.maxLevelLock.Lock()
-		storage.maxLevel--
-		storage.maxLevelLock.Unlock()
-		maxLevel = storage.MaxLevel()
+
+		balance.Lock()
+		failed := storage.moveTipUnsafe(detach, attach)
+		balance.Unlock()
+		if failed != nil {
+			infoLogger.Error("storage: invalid block, drop its branch:", failed.Hash())
+			storage.invalidate(failed)
+			continue
+		}
+		storage.tip = newTip
+
+		if len(detach) > 0 {
+			ancestor := "none"
+			if a != nil {
+				ancestor = a.Hash()
+			}
+			infoLogger.Warnf("storage: reorg depth %d from %s to %s, common ancestor %s",
+				len(detach), oldTip.Hash(), newTip.Hash(), ancestor)
+		}
+		infoLogger.Info("storage: update balance success:", newTip.Hash())
+		return
+	}
+}
+
+// moveTipUnsafe ...
+// Roll back the detach blocks, from the tip, and apply the attach blocks, from the
+// common ancestor. Each attach block is checked against the state of its parent.
+// If one is invalid, undo everything and return it.
+// Make sure you manually lock the balance first.
+func (storage *HippoStorage) moveTipUnsafe(detach, attach []Block) Block {
+	for _, block := range detach {
+		if !storage.applyBalanceChangeUnsafe(block, -1) {
+			infoLogger.Error("storage: cannot roll back:", block.Hash())
+		}
+	}
+	for i := len(attach) - 1; i >= 0; i-- {
+		if attach[i].CheckStateUnsafe(storage.balance) &&
+			storage.applyBalanceChangeUnsafe(attach[i], 1) {
+			continue
+		}
+		for j := i + 1; j < len(attach); j++ {
+			storage.applyBalanceChangeUnsafe(attach[j], -1)
+		}
+		for j := len(detach) - 1; j >= 0; j-- {
+			storage.applyBalanceChangeUnsafe(detach[j], 1)
+		}
+		return attach[i]
 	}
 	return nil
 }
 
+// applyBalanceChangeUnsafe ...
+// Apply (sign = 1) or roll back (sign = -1) the balance and nonce change of a block.
+// Nothing changes and it returns false if a balance would be negative.
+// Make sure you manually lock the balance first.
+func (storage *HippoStorage) applyBalanceChangeUnsafe(block Block, sign int64) bool {
+	change := block.GetBalanceChange()
+	for address, value := range change {
+		if int64(storage.balance.GetUnsafe(address))+sign*value < 0 {
+			infoLogger.Error("storage: balance underflow:", address, block.Hash())
+			return false
+		}
+	}
+	for address, value := range change {
+		storage.balance.UpdateUnsafe(address, sign*value)
+	}
+	for address, value := range block.GetNonceChange() {
+		storage.balance.UpdateNonceUnsafe(address, sign*value)
+	}
+	return true
+}
+
+// invalidate ...
+// Forget the verification and the work of block and its descendants,
+// then choose the best block again.
+func (storage *HippoStorage) invalidate(block Block) {
+	hashes := []string{block.Hash()}
+	for len(hashes) > 0 {
+		h := hashes[len(hashes)-1]
+		hashes = hashes[:len(hashes)-1]
+		storage.invalid.Store(h, true)
+		storage.verified.Delete(h)
+		storage.works.Delete(h)
+		if child, has := storage.child.Load(h); has {
+			hashes = append(hashes, child.([]string)...)
+		}
+	}
+
+	storage.bestLock.Lock()
+	defer storage.bestLock.Unlock()
+	storage.best = nil
+	var bestWork *big.Int
+	storage.works.Range(func(key, value interface{}) bool {
+		h, work := key.(string), value.(*big.Int)
+		if storage.best != nil {
+			cmp := work.Cmp(bestWork)
+			if cmp < 0 || (cmp == 0 && h >= storage.best.Hash()) {
+				return true
+			}
+		}
+		if b, has := storage.Get(h); has {
+			storage.best, bestWork = b, work
+		}
+		return true
+	})
+}
+
+// parentBlock ...
+// Return nil for a genesis block or a missing parent.
+func (storage *HippoStorage) parentBlock(block Block) Block {
+	if block.GetLevel() == 0 {
+		return nil
+	}
+	parent, has := storage.Get(block.ParentHash())
+	if !has {
+		infoLogger.Error("storage: missing parent:", block.Hash())
+		return nil
+	}
+	return parent
+}
+
 // GetBlocksLevel ...
 func (storage *HippoStorage) GetBlocksLevel(level0, level1 int) (blocks []Block) {
 	blocks = make([]Block, 0)
@@ -387,6 +805,94 @@ func (storage *HippoStorage) GetMainChain() []Block {
 	return blocks
 }
 
+// GetLocator ...
+// Hashes of the main chain from the top block back to the genesis block,
+// dense near the top and exponentially sparse further back.
+func (storage *HippoStorage) GetLocator() []string {
+	chain := storage.GetMainChain()
+	locator := make([]string, 0)
+	step := 1
+	for i := len(chain) - 1; i > 0; i -= step {
+		locator = append(locator, chain[i].Hash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	if len(chain) > 0 {
+		locator = append(locator, chain[0].Hash())
+	}
+	return locator
+}
+
+// GetHeaders ...
+// Headers of the main chain after the first locator hash in the main chain,
+// or from the genesis block if none is. Return max headers at most.
+func (storage *HippoStorage) GetHeaders(locator []string, max int) []BlockHeader {
+	chain := storage.GetMainChain()
+	start := 0
+	for _, h := range locator {
+		if storage.IsMainChain(h) {
+			block, _ := storage.Get(h)
+			start = block.GetLevel() + 1
+			break
+		}
+	}
+	headers := make([]BlockHeader, 0)
+	for i := start; i < len(chain) && len(headers) < max; i++ {
+		headers = append(headers, chain[i].GetHeader())
+	}
+	return headers
+}
+
+// IsMainChain ...
+func (storage *HippoStorage) IsMainChain(hashKey string) bool {
+	block, has := storage.Get(hashKey)
+	if !has {
+		return false
+	}
+	top := storage.GetTopBlock()
+	for top != nil && top.GetLevel() > block.GetLevel() {
+		top = storage.parentBlock(top)
+	}
+	return top != nil && top.Hash() == hashKey
+}
+
+// GetTransactionProof ...
+// Build the inclusion proof of a transaction in the main chain.
+func (storage *HippoStorage) GetTransactionProof(transactionHash string) (TransactionProof, bool) {
+	blockHashes, has := storage.transactions.Load(transactionHash)
+	if !has {
+		return TransactionProof{}, false
+	}
+	for _, blockHash := range blockHashes.([]string) {
+		if !storage.IsMainChain(blockHash) {
+			continue
+		}
+		block, _ := storage.Get(blockHash)
+		transactions := block.GetTransactions()
+		leaves := make([][]byte, len(transactions))
+		index := -1
+		for i, tr := range transactions {
+			leaves[i] = tr.HashSignaturesBytes()
+			if tr.Hash() == transactionHash {
+				index = i
+			}
+		}
+		proof, ok := BuildMerkleProof(leaves, index, block.GetHashFunction())
+		if !ok {
+			return TransactionProof{}, false
+		}
+		return TransactionProof{
+			TransactionHash: transactionHash,
+			Leaf:            ByteToHexString(leaves[index]),
+			BlockHash:       blockHash,
+			Level:           block.GetLevel(),
+			Proof:           proof,
+		}, true
+	}
+	return TransactionProof{}, false
+}
+
 // GetLastInterval ...
 // Return -1 if not available.
 func (storage *HippoStorage) GetLastInterval() int64 {
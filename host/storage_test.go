@@ -0,0 +1,383 @@
+package host
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// replayBalance ...
+// Compute the balance by replaying the whole main chain.
+func replayBalance(storage *HippoStorage) map[string]uint64 {
+	balance := new(HippoBalance)
+	balance.New()
+	for _, b := range storage.GetMainChain() {
+		for address, value := range b.GetBalanceChange() {
+			balance.Update(address, value)
+		}
+	}
+	return balance.AllBalance()
+}
+
+func checkLedger(storage *HippoStorage, t *testing.T) {
+	expected := replayBalance(storage)
+	for address, value := range testBalance.AllBalance() {
+		if expected[address] != value {
+			t.Errorf("balance of %s: %d, expected %d", address, value, expected[address])
+		}
+	}
+}
+
+func TestStorageIncrementalBalance(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test storage incremental balance ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	var block Block
+	for i := 0; i < 4; i++ {
+		block = mineTestBlock(block, 250, testKeys[i%2], nil)
+		assertT(storage.Add(block), t)
+		assertT(storage.GetTopBlock().Hash() == block.Hash(), t)
+		checkLedger(storage, t)
+	}
+}
+
+func TestStorageReorg(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage reorg ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	a2 := mineTestBlock(a1, 250, testKeys[1], nil)
+	for _, b := range []Block{genesis, a1, a2} {
+		assertT(storage.Add(b), t)
+	}
+	assertT(storage.GetTopBlock().Hash() == a2.Hash(), t)
+	checkLedger(storage, t)
+	minerA := testBalance.Get(testKeys[1].ToAddress())
+	assertT(minerA > 0, t)
+
+	// A side branch that overtakes the main chain.
+	b1 := mineTestBlock(genesis, 250, testKeys[2], nil)
+	b2 := mineTestBlock(b1, 250, testKeys[2], nil)
+	b3 := mineTestBlock(b2, 250, testKeys[2], nil)
+	assertT(storage.Add(b1), t)
+	assertT(storage.Add(b2), t)
+	checkLedger(storage, t)
+
+	assertT(storage.Add(b3), t)
+	assertT(storage.GetTopBlock().Hash() == b3.Hash(), t)
+	checkLedger(storage, t)
+	assertT(testBalance.Get(testKeys[1].ToAddress()) == 0, t)
+	assertT(testBalance.Get(testKeys[2].ToAddress()) > 0, t)
+
+	// And back again.
+	a3 := mineTestBlock(a2, 250, testKeys[1], nil)
+	a4 := mineTestBlock(a3, 250, testKeys[1], nil)
+	assertT(storage.Add(a3), t)
+	assertT(storage.Add(a4), t)
+	assertT(storage.GetTopBlock().Hash() == a4.Hash(), t)
+	checkLedger(storage, t)
+	assertT(testBalance.Get(testKeys[2].ToAddress()) == 0, t)
+	assertT(testBalance.Get(testKeys[1].ToAddress()) > minerA, t)
+}
+
+// newSpendTestTransaction ...
+// from pays amount plus a fee of 1 to to.
+func newSpendTestTransaction(from Key, to Key, amount uint64, nonce uint64) Transaction {
+	tr := new(HippoTransaction)
+	tr.New(testHashfunction, testCurve)
+	tr.SetSender([]string{from.ToAddress()}, []uint64{amount + 1})
+	tr.SetNonces([]uint64{nonce})
+	tr.SetReceiver([]string{to.ToAddress()}, []uint64{amount})
+	tr.UpdateFee()
+	tr.Sign(from)
+	return tr
+}
+
+func TestStorageReorgSpending(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage reorg spending ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	// key 1 mines a1 and spends its reward in a2.
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	a2 := mineTestBlock(a1, 250, testKeys[0], []Transaction{
+		newSpendTestTransaction(testKeys[1], testKeys[0], 10, 0)})
+	for _, b := range []Block{genesis, a1, a2} {
+		assertT(storage.Add(b), t)
+	}
+	assertT(storage.GetTopBlock().Hash() == a2.Hash(), t)
+	checkLedger(storage, t)
+	assertT(testBalance.GetNonce(testKeys[1].ToAddress()) == 1, t)
+
+	// The spending is rolled back with its branch.
+	b1 := mineTestBlock(genesis, 250, testKeys[2], nil)
+	b2 := mineTestBlock(b1, 250, testKeys[2], nil)
+	b3 := mineTestBlock(b2, 250, testKeys[2], nil)
+	for _, b := range []Block{b1, b2, b3} {
+		assertT(storage.Add(b), t)
+	}
+	assertT(storage.GetTopBlock().Hash() == b3.Hash(), t)
+	checkLedger(storage, t)
+	assertT(testBalance.Get(testKeys[1].ToAddress()) == 0, t)
+	assertT(testBalance.GetNonce(testKeys[1].ToAddress()) == 0, t)
+
+	// And applied again.
+	a3 := mineTestBlock(a2, 250, testKeys[0], nil)
+	a4 := mineTestBlock(a3, 250, testKeys[0], nil)
+	assertT(storage.Add(a3) && storage.Add(a4), t)
+	assertT(storage.GetTopBlock().Hash() == a4.Hash(), t)
+	checkLedger(storage, t)
+	assertT(testBalance.GetNonce(testKeys[1].ToAddress()) == 1, t)
+}
+
+func TestStorageReorgInvalidBranch(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage reorg invalid branch ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	a2 := mineTestBlock(a1, 250, testKeys[0], nil)
+	for _, b := range []Block{genesis, a1, a2} {
+		assertT(storage.Add(b), t)
+	}
+
+	// b2 spends the reward of a1, which key 1 does not have on the b branch.
+	b1 := mineTestBlock(genesis, 250, testKeys[2], nil)
+	b2 := mineTestBlock(b1, 250, testKeys[2], []Transaction{
+		newSpendTestTransaction(testKeys[1], testKeys[2], 10, 0)})
+	b3 := mineTestBlock(b2, 250, testKeys[2], nil)
+	b4 := mineTestBlock(b3, 250, testKeys[2], nil)
+	for _, b := range []Block{b1, b2, b3, b4} {
+		storage.Add(b)
+	}
+
+	// The ledger stays on the valid chain, and the invalid branch is never verified again.
+	assertT(storage.GetTopBlock().Hash() == a2.Hash(), t)
+	checkLedger(storage, t)
+	assertT(storage.CheckVerified(b1.Hash()), t)
+	for _, b := range []Block{b2, b3, b4} {
+		assertT(!storage.CheckVerified(b.Hash()) && storage.GetWork(b.Hash()) == nil, t)
+	}
+	assertT(storage.Add(mineTestBlock(b4, 250, testKeys[2], nil)), t)
+	assertT(storage.GetTopBlock().Hash() == a2.Hash(), t)
+
+	// The valid side of the branch may still win.
+	c2 := mineTestBlock(b1, 250, testKeys[2], nil)
+	c3 := mineTestBlock(c2, 250, testKeys[2], nil)
+	assertT(storage.Add(c2) && storage.Add(c3), t)
+	assertT(storage.GetTopBlock().Hash() == c3.Hash(), t)
+	checkLedger(storage, t)
+}
+
+func TestStorageForkSpending(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage fork spending ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	// Both branches include the same transaction, spending the genesis reward.
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	tr := newSpendTestTransaction(testKeys[0], testKeys[1], 10, 0)
+	a1 := mineTestBlock(genesis, 250, testKeys[1], []Transaction{tr})
+	b1 := mineTestBlock(genesis, 250, testKeys[2], []Transaction{tr})
+	b2 := mineTestBlock(b1, 250, testKeys[2], []Transaction{
+		newSpendTestTransaction(testKeys[0], testKeys[2], 10, 1)})
+	b3 := mineTestBlock(b2, 250, testKeys[2], nil)
+	for _, b := range []Block{genesis, a1, b1, b2, b3} {
+		assertT(storage.Add(b), t)
+	}
+	assertT(storage.GetTopBlock().Hash() == b3.Hash(), t)
+	checkLedger(storage, t)
+	assertT(testBalance.GetNonce(testKeys[0].ToAddress()) == 2, t)
+	assertT(testBalance.Get(testKeys[1].ToAddress()) == 10, t)
+
+	// A nonce or a spending that does not fit its branch is invalid there only.
+	replayed := mineTestBlock(b3, 250, testKeys[2], []Transaction{tr})
+	overspent := mineTestBlock(b3, 250, testKeys[2], []Transaction{
+		newSpendTestTransaction(testKeys[1], testKeys[2], 10, 0),
+		newSpendTestTransaction(testKeys[1], testKeys[0], 10, 1)})
+	for _, b := range []Block{replayed, overspent} {
+		assertT(storage.Add(b), t)
+		assertT(storage.GetTopBlock().Hash() == b3.Hash() && !storage.CheckVerified(b.Hash()), t)
+	}
+	checkLedger(storage, t)
+	a2 := mineTestBlock(a1, 250, testKeys[1], []Transaction{
+		newSpendTestTransaction(testKeys[1], testKeys[0], 10, 0)})
+	assertT(storage.Add(a2) && storage.CheckVerified(a2.Hash()), t)
+}
+
+func TestStorageOverflowSpending(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage overflow spending ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	// key 2 has nothing, and amounts past MaxInt64 must not wrap into credits.
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	assertT(storage.Add(genesis), t)
+	for _, amount := range []uint64{1<<63 + 9, math.MaxUint64 - 1} {
+		tr := newSpendTestTransaction(testKeys[2], testKeys[1], amount, 0)
+		assertT(!tr.CheckFee(), t)
+		b := mineTestBlock(genesis, 250, testKeys[0], []Transaction{tr})
+		storage.Add(b)
+		assertT(storage.GetTopBlock().Hash() == genesis.Hash(), t)
+		checkLedger(storage, t)
+		assertT(testBalance.Get(testKeys[2].ToAddress()) == 0, t)
+		assertT(testBalance.Get(testKeys[1].ToAddress()) == 0, t)
+	}
+
+	// Nor can the receivers of a transaction add up past it.
+	tr := new(HippoTransaction)
+	tr.New(testHashfunction, testCurve)
+	tr.SetSender([]string{testKeys[0].ToAddress()}, []uint64{10})
+	tr.SetNonces([]uint64{0})
+	tr.SetReceiver([]string{testKeys[1].ToAddress(), testKeys[1].ToAddress()},
+		[]uint64{math.MaxInt64, math.MaxInt64 - 1})
+	assertT(!tr.UpdateFee() && !tr.CheckFee(), t)
+}
+
+func TestStorageForkChoiceByWork(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage fork choice by work ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	// A longer chain of easy blocks.
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	a2 := mineTestBlock(a1, 250, testKeys[1], nil)
+	a3 := mineTestBlock(a2, 250, testKeys[1], nil)
+	for _, b := range []Block{genesis, a1, a2, a3} {
+		assertT(storage.Add(b), t)
+	}
+	assertT(storage.GetTopBlock().Hash() == a3.Hash(), t)
+	assertT(!storage.CheckMiningCancel(a3.Hash()), t)
+
+	// A shorter chain with more work wins.
+	b1 := mineTestBlock(genesis, 240, testKeys[2], nil)
+	assertT(storage.Add(b1), t)
+	assertT(storage.GetTopBlock().Hash() == b1.Hash(), t)
+	assertT(storage.MaxLevel() == 3, t)
+	assertT(len(storage.GetMainChain()) == 2, t)
+	assertT(storage.IsMainChain(b1.Hash()) && !storage.IsMainChain(a3.Hash()), t)
+	assertT(storage.CheckMiningCancel(a3.Hash()), t)
+	checkLedger(storage, t)
+	assertT(testBalance.Get(testKeys[1].ToAddress()) == 0, t)
+
+	work := new(big.Int).Add(BlockWork(250), BlockWork(240))
+	assertT(storage.GetWork(b1.Hash()).Cmp(work) == 0, t)
+}
+
+func TestStorageForkChoiceTieBreak(t *testing.T) {
+	initTest(3)
+	infoLogger.Debug("test storage fork choice tie break ==============================")
+	initBalance()
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[1], nil)
+	b1 := mineTestBlock(genesis, 250, testKeys[2], nil)
+	expected := a1
+	if b1.Hash() < a1.Hash() {
+		expected = b1
+	}
+
+	// The same tip whatever the arrival order is.
+	for _, order := range [][]Block{{genesis, a1, b1}, {genesis, b1, a1}} {
+		initBalance()
+		initStorage()
+		storage := testStorage.(*HippoStorage)
+		for _, b := range order {
+			assertT(storage.Add(b), t)
+		}
+		assertT(storage.GetTopBlock().Hash() == expected.Hash(), t)
+		checkLedger(storage, t)
+	}
+}
+
+func TestStorageTimestampRules(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test storage timestamp rules ==============================")
+	initBalance()
+	initStorage()
+	testStorage.SetTimestampRules(3, 60)
+
+	genesis := mineTestBlockAt(nil, 250, testKeys[0], nil, 1000)
+	a1 := mineTestBlockAt(genesis, 250, testKeys[0], nil, 1010)
+	a2 := mineTestBlockAt(a1, 250, testKeys[0], nil, 1030)
+	for _, b := range []Block{genesis, a1, a2} {
+		assertT(testStorage.Add(b), t)
+	}
+	assertT(testStorage.MedianTimePast(a2) == 1010, t)
+
+	// Not after the median time past. A block may still be older than its parent.
+	assertT(!testStorage.Add(mineTestBlockAt(a2, 250, testKeys[0], nil, 1010)), t)
+	assertT(testStorage.Add(mineTestBlockAt(a2, 250, testKeys[0], nil, 1011)), t)
+
+	// Too far in the future.
+	now := time.Now().Unix()
+	assertT(!testStorage.Add(mineTestBlockAt(a2, 250, testKeys[1], nil, now+600)), t)
+	assertT(testStorage.Add(mineTestBlockAt(a2, 250, testKeys[1], nil, now+30)), t)
+}
+
+func TestStorageOrphan(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test storage orphan ==============================")
+	initBalance()
+	initStorage()
+	storage := testStorage.(*HippoStorage)
+
+	requests := make(chan [2]string, 10)
+	storage.SetOrphanHandler(func(parentHash, sender string) {
+		requests <- [2]string{parentHash, sender}
+	})
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	a1 := mineTestBlock(genesis, 250, testKeys[0], nil)
+	a2 := mineTestBlock(a1, 250, testKeys[1], nil)
+	a3 := mineTestBlock(a2, 250, testKeys[1], nil)
+	assertT(storage.Add(genesis), t)
+
+	// An orphan much easier than the top block is refused.
+	assertT(!storage.AddFrom(mineTestBlock(a2, 250+OrphanDifficultySlack+1, testKeys[0], nil), "peer"), t)
+	assertT(storage.orphans.Len() == 0, t)
+
+	// Orphans are kept aside and their parents are requested from the sender once.
+	assertT(storage.AddFrom(a3, "peer"), t)
+	assertT(storage.AddFrom(a2, "peer"), t)
+	_, has := storage.Get(a3.Hash())
+	assertT(!has && storage.orphans.Has(a3.Hash()), t)
+	requested := make(map[[2]string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case request := <-requests:
+			requested[request] = true
+		case <-time.After(time.Second):
+			t.Error("no parent request")
+		}
+	}
+	assertT(requested[[2]string{a2.Hash(), "peer"}] && requested[[2]string{a1.Hash(), "peer"}], t)
+
+	// The parent connects the orphans recursively.
+	assertT(storage.AddFrom(a1, "peer"), t)
+	assertT(storage.orphans.Len() == 0, t)
+	assertT(storage.GetTopBlock().Hash() == a3.Hash(), t)
+	assertT(storage.CheckVerified(a3.Hash()), t)
+	checkLedger(storage, t)
+}
@@ -0,0 +1,233 @@
+package host
+
+import (
+	"math/big"
+	"sync"
+)
+
+// Headers-first sync:
+// 1. Ask the peer for its tip and cumulative work.
+// 2. Send a block locator to find the fork point, and download the headers after it.
+// 3. Check the links, the proof of work and the difficulty of the headers,
+//    anchored at a stored block or the genesis. The last headers must reach
+//    the work the peer claimed.
+// 4. Fetch the bodies in batches, in parallel from several peers, and add them
+//    in order. An invalid body ends the sync.
+
+// MaxHeadersPerRequest ...
+const MaxHeadersPerRequest = 500
+
+// TipInfo ...
+// Work is the cumulative work in decimal.
+type TipInfo struct {
+	Hash  string `json:"hash"`
+	Level int    `json:"level"`
+	Work  string `json:"work"`
+}
+
+// GetWork ...
+func (tip TipInfo) GetWork() *big.Int {
+	work, ok := new(big.Int).SetString(tip.Work, 10)
+	if !ok {
+		return big.NewInt(0)
+	}
+	return work
+}
+
+// SyncProgress ...
+type SyncProgress struct {
+	Peer         string
+	Syncing      bool
+	TargetLevel  int
+	CurrentLevel int
+	Headers      int
+	Blocks       int
+}
+
+// syncSource ...
+// The queries used by the sync. NetworkClient implements it.
+type syncSource interface {
+	GetTip(address string) (TipInfo, bool)
+	GetHeaders(address string, locator []string, max int) ([]BlockHeader, bool)
+	QueryHashes(address string, hashes []string) []Block
+}
+
+// localTip ...
+func localTip(storage Storage) TipInfo {
+	top := storage.GetTopBlock()
+	if top == nil {
+		return TipInfo{Level: -1, Work: "0"}
+	}
+	work := storage.GetWork(top.Hash())
+	if work == nil {
+		work = big.NewInt(0)
+	}
+	return TipInfo{Hash: top.Hash(), Level: top.GetLevel(), Work: work.String()}
+}
+
+// headersFirstSync ...
+// Sync from address if it has more work. Bodies are also fetched from peers.
+// report is called whenever the progress changes.
+// Return false if the peer sends an invalid header chain, a chain with less work
+// than it claimed, or a header whose block is invalid.
+func headersFirstSync(source syncSource, address string, peers []string, storage Storage,
+	hashFunction HashFunction, report func(SyncProgress)) bool {
+	tip, ok := source.GetTip(address)
+	if !ok {
+		return true
+	}
+	local := localTip(storage)
+	if tip.GetWork().Cmp(local.GetWork()) <= 0 {
+		return true
+	}
+	progress := SyncProgress{
+		Peer:         address,
+		Syncing:      true,
+		TargetLevel:  tip.Level,
+		CurrentLevel: local.Level,
+	}
+	report(progress)
+	defer func() {
+		progress.Syncing = false
+		report(progress)
+	}()
+	infoLogger.Infof("sync: from %s, level %d -> %d", address, local.Level, tip.Level)
+
+	for {
+		headers, ok := source.GetHeaders(address, storage.GetLocator(), MaxHeadersPerRequest)
+		if !ok || len(headers) == 0 {
+			return true
+		}
+		rules := storage.HeaderRules()
+		if first := headers[0]; first.Level > 0 {
+			rules.Anchor = ByteToHexString(first.PreviousHash)
+		} else if rules.Anchor == "" {
+			// Any genesis is stored without a configured one.
+			rules.Anchor = first.Hash(hashFunction)
+		}
+		if !VerifyHeaderChain(headers, rules, hashFunction) {
+			infoLogger.Error("sync: invalid header chain from", address)
+			return false
+		}
+		// The work of the chain up to the last header.
+		work := big.NewInt(0)
+		if headers[0].Level > 0 {
+			if anchorWork := storage.GetWork(rules.Anchor); anchorWork != nil {
+				work.Set(anchorWork)
+			}
+		}
+		work.Add(work, HeadersWork(headers))
+		final := len(headers) < MaxHeadersPerRequest ||
+			headers[len(headers)-1].Hash(hashFunction) == tip.Hash
+		if final && work.Cmp(tip.GetWork()) < 0 {
+			infoLogger.Errorf("sync: %s claimed work %s, its headers have %s",
+				address, tip.Work, work)
+			return false
+		}
+		progress.Headers += len(headers)
+		report(progress)
+
+		hashes := make([]string, 0, len(headers))
+		for _, header := range headers {
+			h := header.Hash(hashFunction)
+			if _, has := storage.Get(h); !has {
+				hashes = append(hashes, h)
+			}
+		}
+		blocks := fetchBodies(source, hashes, address, peers)
+		for i, block := range blocks {
+			if block == nil {
+				infoLogger.Error("sync: cannot fetch block", hashes[i])
+				return true
+			}
+			if !storage.AddFrom(block, address) {
+				infoLogger.Error("sync: invalid block", hashes[i], "from", address)
+				return false
+			}
+			progress.Blocks++
+			progress.CurrentLevel = block.GetLevel()
+			report(progress)
+		}
+
+		last := headers[len(headers)-1].Hash(hashFunction)
+		if _, has := storage.Get(last); !has || len(headers) < MaxHeadersPerRequest {
+			return true
+		}
+	}
+}
+
+// fetchBodies ...
+// Fetch blocks by hash, spread over address and peers, with one batch per peer.
+// Missing ones are asked again from address. The result keeps the order of hashes,
+// with nil for the blocks not fetched.
+func fetchBodies(source syncSource, hashes []string, address string, peers []string) []Block {
+	sources := []string{address}
+	for _, peer := range peers {
+		if peer != address {
+			sources = append(sources, peer)
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		bodies = make(map[string]Block, len(hashes))
+	)
+	for s, peer := range sources {
+		batch := make([]string, 0, len(hashes)/len(sources)+1)
+		for i := s; i < len(hashes); i += len(sources) {
+			batch = append(batch, hashes[i])
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(peer string, batch []string) {
+			defer wg.Done()
+			fetched := fetchBatch(source, peer, batch)
+			lock.Lock()
+			defer lock.Unlock()
+			for h, block := range fetched {
+				bodies[h] = block
+			}
+		}(peer, batch)
+	}
+	wg.Wait()
+
+	missing := make([]string, 0)
+	for _, h := range hashes {
+		if bodies[h] == nil {
+			missing = append(missing, h)
+		}
+	}
+	if len(missing) > 0 && len(sources) > 1 {
+		for h, block := range fetchBatch(source, address, missing) {
+			bodies[h] = block
+		}
+	}
+
+	blocks := make([]Block, len(hashes))
+	for i, h := range hashes {
+		blocks[i] = bodies[h]
+	}
+	return blocks
+}
+
+// fetchBatch ...
+// Query hashes from address, and keep the blocks that were asked for.
+func fetchBatch(source syncSource, address string, hashes []string) map[string]Block {
+	requested := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		requested[h] = true
+	}
+	blocks := make(map[string]Block, len(hashes))
+	for _, block := range source.QueryHashes(address, hashes) {
+		if block == nil {
+			continue
+		}
+		if h := block.Hash(); requested[h] {
+			blocks[h] = block
+		}
+	}
+	return blocks
+}
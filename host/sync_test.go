@@ -0,0 +1,156 @@
+package host
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// testSyncSource ...
+// Serve the blocks of a storage as a peer does.
+type testSyncSource struct {
+	storage Storage
+	missing map[string]bool // address -> no bodies
+	tamper  bool
+	// claim: the work claimed instead of the real one.
+	claim string
+	// corrupt: hash -> serve the block without its transactions.
+	corrupt map[string]bool
+	queries int32
+}
+
+func (s *testSyncSource) GetTip(address string) (TipInfo, bool) {
+	tip := localTip(s.storage)
+	if s.claim != "" {
+		tip.Work = s.claim
+	}
+	return tip, true
+}
+
+func (s *testSyncSource) GetHeaders(address string, locator []string, max int) ([]BlockHeader, bool) {
+	headers := s.storage.GetHeaders(locator, max)
+	if s.tamper && len(headers) > 1 {
+		headers[1].PreviousHash = headers[0].PreviousHash
+	}
+	return headers, true
+}
+
+func (s *testSyncSource) QueryHashes(address string, hashes []string) []Block {
+	atomic.AddInt32(&s.queries, 1)
+	blocks := make([]Block, 0)
+	for _, h := range hashes {
+		block, has := s.storage.Get(h)
+		if !has || s.missing[address] {
+			continue
+		}
+		block = DecodeBlock(block.Encode(), block)
+		if s.corrupt[h] {
+			block.(*HippoBlock).transactions = nil
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func newSyncTestStorage() Storage {
+	balance := new(HippoBalance)
+	balance.New()
+	storage := new(HippoStorage)
+	storage.New()
+	storage.SetBalance(balance)
+	return storage
+}
+
+func TestSyncLocator(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test sync locator ==============================")
+	initBalance()
+	storage := newSyncTestStorage()
+
+	var block Block
+	for i := 0; i < 30; i++ {
+		block = mineTestBlock(block, 250, testKeys[0], nil)
+		assertT(storage.Add(block), t)
+	}
+	chain := storage.GetMainChain()
+	locator := storage.GetLocator()
+	assertT(locator[0] == chain[29].Hash() && locator[len(locator)-1] == chain[0].Hash(), t)
+	assertT(len(locator) < 20, t)
+
+	// Unknown hashes are skipped.
+	headers := storage.GetHeaders([]string{"unknown", chain[20].Hash()}, 5)
+	assertT(len(headers) == 5 && headers[0].Level == 21, t)
+	assertT(headers[0].Hash(testHashfunction) == chain[21].Hash(), t)
+	assertT(len(storage.GetHeaders([]string{"unknown"}, 100)) == 30, t)
+}
+
+func TestSyncHeadersFirst(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test headers first sync ==============================")
+	initBalance()
+	remote, local := newSyncTestStorage(), newSyncTestStorage()
+
+	genesis := mineTestBlock(nil, 250, testKeys[0], nil)
+	assertT(remote.Add(genesis), t)
+	assertT(local.Add(DecodeBlock(genesis.Encode(), genesis)), t)
+
+	// The local node has a short side branch.
+	fork := mineTestBlock(genesis, 250, testKeys[1], nil)
+	assertT(local.Add(fork), t)
+
+	block := genesis
+	for i := 0; i < 12; i++ {
+		block = mineTestBlock(block, 250, testKeys[0], nil)
+		assertT(remote.Add(block), t)
+	}
+
+	source := &testSyncSource{
+		storage: remote,
+		missing: map[string]bool{"helper": true},
+	}
+	reports := make([]SyncProgress, 0)
+	report := func(progress SyncProgress) { reports = append(reports, progress) }
+	assertT(headersFirstSync(source, "remote", []string{"helper"}, local,
+		testHashfunction, report), t)
+
+	assertT(local.GetTopBlock().Hash() == block.Hash(), t)
+	assertT(!local.IsMainChain(fork.Hash()), t)
+	// One batch from each peer, then the missing ones again from the peer.
+	assertT(atomic.LoadInt32(&source.queries) == 3, t)
+	last := reports[len(reports)-1]
+	assertT(!last.Syncing && last.Blocks == 12 && last.CurrentLevel == 12 && last.TargetLevel == 12, t)
+
+	// Nothing to do with the same work.
+	reports = reports[:0]
+	assertT(headersFirstSync(source, "remote", nil, local, testHashfunction, report), t)
+	assertT(len(reports) == 0, t)
+}
+
+func TestSyncInvalidHeaders(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("test headers first sync with invalid headers ==============================")
+	initBalance()
+	remote, local := newSyncTestStorage(), newSyncTestStorage()
+
+	var block Block
+	for i := 0; i < 3; i++ {
+		block = mineTestBlock(block, 250, testKeys[0], nil)
+		assertT(remote.Add(block), t)
+	}
+	source := &testSyncSource{storage: remote, tamper: true}
+	assertT(!headersFirstSync(source, "remote", nil, local, testHashfunction,
+		func(SyncProgress) {}), t)
+	assertT(local.GetTopBlock() == nil, t)
+
+	// The headers must have the work the peer claims.
+	source = &testSyncSource{storage: remote, claim: "1" + localTip(remote).Work}
+	assertT(!headersFirstSync(source, "remote", nil, local, testHashfunction,
+		func(SyncProgress) {}), t)
+
+	// An invalid body ends the sync.
+	local = newSyncTestStorage()
+	chain := remote.GetMainChain()
+	source = &testSyncSource{storage: remote, corrupt: map[string]bool{chain[1].Hash(): true}}
+	assertT(!headersFirstSync(source, "remote", nil, local, testHashfunction,
+		func(SyncProgress) {}), t)
+	assertT(local.GetTopBlock().Hash() == chain[0].Hash(), t)
+}
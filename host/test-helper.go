@@ -166,3 +166,37 @@ func watchStorageBalance(storage Storage, balance Balance,
 		infoLogger.Debug("balance:", balance.AllBalance())
 	}
 }
+
+// mineTestBlock ...
+// Create, sign and mine a block on top of parent. Use a nil parent for a genesis block.
+func mineTestBlock(parent Block, numBytes uint, key Key, trs []Transaction) Block {
+	return mineTestBlockAt(parent, numBytes, key, trs, time.Now().Unix())
+}
+
+// mineTestBlockAt ...
+// Same as mineTestBlock with a given timestamp.
+func mineTestBlockAt(parent Block, numBytes uint, key Key, trs []Transaction,
+	timestamp int64) Block {
+	var block HippoBlock
+	if parent == nil {
+		block.New([]byte{}, numBytes, testHashfunction, 0, testBalance, testCurve)
+	} else {
+		block.New(parent.HashBytes(), numBytes, testHashfunction,
+			parent.GetLevel()+1, testBalance, testCurve)
+	}
+	block.Timestamp = timestamp
+	var fees uint64
+	for _, t := range trs {
+		t.UpdateFee()
+		fees += t.GetFee()
+	}
+	coinbase := CreateCoinbaseTransaction(testHashfunction, testCurve, key.ToAddress(),
+		Reward(&block)+fees, block.Level)
+	block.SetTransactions(append([]Transaction{coinbase}, trs...))
+	block.Sign(key)
+
+	miningFunction := new(SingleMiningFunction)
+	miningFunction.New(testHashfunction, 1)
+	_, newBlock := miningFunction.Solve(context.Background(), block)
+	return &newBlock
+}
@@ -3,6 +3,7 @@ package host
 import (
 	"container/heap"
 	"sync"
+	"time"
 )
 
 // TransactionPool ...
@@ -16,28 +17,61 @@ type TransactionPool interface {
 	Unlock()
 	Push(t Transaction) bool
 	Pop() Transaction
+	Has(hash string) bool
+	Get(hash string) (Transaction, bool)
 	Len() int
 	Fetch(n int, checkFunc transactionPoolCheck) (result []Transaction)
+	NextNonce(address string) uint64
+	SetTimestampRules(maxFutureDrift, maxAge int64)
 }
 
 // HippoTransactionPool ...
 type HippoTransactionPool struct {
 	lock sync.Mutex
 	heap transactionHeap
-	hash map[string]bool
+	hash map[string]Transaction
+
+	// fetched into the block being mined; counted until confirmed or dropped.
+	fetched map[string]Transaction
 
 	balance Balance
 	bq      BroadcastQueue
+
+	// timestamp rules in seconds; zero disables a rule.
+	maxFutureDrift int64
+	maxAge         int64
 }
 
 // New ...
 func (tp *HippoTransactionPool) New(balance Balance, bq BroadcastQueue) {
 	heap.Init(&tp.heap)
 	tp.balance = balance
-	tp.hash = make(map[string]bool)
+	tp.hash = make(map[string]Transaction)
+	tp.fetched = make(map[string]Transaction)
 	tp.bq = bq
 }
 
+// SetTimestampRules ...
+// Reject transactions more than maxFutureDrift seconds ahead of the local clock
+// or older than maxAge seconds.
+func (tp *HippoTransactionPool) SetTimestampRules(maxFutureDrift, maxAge int64) {
+	tp.maxFutureDrift, tp.maxAge = maxFutureDrift, maxAge
+}
+
+// checkTimestamp ...
+func (tp *HippoTransactionPool) checkTimestamp(t Transaction) bool {
+	now := time.Now().Unix()
+	if tp.maxFutureDrift > 0 && t.GetTimestamp() > now+tp.maxFutureDrift {
+		infoLogger.Warn("tp push: transaction from the future:", t.Hash())
+		return false
+	}
+	if tp.maxAge > 0 && t.GetTimestamp() < now-tp.maxAge {
+		infoLogger.Warn("tp push: transaction expired:", t.Hash())
+		return false
+	}
+	return true
+}
+
 // Lock ...
 func (tp *HippoTransactionPool) Lock() {
 	tp.lock.Lock()
@@ -50,27 +84,33 @@ func (tp *HippoTransactionPool) Unlock() {
 
 // Push ...
 // Should pass the check first.
+// Transactions with a nonce already used by the sender are rejected as replays.
+// Transactions breaking the timestamp rules are rejected.
 // 1. Add to the transaction heap.
 // 2. Add to the hash map.
 // 3. Broadcast.
 func (tp *HippoTransactionPool) Push(t Transaction) bool {
 	infoLogger.Warn("tp push:", t.Hash())
-	if !t.CheckWithoutBalance() {
+	if !t.CheckWithoutBalance() || !tp.checkTimestamp(t) {
 		return false
 	}
 	infoLogger.Warn("tp push check without balance pass:", t.Hash())
+	if tp.balance != nil && tp.compareNonces(t, nil) < 0 {
+		infoLogger.Warn("tp push: nonce has been used:", t.Hash())
+		return false
+	}
 	tp.Lock()
 	defer tp.Unlock()
 	hash := t.Hash()
-	if _, has := tp.hash[hash]; !has {
+	_, fetched := tp.fetched[hash]
+	if _, has := tp.hash[hash]; !has && !fetched {
 		tp.heap.Push(t)
-		tp.hash[t.Hash()] = true
+		tp.hash[t.Hash()] = t
 
 		if tp.bq != nil {
 			var broadcastTransaction = BroadcastTransaction{
 				transaction: t,
 				Level:       0,
-				Addresses:   make(map[string]bool),
 			}
 			tp.bq.AddTransaction(broadcastTransaction)
 		} else {
@@ -100,6 +140,23 @@ func (tp *HippoTransactionPool) PopUnsafe() Transaction {
 	return t
 }
 
+// Has ...
+func (tp *HippoTransactionPool) Has(hash string) bool {
+	_, has := tp.Get(hash)
+	return has
+}
+
+// Get ...
+func (tp *HippoTransactionPool) Get(hash string) (Transaction, bool) {
+	tp.Lock()
+	defer tp.Unlock()
+	t, has := tp.hash[hash]
+	if !has {
+		t, has = tp.fetched[hash]
+	}
+	return t, has
+}
+
 // Len ...
 func (tp *HippoTransactionPool) Len() int {
 	return tp.heap.Len()
@@ -109,6 +166,11 @@ type transactionPoolCheck func(t Transaction) bool
 
 // Fetch ...
 // Fetch a number of transactions.
+// A sender's transactions are fetched in the order of nonces.
+// Transactions whose nonces have been used are dropped,
+// and those waiting for earlier nonces are pushed back.
+// The transactions of the previous fetch are fetched again unless their nonces
+// have been used, i.e. the block was confirmed.
 func (tp *HippoTransactionPool) Fetch(n int, checkFunc transactionPoolCheck) (result []Transaction) {
 	tp.Lock()
 	defer tp.Unlock()
@@ -116,28 +178,113 @@ func (tp *HippoTransactionPool) Fetch(n int, checkFunc transactionPoolCheck) (re
 	count := 0
 	result = make([]Transaction, n)
 
-	sendBackTransactions := make([]Transaction, 0)
-	for count < n && tp.Len() > 0 {
+	candidates := make([]Transaction, 0)
+	for hash, t := range tp.fetched {
+		delete(tp.fetched, hash)
+		if checkFunc(t) {
+			candidates = append(candidates, t)
+		}
+	}
+	for tp.Len() > 0 {
 		t := tp.PopUnsafe()
-		infoLogger.Warn("pop a transaction:", t.Check(tp.balance))
 		if checkFunc(t) {
-			if t.Check(tp.balance) {
-				result[count] = t
-				count++
-			} else {
-				// push back because of the balance unbalanced
-				sendBackTransactions = append(sendBackTransactions, t)
+			candidates = append(candidates, t)
+		}
+	}
+
+	pending := make(map[string]uint64)
+	for progress := true; progress && count < n; {
+		progress = false
+		rest := make([]Transaction, 0)
+		for _, t := range candidates {
+			if count >= n {
+				rest = append(rest, t)
+				continue
+			}
+			switch tp.compareNonces(t, pending) {
+			case -1:
+				infoLogger.Warn("transaction pool: drop used nonce:", t.Hash())
+			case 0:
+				if t.CheckWithoutBalance() && t.CheckBalance(tp.balance) {
+					result[count] = t
+					tp.fetched[t.Hash()] = t
+					count++
+					progress = true
+					senders, _ := t.GetSender()
+					for _, address := range senders {
+						pending[address]++
+					}
+				} else {
+					// push back because of the balance unbalanced
+					rest = append(rest, t)
+				}
+			default:
+				rest = append(rest, t)
 			}
 		}
+		candidates = rest
 	}
-	for _, t := range sendBackTransactions {
+	for _, t := range candidates {
 		tp.heap.Push(t)
+		tp.hash[t.Hash()] = t
 	}
 	result = result[:count]
 	infoLogger.Info("transaction pool: fetch transactions:", count)
 	return
 }
 
+// compareNonces ...
+// Compare the transaction's nonces with the expected ones.
+// Return -1 if any nonce has been used, 0 if all match, and 1 if it has to wait.
+func (tp *HippoTransactionPool) compareNonces(t Transaction, pending map[string]uint64) int {
+	senders, _ := t.GetSender()
+	nonces := t.GetNonces()
+	if len(nonces) != len(senders) {
+		return -1
+	}
+	result := 0
+	tp.balance.Lock()
+	defer tp.balance.Unlock()
+	for i, address := range senders {
+		expected := tp.balance.GetNonceUnsafe(address) + pending[address]
+		if nonces[i] < expected {
+			return -1
+		}
+		if nonces[i] > expected {
+			result = 1
+		}
+	}
+	return result
+}
+
+// NextNonce ...
+// The nonce for the next transaction of address, counting transactions in the pool
+// and those fetched into the block being mined.
+func (tp *HippoTransactionPool) NextNonce(address string) uint64 {
+	nonce := tp.balance.GetNonce(address)
+	tp.Lock()
+	defer tp.Unlock()
+	for _, t := range tp.heap {
+		nonce = nextNonce(t, address, nonce)
+	}
+	for _, t := range tp.fetched {
+		nonce = nextNonce(t, address, nonce)
+	}
+	return nonce
+}
+
+// nextNonce ...
+func nextNonce(t Transaction, address string, nonce uint64) uint64 {
+	senders, _ := t.GetSender()
+	nonces := t.GetNonces()
+	for i, sender := range senders {
+		if sender == address && i < len(nonces) && nonces[i] >= nonce {
+			nonce = nonces[i] + 1
+		}
+	}
+	return nonce
+}
+
 // =================================
 type transactionHeap []Transaction
 
@@ -3,7 +3,9 @@ package host
 import (
 	"crypto/elliptic"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -12,22 +14,28 @@ import (
 // 1. New(hashFunction, curve)
 // 2. SetSender(senderAddresses, senderAmounts)
 // 3. SetReceiver(receiverAddresses, receiverAmounts)
+// 3.1 SetNonces(senderNonces)
 // 4. UpdateFee()
-// 5. Sign() for all senders.
+// 4.1 SetMultisig() for the multisig senders.
+// 5. Sign() for all senders, or by enough keys of each multisig sender.
+// A coinbase transaction has no senders; see CreateCoinbaseTransaction.
 type Transaction interface {
 	New(hashFunction HashFunction, curve elliptic.Curve)
 	SetSender(senderAddresses []string,
 		senderAmounts []uint64) bool
 	SetReceiver(receiverAddresses []string,
 		receiverAmounts []uint64) bool
+	SetNonces(senderNonces []uint64) bool
 	SenderSum() uint64
 	ReceiverSum() uint64
 	GetBalanceChange() map[string]int64
 	UpdateFee() bool
 	CheckFee() bool
 	CheckBalance(balance Balance) bool
+	CheckNonces(balance Balance, pending map[string]uint64) bool
 	Sign(key Key) bool
 	SetSignature(address string, signature string) bool
+	SetMultisig(address string, m int, publicKeys []string) bool
 	CheckSignatures() bool
 	Check(balance Balance) bool
 	CheckWithoutBalance() bool
@@ -41,8 +49,12 @@ type Transaction interface {
 	GetTimestamp() int64
 	GetFee() uint64
 	GetSender() ([]string, []uint64)
+	GetNonces() []uint64
 	GetReceiver() ([]string, []uint64)
 	GetSignatures() []string
+	GetMultisig() []MultisigWitness
+	GetLevel() int
+	IsCoinbase() bool
 
 	CloneConstants() Transaction
 	CopyVariables(tr Transaction)
@@ -53,6 +65,7 @@ type Transaction interface {
 type HippoTransaction struct {
 	SenderAddresses   []string `json:"senderAddresses"`
 	SenderAmounts     []uint64 `json:"senderAmounts"`
+	SenderNonces      []uint64 `json:"senderNonces"`
 	ReceiverAddresses []string `json:"receiverAddresses"`
 	ReceiverAmounts   []uint64 `json:"receiverAmounts"`
 	Fee               uint64   `json:"fee"`
@@ -61,6 +74,13 @@ type HippoTransaction struct {
 
 	SenderSignatures []string `json:"senderSignatures"`
 	curve            elliptic.Curve
+
+	// Level of the block, only for a coinbase transaction.
+	Level int `json:"level,omitempty"`
+
+	// SenderMultisig[i] is the witness of sender i if it is a multisig address,
+	// or has M 0. Empty if there is no multisig sender.
+	SenderMultisig []MultisigWitness `json:"senderMultisig,omitempty"`
 }
 
 // New ...
@@ -70,6 +90,21 @@ func (t *HippoTransaction) New(hashFunction HashFunction, curve elliptic.Curve)
 	t.curve = curve
 }
 
+// CreateCoinbaseTransaction ...
+// The first transaction of a block, which pays the reward and the fees to the miner.
+func CreateCoinbaseTransaction(hashFunction HashFunction, curve elliptic.Curve,
+	minerAddress string, amount uint64, level int) *HippoTransaction {
+	var t HippoTransaction
+	t.New(hashFunction, curve)
+	t.SenderAddresses = make([]string, 0)
+	t.SenderAmounts = make([]uint64, 0)
+	t.SenderNonces = make([]uint64, 0)
+	t.SenderSignatures = make([]string, 0)
+	t.SetReceiver([]string{minerAddress}, []uint64{amount})
+	t.Level = level
+	return &t
+}
+
 // SetSender ...
 func (t *HippoTransaction) SetSender(senderAddresses []string,
 	senderAmounts []uint64) bool {
@@ -78,7 +113,40 @@ func (t *HippoTransaction) SetSender(senderAddresses []string,
 	}
 	t.SenderAddresses, t.SenderAmounts = senderAddresses,
 		senderAmounts
+	t.SenderNonces = make([]uint64, len(senderAddresses))
 	t.SenderSignatures = make([]string, len(senderAddresses))
+	t.SenderMultisig = nil
+	return true
+}
+
+// SetMultisig ...
+// Set the unsigned witness of the multisig sender address: m of the publicKeys.
+func (t *HippoTransaction) SetMultisig(address string, m int, publicKeys []string) bool {
+	pos := t.findAddress(address)
+	if pos == -1 {
+		debugLogger.Debug("set multisig failed: no such address")
+		return false
+	}
+	witness, err := NewMultisigWitness(m, publicKeys, t.curve)
+	if err != nil || witness.Address() != address {
+		debugLogger.Debug("set multisig failed: the keys do not match", address)
+		return false
+	}
+	if len(t.SenderMultisig) != len(t.SenderAddresses) {
+		t.SenderMultisig = make([]MultisigWitness, len(t.SenderAddresses))
+	}
+	t.SenderMultisig[pos] = witness
+	t.SenderSignatures[pos] = ""
+	return true
+}
+
+// SetNonces ...
+// Each sender's nonce is the number of transactions it has sent before.
+func (t *HippoTransaction) SetNonces(senderNonces []uint64) bool {
+	if len(senderNonces) != len(t.SenderAddresses) {
+		return false
+	}
+	t.SenderNonces = senderNonces
 	return true
 }
 
@@ -92,29 +160,41 @@ func (t *HippoTransaction) SetReceiver(receiverAddresses []string,
 	return true
 }
 
+// sumAmounts ...
+// The sum of amounts, false if an amount or the sum does not fit in an int64,
+// in which balance changes are counted.
+func sumAmounts(amounts []uint64) (uint64, bool) {
+	sum := uint64(0)
+	for _, a := range amounts {
+		if a > math.MaxInt64 || sum > math.MaxInt64-a {
+			return math.MaxUint64, false
+		}
+		sum += a
+	}
+	return sum, true
+}
+
 // SenderSum ...
+// MaxUint64 if the sum overflows.
 func (t *HippoTransaction) SenderSum() uint64 {
-	senderSum := uint64(0)
-	for _, a := range t.SenderAmounts {
-		senderSum += a
-	}
+	senderSum, _ := sumAmounts(t.SenderAmounts)
 	return senderSum
 }
 
 // ReceiverSum ...
+// MaxUint64 if the sum overflows.
 func (t *HippoTransaction) ReceiverSum() uint64 {
-	receiverSum := uint64(0)
-	for _, a := range t.ReceiverAmounts {
-		receiverSum += a
-	}
+	receiverSum, _ := sumAmounts(t.ReceiverAmounts)
 	return receiverSum
 }
 
 // UpdateFee ...
-// Return senderSum >= receiverSum
+// Return senderSum >= receiverSum, and false if either sum overflows.
 func (t *HippoTransaction) UpdateFee() bool {
-	senderSum, receiverSum := t.SenderSum(), t.ReceiverSum()
-	if senderSum < receiverSum {
+	senderSum, senderOK := sumAmounts(t.SenderAmounts)
+	receiverSum, receiverOK := sumAmounts(t.ReceiverAmounts)
+	if !senderOK || !receiverOK || senderSum < receiverSum {
+		t.Fee = 0
 		return false
 	}
 	t.Fee = senderSum - receiverSum
@@ -123,8 +203,7 @@ func (t *HippoTransaction) UpdateFee() bool {
 
 // CheckFee ...
 func (t *HippoTransaction) CheckFee() bool {
-	t.UpdateFee()
-	return t.SenderSum() == t.ReceiverSum()+t.Fee
+	return t.UpdateFee() && t.SenderSum() == t.ReceiverSum()+t.Fee
 }
 
 // CheckBalance ...
@@ -147,6 +226,34 @@ func (t *HippoTransaction) CheckBalance(balance Balance) bool {
 	return safe
 }
 
+// CheckNonces ...
+// Each sender's nonce should be its nonce in the balance plus the number of
+// its transactions ahead of this one (pending, can be nil).
+func (t *HippoTransaction) CheckNonces(balance Balance, pending map[string]uint64) bool {
+	if balance == nil {
+		infoLogger.Error("no balance for transaction nonce check")
+		return false
+	}
+	if len(t.SenderNonces) != len(t.SenderAddresses) {
+		return false
+	}
+	balance.Lock()
+	defer balance.Unlock()
+	seen := make(map[string]bool)
+	for i, address := range t.SenderAddresses {
+		if seen[address] {
+			debugLogger.Debug("check nonce failed: duplicated sender", address)
+			return false
+		}
+		seen[address] = true
+		if t.SenderNonces[i] != balance.GetNonceUnsafe(address)+pending[address] {
+			debugLogger.Debug("check nonce failed:", address, t.SenderNonces[i])
+			return false
+		}
+	}
+	return true
+}
+
 func (t *HippoTransaction) findAddress(address string) int {
 	pos := -1
 	for i, a := range t.SenderAddresses {
@@ -158,6 +265,34 @@ func (t *HippoTransaction) findAddress(address string) int {
 	return pos
 }
 
+// multisigWitness ...
+// The witness of sender pos, or nil if it is not a multisig sender.
+func (t *HippoTransaction) multisigWitness(pos int) *MultisigWitness {
+	if pos >= len(t.SenderMultisig) || t.SenderMultisig[pos].M == 0 {
+		return nil
+	}
+	return &t.SenderMultisig[pos]
+}
+
+// setSignatureUnsafe ...
+// Put the signature of address wherever it signs: as a sender, or as a key of
+// a multisig sender. The signature is not checked.
+func (t *HippoTransaction) setSignatureUnsafe(address string, signature string) bool {
+	found := false
+	for pos, a := range t.SenderAddresses {
+		if witness := t.multisigWitness(pos); witness != nil {
+			if i := witness.findKey(address); i != -1 {
+				witness.Signatures[i] = signature
+				found = true
+			}
+		} else if a == address {
+			t.SenderSignatures[pos] = signature
+			found = true
+		}
+	}
+	return found
+}
+
 // Sign ...
 func (t *HippoTransaction) Sign(key Key) bool {
 	var (
@@ -165,46 +300,85 @@ func (t *HippoTransaction) Sign(key Key) bool {
 		signature string
 	)
 
-	// Check address
-	address := key.ToAddress()
-	pos := t.findAddress(address)
-	if pos == -1 {
-		infoLogger.Error("Cannot sign the transaction:", t.Hash())
+	if signature, err = key.SignString(t.HashBytes()); err != nil {
+		infoLogger.Error("sign transaction error:", err)
 		return false
 	}
-	if signature, err = key.SignString(t.HashBytes()); err == nil {
-		t.SenderSignatures[pos] = signature
-		return true
+	if !t.setSignatureUnsafe(key.ToAddress(), signature) {
+		infoLogger.Error("Cannot sign the transaction:", t.Hash())
+		return false
 	}
-	infoLogger.Error("sign transaction error:", err)
-	return false
+	return true
 }
 
 // SetSignature ...
+// address is a sender, or a key of a multisig sender.
 func (t *HippoTransaction) SetSignature(address string, signature string) bool {
 	var key Key
-	pos := t.findAddress(address)
-	if pos == -1 {
+	key.LoadAddress(address, t.curve)
+	if !key.CheckSignString(t.Hash(), signature) {
+		debugLogger.Debug("set signature failed:", address)
+		return false
+	}
+	if !t.setSignatureUnsafe(address, signature) {
 		debugLogger.Debug("set signature failed: no such address")
 		return false
 	}
+	return true
+}
 
+// checkSender ...
+// Whether sender pos has signed the hash h, or enough of its keys for a multisig sender.
+func (t *HippoTransaction) checkSender(pos int, h string) bool {
+	address := t.SenderAddresses[pos]
+	if IsMultisigAddress(address) {
+		witness := t.multisigWitness(pos)
+		return witness != nil && witness.check(address, h, t.curve)
+	}
+	var key Key
 	key.LoadAddress(address, t.curve)
-	if !key.CheckSignString(t.Hash(), signature) {
-		debugLogger.Debug("set signature failed:", address)
+	return pos < len(t.SenderSignatures) && key.CheckSignString(h, t.SenderSignatures[pos])
+}
+
+// checkPartialSignature ...
+// Whether the signatures of sender pos so far are valid. A multisig sender needs
+// its witness.
+func (t *HippoTransaction) checkPartialSignature(pos int) bool {
+	address := t.SenderAddresses[pos]
+	if !IsMultisigAddress(address) {
+		return t.SenderSignatures[pos] == "" || t.checkSender(pos, t.Hash())
+	}
+	witness := t.multisigWitness(pos)
+	if witness == nil {
 		return false
 	}
-	t.SenderSignatures[pos] = signature
-	return true
+	return witness.checkPartial(address, t.Hash(), t.curve)
+}
+
+// checkShape ...
+// The lengths of the sender fields.
+func (t *HippoTransaction) checkShape() error {
+	n := len(t.SenderAddresses)
+	if len(t.SenderAmounts) != n || len(t.SenderSignatures) != n ||
+		len(t.ReceiverAmounts) != len(t.ReceiverAddresses) {
+		return errors.New("transaction: bad number of fields")
+	}
+	if len(t.SenderMultisig) != 0 && len(t.SenderMultisig) != n {
+		return errors.New("transaction: bad number of multisig witnesses")
+	}
+	return nil
 }
 
 // CheckSignatures ...
+// Every sender signed, and at least M keys of every multisig sender.
 func (t *HippoTransaction) CheckSignatures() bool {
-	var key Key
+	if t.checkShape() != nil {
+		debugLogger.Debug("check signatures failed: bad shape")
+		return false
+	}
 	h := t.Hash()
 	for pos, address := range t.SenderAddresses {
-		key.LoadAddress(address, t.curve)
-		if !key.CheckSignString(h, t.SenderSignatures[pos]) {
+		if !t.checkSender(pos, h) {
 			debugLogger.Debug("check signatures failed:", pos, address)
 			return false
 		}
@@ -214,9 +388,10 @@ func (t *HippoTransaction) CheckSignatures() bool {
 }
 
 // Check ...
-// Check fee + check balance + check signatures
+// Check fee + check balance + check nonces + check signatures
 func (t *HippoTransaction) Check(balance Balance) bool {
-	return t.CheckFee() && t.CheckBalance(balance) && t.CheckSignatures()
+	return t.CheckFee() && t.CheckBalance(balance) &&
+		t.CheckNonces(balance, nil) && t.CheckSignatures()
 }
 
 // CheckWithoutBalance ...
@@ -230,6 +405,9 @@ func (t *HippoTransaction) Digest() string {
 	for i := range t.SenderAddresses {
 		result += "|" + t.SenderAddresses[i]
 		result += fmt.Sprintf("|%d", t.SenderAmounts[i])
+		if i < len(t.SenderNonces) {
+			result += fmt.Sprintf("|%d", t.SenderNonces[i])
+		}
 	}
 	result += "|"
 	for i := range t.ReceiverAddresses {
@@ -237,6 +415,9 @@ func (t *HippoTransaction) Digest() string {
 		result += fmt.Sprintf("|%d", t.ReceiverAmounts[i])
 	}
 	result += fmt.Sprintf("||%d", t.Timestamp)
+	if t.IsCoinbase() {
+		result += fmt.Sprintf("||coinbase|%d", t.Level)
+	}
 	return result
 }
 
@@ -256,6 +437,11 @@ func (t *HippoTransaction) DigestSignatures() string {
 	for _, signature := range t.SenderSignatures {
 		signatures += "-" + signature
 	}
+	for pos, witness := range t.SenderMultisig {
+		for _, signature := range witness.Signatures {
+			signatures += fmt.Sprintf("-%d:%s", pos, signature)
+		}
+	}
 	return t.Digest() + signatures
 }
 
@@ -306,6 +492,9 @@ func (t *HippoTransaction) GetSender() ([]string, []uint64) {
 	return t.SenderAddresses, t.SenderAmounts
 }
 
+// GetNonces ...
+func (t *HippoTransaction) GetNonces() []uint64 { return t.SenderNonces }
+
 // GetReceiver ...
 func (t *HippoTransaction) GetReceiver() ([]string, []uint64) {
 	return t.ReceiverAddresses, t.ReceiverAmounts
@@ -314,6 +503,15 @@ func (t *HippoTransaction) GetReceiver() ([]string, []uint64) {
 // GetSignatures ...
 func (t *HippoTransaction) GetSignatures() []string { return t.SenderSignatures }
 
+// GetMultisig ...
+func (t *HippoTransaction) GetMultisig() []MultisigWitness { return t.SenderMultisig }
+
+// GetLevel ...
+func (t *HippoTransaction) GetLevel() int { return t.Level }
+
+// IsCoinbase ...
+func (t *HippoTransaction) IsCoinbase() bool { return len(t.SenderAddresses) == 0 }
+
 // CloneConstants ...
 func (t *HippoTransaction) CloneConstants() Transaction {
 	newTransaction := HippoTransaction{
@@ -327,9 +525,12 @@ func (t *HippoTransaction) CloneConstants() Transaction {
 func (t *HippoTransaction) CopyVariables(tr Transaction) {
 	t.Fee = tr.GetFee()
 	t.SenderAddresses, t.SenderAmounts = tr.GetSender()
+	t.SenderNonces = tr.GetNonces()
 	t.SenderSignatures = tr.GetSignatures()
+	t.SenderMultisig = tr.GetMultisig()
 	t.ReceiverAddresses, t.ReceiverAmounts = tr.GetReceiver()
 	t.Timestamp = tr.GetTimestamp()
+	t.Level = tr.GetLevel()
 	infoLogger.Warn("copy variables:", t)
 }
 
@@ -345,8 +546,13 @@ func (t *HippoTransaction) Encode() []byte {
 
 // DecodeTransaction ...
 func DecodeTransaction(bytes []byte, hash HashFunction, curve elliptic.Curve) Transaction {
+	var err error
 	tr := new(HippoTransaction)
-	err := json.Unmarshal(bytes, tr)
+	if IsBinary(bytes) {
+		tr, err = decodeTransactionBinary(bytes)
+	} else {
+		err = json.Unmarshal(bytes, tr)
+	}
 	if err != nil {
 		infoLogger.Error("decode transaction error:", err)
 		return nil
@@ -1,6 +1,9 @@
 package host
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestTransaction(t *testing.T) {
 	initTest(3)
@@ -97,3 +100,86 @@ func TestTransactionWithBalance(t *testing.T) {
 	debugLogger.Debug("balances:", balance.Get(testKeys[0].ToAddress()),
 		balance.Get(testKeys[1].ToAddress()), balance.Get(testKeys[2].ToAddress()))
 }
+
+func newNonceTestTransaction(nonce uint64) *HippoTransaction {
+	tr := new(HippoTransaction)
+	tr.New(testHashfunction, testCurve)
+	tr.SetSender([]string{testKeys[0].ToAddress()}, []uint64{10})
+	tr.SetNonces([]uint64{nonce})
+	tr.SetReceiver([]string{testKeys[1].ToAddress()}, []uint64{8})
+	tr.UpdateFee()
+	tr.Sign(testKeys[0])
+	return tr
+}
+
+func TestTransactionNonce(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("TestTransactionNonce=======================================================")
+
+	balance := new(HippoBalance)
+	balance.New()
+	balance.Store(testKeys[0].ToAddress(), 100)
+
+	tr0, tr1 := newNonceTestTransaction(0), newNonceTestTransaction(1)
+	assertT(tr0.Check(balance), t)
+	assertT(!tr1.Check(balance), t)
+
+	// The nonce is signed.
+	tampered := newNonceTestTransaction(0)
+	tampered.SenderNonces[0] = 1
+	assertT(!tampered.CheckSignatures(), t)
+
+	pool := new(HippoTransactionPool)
+	pool.New(balance, nil)
+	assertT(pool.Push(tr1), t)
+	assertT(pool.Push(tr0), t)
+	assertT(pool.NextNonce(testKeys[0].ToAddress()) == 2, t)
+
+	// Fetched in the order of nonces.
+	result := pool.Fetch(2, func(Transaction) bool { return true })
+	assertT(len(result) == 2, t)
+	assertT(result[0].Hash() == tr0.Hash() && result[1].Hash() == tr1.Hash(), t)
+
+	// Fetched transactions are counted until they are confirmed.
+	assertT(pool.Len() == 0 && pool.Has(tr1.Hash()), t)
+	assertT(pool.NextNonce(testKeys[0].ToAddress()) == 2, t)
+
+	// After only tr0 is included, replaying it is rejected and tr1 is fetched again.
+	balance.Lock()
+	balance.UpdateNonceUnsafe(testKeys[0].ToAddress(), 1)
+	balance.Unlock()
+	assertT(!pool.Push(tr0), t)
+	assertT(pool.NextNonce(testKeys[0].ToAddress()) == 2, t)
+	result = pool.Fetch(2, func(Transaction) bool { return true })
+	assertT(len(result) == 1 && result[0].Hash() == tr1.Hash(), t)
+
+	balance.Lock()
+	balance.UpdateNonceUnsafe(testKeys[0].ToAddress(), 1)
+	balance.Unlock()
+	assertT(len(pool.Fetch(2, func(Transaction) bool { return true })) == 0, t)
+	assertT(!pool.Has(tr1.Hash()) && pool.NextNonce(testKeys[0].ToAddress()) == 2, t)
+}
+
+func TestTransactionTimestamp(t *testing.T) {
+	initTest(2)
+	infoLogger.Debug("TestTransactionTimestamp===================================================")
+
+	balance := new(HippoBalance)
+	balance.New()
+	balance.Store(testKeys[0].ToAddress(), 100)
+	pool := new(HippoTransactionPool)
+	pool.New(balance, nil)
+	pool.SetTimestampRules(60, 3600)
+
+	at := func(timestamp int64) Transaction {
+		tr := newNonceTestTransaction(0)
+		tr.Timestamp = timestamp
+		tr.Sign(testKeys[0])
+		return tr
+	}
+	now := time.Now().Unix()
+	assertT(!pool.Push(at(now+600)), t)
+	assertT(!pool.Push(at(now-7200)), t)
+	assertT(pool.Push(at(now-600)), t)
+	assertT(pool.Push(at(now+30)), t)
+}
@@ -0,0 +1,372 @@
+package host
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Peer transports, selected by the protocol setting:
+// - tcp: plain net/rpc over TCP.
+// - tls: TLS 1.3 over TCP. Every node presents a self-signed certificate of its node key,
+//   and both sides check it. The node ID of an address is pinned on the first connection
+//   and saved to the pins file, so a later peer with another key on the same address is
+//   refused, even after a restart. Expected node IDs can be written to the file beforehand.
+//   Peers announce their node ID in the handshake, which must match their certificate.
+
+const (
+	// ProtocolTCP ...
+	ProtocolTCP = "tcp"
+	// ProtocolTLS ...
+	ProtocolTLS = "tls"
+
+	// DefaultNodeKeyPath ...
+	DefaultNodeKeyPath = "./data/node.key"
+	// DefaultNodePinsPath ...
+	DefaultNodePinsPath = "./data/node-pins.json"
+	// DefaultDialTimeout ...
+	// Seconds to connect to a peer.
+	DefaultDialTimeout = 5
+
+	nodeKeyPEMType = "EC PRIVATE KEY"
+)
+
+// Transport ...
+// How peers connect to each other.
+type Transport interface {
+	Protocol() string
+	Listen(address string) (net.Listener, error)
+	Dial(address string) (net.Conn, error)
+	// NodeID is "" for a transport without node keys.
+	NodeID() string
+	// PinnedNodeID is the node ID expected at address, if any.
+	PinnedNodeID(address string) (string, bool)
+}
+
+// NewTransport ...
+// nodeKey is required by ProtocolTLS only.
+func NewTransport(protocol string, nodeKey *ecdsa.PrivateKey) (Transport, error) {
+	switch protocol {
+	case ProtocolTCP, "":
+		return new(TCPTransport), nil
+	case ProtocolTLS:
+		t := new(TLSTransport)
+		return t, t.New(nodeKey)
+	}
+	return nil, fmt.Errorf("unknown protocol %q", protocol)
+}
+
+// TCPTransport ...
+type TCPTransport struct{}
+
+// Protocol ...
+func (t *TCPTransport) Protocol() string { return ProtocolTCP }
+
+// Listen ...
+func (t *TCPTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+// Dial ...
+func (t *TCPTransport) Dial(address string) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, DefaultDialTimeout*time.Second)
+}
+
+// NodeID ...
+func (t *TCPTransport) NodeID() string { return "" }
+
+// PinnedNodeID ...
+func (t *TCPTransport) PinnedNodeID(address string) (string, bool) { return "", false }
+
+// TLSTransport ...
+// TLSTransport is thread-safe.
+type TLSTransport struct {
+	certificate tls.Certificate
+	nodeID      string
+	// pins: address -> node ID, saved to pinsPath unless it is empty.
+	pins     sync.Map
+	pinsLock sync.Mutex
+	pinsPath string
+}
+
+// New ...
+func (t *TLSTransport) New(nodeKey *ecdsa.PrivateKey) (err error) {
+	if nodeKey == nil {
+		return errors.New("tls transport: no node key")
+	}
+	t.certificate, err = nodeCertificate(nodeKey)
+	t.nodeID = NodeID(&nodeKey.PublicKey)
+	return
+}
+
+// Protocol ...
+func (t *TLSTransport) Protocol() string { return ProtocolTLS }
+
+// NodeID ...
+func (t *TLSTransport) NodeID() string { return t.nodeID }
+
+// Listen ...
+// Clients must present a valid node certificate.
+func (t *TLSTransport) Listen(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, t.config(tls.RequireAnyClientCert)), nil
+}
+
+// Dial ...
+// Fail if the node ID of address differs from the pinned one.
+func (t *TLSTransport) Dial(address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: DefaultDialTimeout * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, t.config(tls.NoClientCert))
+	if err != nil {
+		return nil, err
+	}
+	nodeID, err := PeerNodeID(conn)
+	if err == nil {
+		pinned, loaded := t.pins.LoadOrStore(address, nodeID)
+		if loaded && pinned.(string) != nodeID {
+			err = fmt.Errorf("tls transport: node ID of %s changed from %s to %s",
+				address, pinned, nodeID)
+		} else if !loaded {
+			t.savePins()
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Pin ...
+// Expect nodeID at address, e.g. from the configuration.
+func (t *TLSTransport) Pin(address, nodeID string) {
+	t.pins.Store(address, nodeID)
+	t.savePins()
+}
+
+// Unpin ...
+// Accept a new node key at address.
+func (t *TLSTransport) Unpin(address string) {
+	t.pins.Delete(address)
+	t.savePins()
+}
+
+// SetPinsPath ...
+// Load the pins saved at path, a JSON object of addresses to node IDs, and save
+// the new ones there. A missing file is created with the first pin.
+func (t *TLSTransport) SetPinsPath(path string) error {
+	t.pinsLock.Lock()
+	defer t.pinsLock.Unlock()
+	t.pinsPath = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pins := make(map[string]string)
+	if err = json.Unmarshal(data, &pins); err != nil {
+		return fmt.Errorf("node pins: %s: %v", path, err)
+	}
+	for address, nodeID := range pins {
+		t.pins.Store(address, nodeID)
+	}
+	infoLogger.Info("node pins: load", len(pins), "from", path)
+	return nil
+}
+
+// savePins ...
+func (t *TLSTransport) savePins() {
+	t.pinsLock.Lock()
+	defer t.pinsLock.Unlock()
+	if t.pinsPath == "" {
+		return
+	}
+	pins := make(map[string]string)
+	t.pins.Range(func(k, v interface{}) bool {
+		pins[k.(string)] = v.(string)
+		return true
+	})
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(t.pinsPath), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(t.pinsPath, data, 0600)
+	}
+	if err != nil {
+		infoLogger.Error("node pins: save:", err)
+	}
+}
+
+// PinnedNodeID ...
+func (t *TLSTransport) PinnedNodeID(address string) (string, bool) {
+	nodeID, has := t.pins.Load(address)
+	if !has {
+		return "", false
+	}
+	return nodeID.(string), true
+}
+
+func (t *TLSTransport) config(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{t.certificate},
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS13,
+		// Node certificates are self-signed, so they are checked by
+		// verifyNodeCertificate instead of a CA.
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyNodeCertificate,
+	}
+}
+
+// NodeID ...
+// The hex SHA-256 of the public node key.
+func NodeID(publicKey *ecdsa.PublicKey) string {
+	h := sha256.Sum256(elliptic.Marshal(publicKey.Curve, publicKey.X, publicKey.Y))
+	return ByteToHexString(h[:])
+}
+
+// PeerNodeID ...
+// The node ID of the other side of a TLS connection.
+func PeerNodeID(conn *tls.Conn) (string, error) {
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return "", errors.New("tls transport: no peer certificate")
+	}
+	publicKey, ok := certificates[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return "", errors.New("tls transport: peer certificate is not ECDSA")
+	}
+	return NodeID(publicKey), nil
+}
+
+// verifyNodeCertificate ...
+// Accept exactly one valid, self-signed ECDSA certificate.
+func verifyNodeCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) != 1 {
+		return fmt.Errorf("tls transport: %d peer certificates, expected 1", len(rawCerts))
+	}
+	certificate, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	if _, ok := certificate.PublicKey.(*ecdsa.PublicKey); !ok {
+		return errors.New("tls transport: peer certificate is not ECDSA")
+	}
+	now := time.Now()
+	if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+		return errors.New("tls transport: peer certificate expired")
+	}
+	return certificate.CheckSignature(certificate.SignatureAlgorithm,
+		certificate.RawTBSCertificate, certificate.Signature)
+}
+
+// nodeCertificate ...
+// A self-signed certificate of the node key. Its subject is the node ID.
+func nodeCertificate(nodeKey *ecdsa.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: NodeID(&nodeKey.PublicKey)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&nodeKey.PublicKey, nodeKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: nodeKey}, nil
+}
+
+// LoadNodeKey ...
+// Load the node key from path, or create a P-256 key there if it does not exist.
+// The node key only authenticates the transport; it is not the mining key.
+func LoadNodeKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return createNodeKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != nodeKeyPEMType {
+		return nil, fmt.Errorf("node key: no %s in %s", nodeKeyPEMType, path)
+	}
+	return byteToKey(block.Bytes)
+}
+
+func createNodeKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: nodeKeyPEMType, Bytes: keyToByte(key)})
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	infoLogger.Info("node key: create", path, NodeID(&key.PublicKey))
+	return key, nil
+}
+
+// connectTimeout ...
+// The time for a peer to answer the HTTP CONNECT of dialRPC, and for a client
+// of the P2P server to finish the TLS handshake and send its CONNECT.
+var connectTimeout = DefaultDialTimeout * time.Second
+
+// dialRPC ...
+// Same as rpc.DialHTTP, over a connection of the transport.
+// A peer that does not answer the CONNECT within connectTimeout is given up.
+func dialRPC(transport Transport, address string) (*rpc.Client, error) {
+	conn, err := transport.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && response.Status != "200 Connected to Go RPC" {
+		err = errors.New("unexpected HTTP response: " + response.Status)
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
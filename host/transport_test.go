@@ -0,0 +1,200 @@
+package host
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testEchoService struct{}
+
+func (testEchoService) Echo(request string, reply *string) error {
+	*reply = request
+	return nil
+}
+
+// newTestTLSTransport ...
+// Serve testEchoService over a TLS listener of a new node key.
+func newTestTLSTransport(t *testing.T, dir, name string) (*TLSTransport, net.Listener) {
+	key, err := LoadNodeKey(filepath.Join(dir, name))
+	assertT(err == nil, t)
+	transport, err := NewTransport(ProtocolTLS, key)
+	assertT(err == nil, t)
+	listener, err := transport.Listen("127.0.0.1:0")
+	assertT(err == nil, t)
+
+	server := rpc.NewServer()
+	server.RegisterName("Echo", testEchoService{})
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
+	go http.Serve(listener, mux)
+	return transport.(*TLSTransport), listener
+}
+
+func TestTLSTransport(t *testing.T) {
+	initTest(0)
+	dir := t.TempDir()
+
+	key, err := LoadNodeKey(filepath.Join(dir, "a.key"))
+	assertT(err == nil, t)
+	reloaded, err := LoadNodeKey(filepath.Join(dir, "a.key"))
+	assertT(err == nil && NodeID(&reloaded.PublicKey) == NodeID(&key.PublicKey), t)
+	_, err = NewTransport(ProtocolTLS, nil)
+	assertT(err != nil, t)
+	_, err = NewTransport("udp", nil)
+	assertT(err != nil, t)
+
+	server, listener := newTestTLSTransport(t, dir, "a.key")
+	defer listener.Close()
+	assertT(server.NodeID() == NodeID(&key.PublicKey), t)
+	client, clientListener := newTestTLSTransport(t, dir, "b.key")
+	clientListener.Close()
+	address := listener.Addr().String()
+
+	c, err := dialRPC(client, address)
+	assertT(err == nil, t)
+	var reply string
+	assertT(c.Call("Echo.Echo", "hippo", &reply) == nil && reply == "hippo", t)
+	c.Close()
+	pinned, ok := client.PinnedNodeID(address)
+	assertT(ok && pinned == server.NodeID(), t)
+
+	// Plain clients and clients without a certificate are refused.
+	_, err = rpc.DialHTTP("tcp", address)
+	assertT(err != nil, t)
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		_, err = conn.Write([]byte("CONNECT"))
+		if err == nil {
+			_, err = conn.Read(make([]byte, 1))
+		}
+		conn.Close()
+	}
+	assertT(err != nil, t)
+
+	// Another node key on a pinned address is refused.
+	client.Pin(address, NodeID(&reloaded.PublicKey)[1:])
+	_, err = dialRPC(client, address)
+	assertT(err != nil, t)
+	client.Unpin(address)
+	c, err = dialRPC(client, address)
+	assertT(err == nil, t)
+	c.Close()
+}
+
+func TestDialRPCSilentPeer(t *testing.T) {
+	initTest(0)
+	// A peer that accepts the connection and never answers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assertT(err == nil, t)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	timeout := connectTimeout
+	connectTimeout = 100 * time.Millisecond
+	defer func() { connectTimeout = timeout }()
+	start := time.Now()
+	_, err = dialRPC(new(TCPTransport), listener.Addr().String())
+	assertT(err != nil && time.Since(start) < time.Second, t)
+}
+
+func TestTLSTransportPins(t *testing.T) {
+	initTest(0)
+	dir := t.TempDir()
+	pinsPath := filepath.Join(dir, "pins", "node-pins.json")
+
+	server, listener := newTestTLSTransport(t, dir, "a.key")
+	defer listener.Close()
+	client, clientListener := newTestTLSTransport(t, dir, "b.key")
+	clientListener.Close()
+	assertT(client.SetPinsPath(pinsPath) == nil, t)
+	address := listener.Addr().String()
+	c, err := dialRPC(client, address)
+	assertT(err == nil, t)
+	c.Close()
+
+	// The pins survive a restart.
+	restarted, restartedListener := newTestTLSTransport(t, dir, "b.key")
+	restartedListener.Close()
+	assertT(restarted.SetPinsPath(pinsPath) == nil, t)
+	pinned, ok := restarted.PinnedNodeID(address)
+	assertT(ok && pinned == server.NodeID(), t)
+	restarted.Pin(address, server.NodeID()[1:])
+	_, err = dialRPC(restarted, address)
+	assertT(err != nil, t)
+}
+
+func TestP2PServerNodeID(t *testing.T) {
+	initTest(0)
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Set before the server runs, and restored after it is closed.
+	timeout := connectTimeout
+	connectTimeout = 500 * time.Millisecond
+	defer func() { connectTimeout = timeout }()
+
+	key, err := LoadNodeKey(filepath.Join(dir, "server.key"))
+	assertT(err == nil, t)
+	transport, err := NewTransport(ProtocolTLS, key)
+	assertT(err == nil, t)
+	listener, err := transport.Listen("127.0.0.1:0")
+	assertT(err == nil, t)
+	s := new(P2PServer)
+	s.new(ctx, listener)
+	defer s.close()
+	s.setHandshake(func() HandshakeInfo { return HandshakeInfo{Version: ProtocolVersion} })
+	s.setTransport(transport)
+	s.serve()
+	address := listener.Addr().String()
+
+	clientTransport, clientListener := newTestTLSTransport(t, dir, "client.key")
+	clientListener.Close()
+	dial := func() *P2PClient {
+		client := new(P2PClient)
+		client.SetTransport(clientTransport)
+		assertT(client.New(ctx, ProtocolTLS, address) == nil, t)
+		return client
+	}
+
+	// The announced node ID must be the one of the certificate.
+	client := dial()
+	_, err = client.Handshake(HandshakeInfo{Version: ProtocolVersion, NodeID: transport.NodeID()})
+	assertT(err != nil && err.Error() == ErrNodeIDMismatch.Error(), t)
+	client.Close()
+	client = dial()
+	info := HandshakeInfo{Version: ProtocolVersion, NodeID: clientTransport.NodeID(),
+		Address: "127.0.0.1:1"}
+	_, err = client.Handshake(info)
+	assertT(err == nil, t)
+	client.Close()
+
+	// So must the node ID pinned for the announced address.
+	transport.(*TLSTransport).Pin(info.Address, transport.NodeID())
+	client = dial()
+	_, err = client.Handshake(info)
+	assertT(err != nil && err.Error() == ErrNodeIDMismatch.Error(), t)
+	client.Close()
+
+	// A client that never finishes the TLS handshake is dropped.
+	conn, err := net.Dial("tcp", address)
+	assertT(err == nil, t)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	assertT(err != nil && time.Since(start) < 3*time.Second, t)
+}
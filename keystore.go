@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/XieGuochao/HippoCoin/host"
+)
+
+const keystoreUsage = "usage: coin keystore create|import|export|passwd [config.yml]"
+
+// runKeystore ...
+// Manage the keystore at the key-file of the config. Passwords and keys are read from stdin.
+func runKeystore(args []string) error {
+	if len(args) == 0 {
+		return errors.New(keystoreUsage)
+	}
+	configPath := "./host.yml"
+	if len(args) > 1 {
+		configPath = args[1]
+	}
+	var config HippoConfig
+	config.Load(configPath)
+	if config.KeyFile == "" {
+		return errors.New("no key-file in " + configPath)
+	}
+	stdin := bufio.NewReader(os.Stdin)
+
+	switch args[0] {
+	case "create":
+		password, err := readNewPassword(stdin)
+		if err != nil {
+			return err
+		}
+		if _, err = host.CreateKeystore(config.KeyFile, config.curve, password); err != nil {
+			return err
+		}
+	case "import":
+		priString, err := readLine(stdin, "private key: ")
+		if err != nil {
+			return err
+		}
+		password, err := readNewPassword(stdin)
+		if err != nil {
+			return err
+		}
+		if _, err = host.ImportKeystore(config.KeyFile, priString, password); err != nil {
+			return err
+		}
+	case "export":
+		password, err := readLine(stdin, "password: ")
+		if err != nil {
+			return err
+		}
+		priString, err := host.ExportKeystore(config.KeyFile, password)
+		if err != nil {
+			return err
+		}
+		fmt.Println(priString)
+		return nil
+	case "passwd":
+		oldPassword, err := readLine(stdin, "old password: ")
+		if err != nil {
+			return err
+		}
+		password, err := readNewPassword(stdin)
+		if err != nil {
+			return err
+		}
+		if err = host.ChangeKeystorePassword(config.KeyFile, oldPassword, password); err != nil {
+			return err
+		}
+	default:
+		return errors.New(keystoreUsage)
+	}
+	file, err := host.ReadKeystore(config.KeyFile)
+	if err != nil {
+		return err
+	}
+	fmt.Println("address:", file.Address)
+	return nil
+}
+
+func readLine(r *bufio.Reader, prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func readNewPassword(r *bufio.Reader) (string, error) {
+	password, err := readLine(r, "new password: ")
+	if err != nil {
+		return "", err
+	}
+	again, err := readLine(r, "repeat password: ")
+	if err != nil {
+		return "", err
+	}
+	if password != again {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}
@@ -12,6 +12,7 @@ import (
 
 	. "github.com/XieGuochao/HippoCoin/host"
 	"github.com/XieGuochao/HippoCoin/ui"
+	"github.com/XieGuochao/HippoCoin/wallet"
 )
 
 var version = "1.0"
@@ -47,6 +48,13 @@ func initLogger(debugPath string) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keystore" {
+		if err := runKeystore(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	fmt.Printf("Hippo Coin v%s\n", version)
 	fmt.Println("By Guochao Xie")
 	fmt.Println(`                                                     
@@ -99,9 +107,52 @@ func main() {
 		infoPath = fmt.Sprintf(infoPath, t)
 	}
 
+	SetRewardSchedule(RewardSchedule{
+		Initial:         config.RewardInitial,
+		HalvingInterval: config.RewardHalvingInterval,
+		MaxSupply:       config.RewardMaxSupply,
+	})
+	host.SetChainConfig(config.ChainID, config.GenesisHash)
+	host.SetHeaderDifficulty(NewWindowHeaderDifficulty(config.DifficultyWindow))
+	host.SetPeerConfig(config.SeedPeers, config.AddressBookPath)
+	host.SetBanDuration(int64(config.BanDuration))
+	host.SetNodeKeyPath(config.NodeKeyPath)
+	host.SetNodePinsPath(config.NodePinsPath)
+	host.SetPoolConfig(PoolConfig{
+		CallTimeout:       time.Duration(config.RPCTimeout) * time.Second,
+		MaxInFlight:       config.MaxInFlight,
+		HealthCheckPeriod: time.Duration(config.HealthCheckPeriod) * time.Second,
+	})
+	host.SetMaxInbound(config.MaxInbound)
+	host.SetExternalAddress(config.ExternalAddress)
+	host.SetServerLimits(ServerLimits{
+		MaxMessageSize: config.MaxMessageSize,
+		MaxLevelSpan:   config.MaxLevelSpan,
+		MaxHashes:      config.MaxHashes,
+		Rate:           config.RateLimit,
+		Burst:          config.RateBurst,
+	})
+	host.SetStorageConfig(config.StorageBackend, config.StoragePath)
+	host.SetTimestampConfig(config.MedianTimeWindow, int64(config.BlockFutureDrift),
+		int64(config.TransactionFutureDrift), int64(config.TransactionMaxAge))
 	host.New(true, debugPath, infoPath, config.curve, config.LocalMode)
 	host.InitLogger(true)
 	debugLogger, infoLogger = host.GetLoggers()
+	password, err := config.KeyPassword()
+	if err != nil {
+		infoLogger.Fatal("key password:", err)
+	}
+	if config.KeyFile != "" {
+		if err = host.SetKeyFile(config.KeyFile, password); err == ErrEmptyPassword {
+			infoLogger.Fatal("key file: set key-password-file or HIPPO_KEY_PASSWORD to create", config.KeyFile)
+		} else if err != nil {
+			infoLogger.Fatal("key file:", err)
+		}
+	}
+	if password == "" {
+		infoLogger.Warn("NO KEY PASSWORD: the wallet accounts in", config.WalletPath,
+			"are not protected. Set key-password-file or HIPPO_KEY_PASSWORD.")
+	}
 	infoLogger.Info("localmode:", config.LocalMode)
 
 	fmt.Println("output to debug file:", t+"-debug.out")
@@ -110,13 +161,30 @@ func main() {
 	fmt.Println("set max procs:", config.MiningThreads+2)
 	host.InitLocals(ctx, Hash, config.miningFunction, config.MiningThreads,
 		new(P2PClient), uint(config.BroadcastQueueLen), MiningCallbackBroadcastSave,
-		BasicDifficulty, int64(config.MiningInterval), config.MiningCapacity,
+		NewWindowDifficulty(config.DifficultyWindow), int64(config.MiningInterval), config.MiningCapacity,
 		int64(config.MiningTTL), config.Protocol)
 	host.InitNetwork(new(HippoBlock), new(HippoTransaction), config.MaxNeighbors, config.UpdateTimeBase, config.UpdateTimeRand,
 		config.RegisterAddress, config.RegisterProtocol, config.ListenerPort)
 
+	w := new(wallet.HippoWallet)
+	if err = w.New(config.WalletPath, password, host); err != nil {
+		infoLogger.Fatal(err)
+	}
+	var hostKey Key
+	hostKey.New(config.curve)
+	if err = hostKey.LoadPrivateKeyString(host.PrivateKey(), config.curve); err == nil {
+		_, err = w.AddKey("host", hostKey)
+	}
+	if err != nil {
+		infoLogger.Error("wallet: host key:", err)
+	}
+
 	u.New(debugLogger, infoLogger, host)
-	u.Main(config.UIPort)
+	u.SetWallet(w)
+	u.SetPassword(config.UIPassword())
+	if err = u.Main(config.UIHost, config.UIPort); err != nil {
+		infoLogger.Fatal(err)
+	}
 
 	host.Run()
 }
@@ -4,8 +4,11 @@ go 1.15
 
 replace github.com/XieGuochao/HippoCoin/host => ../host
 
+replace github.com/XieGuochao/HippoCoin/wallet => ../wallet
+
 require (
 	github.com/XieGuochao/HippoCoin/host v0.0.0-00010101000000-000000000000
+	github.com/XieGuochao/HippoCoin/wallet v0.0.0-00010101000000-000000000000
 	github.com/gin-gonic/gin v1.6.3
 	github.com/withmandala/go-log v0.1.0
 )
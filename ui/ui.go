@@ -1,25 +1,38 @@
 package ui
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/withmandala/go-log"
 
 	"github.com/XieGuochao/HippoCoin/host"
+	"github.com/XieGuochao/HippoCoin/wallet"
 	"github.com/gin-gonic/gin"
 )
 
 // UI ...
 type UI struct {
 	h           host.Host
+	w           wallet.Wallet
 	r           *gin.Engine
 	debugLogger *log.Logger
 	infoLogger  *log.Logger
+
+	// password of the basic auth, none if empty.
+	password string
+	// csrfToken: every POST form carries it.
+	csrfToken string
 }
 
 // UIBlock ...
@@ -51,6 +64,9 @@ func (u *UI) New(debugLogger, infoLogger *log.Logger, h host.Host) {
 	u.h = h
 	u.r = gin.Default()
 	u.r.LoadHTMLGlob("./templates/*")
+	u.csrfToken = newCSRFToken()
+
+	u.r.Use(u.authenticate, u.checkCSRF)
 
 	u.r.Use(func(c *gin.Context) {
 		if u.h != nil {
@@ -86,159 +102,96 @@ func (u *UI) New(debugLogger, infoLogger *log.Logger, h host.Host) {
 	})
 
 	u.r.POST("/transfer-post", func(c *gin.Context) {
-		var (
-			SenderAddresses   []string
-			senderAmounts     []uint64
-			senderKeys        []host.Key
-			receiverAddresses []string
-			receiverAmounts   []uint64
-			// fee               uint64
-
-			numSenders   = 0
-			numReceivers = 0
-
-			err error
-		)
-		c.MultipartForm()
-
-		for key, value := range c.Request.PostForm {
-			infoLogger.Info(key, value)
-		}
-
-		// First, iterate through sender amounts and receiver amounts to determine size.
-		for key, value := range c.Request.PostForm {
-			switch {
-			case len(value) == 0 || value[0] == "0":
-				continue
-			case strings.Contains(key, "sender-amount-"):
-				v, err := strconv.Atoi(key[len("sender-amount-"):])
-				if err != nil {
-					infoLogger.Error("transfer-post error:", err)
-					c.String(http.StatusBadRequest, err.Error())
-					return
-				}
-				if v > numSenders {
-					numSenders = v
-				}
-			case strings.Contains(key, "receiver-amount-"):
-				v, err := strconv.Atoi(key[len("receiver-amount-"):])
-				if err != nil {
-					infoLogger.Error("transfer-post error:", err)
-					c.String(http.StatusBadRequest, err.Error())
-					return
-				}
-				if v > numReceivers {
-					numReceivers = v
-				}
-			}
+		newTransaction, err := u.transferForm(c, true)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if ok := h.AddTransaction(newTransaction); !ok {
+			c.String(http.StatusBadRequest, "host add transaction failed.")
+			infoLogger.Error("ui: transfer-post transaction check failed.")
+			return
 		}
 
-		numSenders++
-		numReceivers++
-
-		SenderAddresses = make([]string, 0)
-		senderAmounts = make([]uint64, 0)
-		senderKeys = make([]host.Key, 0)
-
-		receiverAddresses = make([]string, 0)
-		receiverAmounts = make([]uint64, 0)
-
-		// feeStr := c.Request.FormValue("fee")
-		// feeInt, _ := strconv.Atoi(feeStr)
-		// fee = uint64(feeInt)
-
-		for i := 0; i < numSenders; i++ {
-			var key, value string
-			key = fmt.Sprintf("sender-addr-%d", i)
-			if value = c.Request.PostFormValue(key); value == "" {
-				c.String(http.StatusBadRequest, "empty sender address.")
-				return
-			}
-			SenderAddresses = append(SenderAddresses, value)
-
-			key = fmt.Sprintf("sender-amount-%d", i)
-			if value = c.Request.PostFormValue(key); value == "" {
-				c.String(http.StatusBadRequest, "empty sender amount.")
-				return
-			}
-			if amount, err := strconv.Atoi(value); err != nil {
-				infoLogger.Error("transfer-post error:", err)
-				c.String(http.StatusBadRequest, err.Error())
-				return
-			} else {
-				senderAmounts = append(senderAmounts, uint64(amount))
-			}
+		infoLogger.Info("transfer-post success")
+		c.String(200, "OK")
+	})
 
-			key = fmt.Sprintf("sender-key-%d", i)
-			if value = c.Request.PostFormValue(key); value == "" {
-				c.String(http.StatusBadRequest, "empty sender key.")
-				return
-			}
-			senderKey := host.Key{}
-			if err = senderKey.LoadPrivateKeyString(value, h.GetCurve()); err != nil {
-				infoLogger.Error("transfer-post error:", err)
-				c.String(http.StatusBadRequest, err.Error())
-				return
-			}
-			infoLogger.Info("sender key:", senderKey, senderKey.Key().Curve)
-			senderKeys = append(senderKeys, senderKey)
+	// Partially signed transactions, for senders whose keys are on different machines.
+	// Each endpoint but submit-post returns the transaction to pass to the next signer.
+	u.r.POST("/pst/create-post", func(c *gin.Context) {
+		tr, err := u.transferForm(c, false)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
 		}
+		c.Data(200, "application/json; charset=utf-8", host.NewPartiallySignedTransaction(tr).Encode())
+	})
 
-		for i := 0; i < numReceivers; i++ {
-			var key, value string
-			key = fmt.Sprintf("receiver-addr-%d", i)
-			if value = c.Request.PostFormValue(key); value == "" {
-				c.String(http.StatusBadRequest, "empty receiver address.")
-				return
-			}
-			receiverAddresses = append(receiverAddresses, value)
-
-			key = fmt.Sprintf("receiver-amount-%d", i)
-			if value = c.Request.PostFormValue(key); value == "" {
-				c.String(http.StatusBadRequest, "empty receiver amount")
-				return
+	// Sign by the wallet account, the private key, or add the signature of signer.
+	u.r.POST("/pst/sign-post", func(c *gin.Context) {
+		p, err := u.partialForm(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		account, priString := c.Request.PostFormValue("account"), c.Request.PostFormValue("private-key")
+		signer, signature := c.Request.PostFormValue("signer"), c.Request.PostFormValue("signature")
+		switch {
+		case account != "" && u.w == nil:
+			err = errors.New("no wallet.")
+		case account != "":
+			err = u.w.SignPartial(account, p)
+		case priString != "":
+			key := host.Key{}
+			if err = key.LoadPrivateKeyString(priString, h.GetCurve()); err == nil && !p.Sign(key) {
+				err = errors.New("the key does not sign the transaction.")
 			}
-			if amount, err := strconv.Atoi(value); err != nil {
-				infoLogger.Error("transfer-post error:", err)
-				c.String(http.StatusBadRequest, err.Error())
-				return
-			} else {
-				receiverAmounts = append(receiverAmounts, uint64(amount))
+		case signer != "" && signature != "":
+			if !p.SetSignature(signer, signature) {
+				err = errors.New("bad signature.")
 			}
+		default:
+			err = errors.New("no account, key or signature.")
 		}
-
-		var newTransaction host.Transaction
-		var ok bool
-		newTransaction = new(host.HippoTransaction)
-		newTransaction.New(h.GetHashFunction(), h.GetCurve())
-		if ok = newTransaction.SetSender(SenderAddresses, senderAmounts); !ok {
-			c.String(http.StatusBadRequest, "set senders failed.")
-			infoLogger.Error("ui: transfer-post set senders failed.")
+		if err != nil {
+			infoLogger.Error("pst sign-post error:", err)
+			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
-		if ok = newTransaction.SetReceiver(receiverAddresses, receiverAmounts); !ok {
-			c.String(http.StatusBadRequest, "set receivers failed.")
-			infoLogger.Error("ui: transfer-post set receivers failed.")
+		c.Data(200, "application/json; charset=utf-8", p.Encode())
+	})
+
+	u.r.POST("/pst/inspect-post", func(c *gin.Context) {
+		p, err := u.partialForm(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
 			return
 		}
-		if ok = newTransaction.UpdateFee(); !ok {
-			c.String(http.StatusBadRequest, "Wrong fee!")
+		c.JSON(200, gin.H{
+			"hash":     p.Hash,
+			"complete": p.Complete(),
+			"missing":  p.Missing(),
+			"senders":  p.Status(),
+		})
+	})
+
+	u.r.POST("/pst/submit-post", func(c *gin.Context) {
+		p, err := u.partialForm(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
 		}
-		for _, key := range senderKeys {
-			if ok = newTransaction.Sign(key); !ok {
-				c.String(http.StatusBadRequest, "sign failed.")
-				infoLogger.Error("ui: transfer-post sign failed.")
-				return
-			}
+		if missing := p.Missing(); len(missing) > 0 {
+			c.String(http.StatusBadRequest, "missing signatures of: "+strings.Join(missing, ", "))
+			return
 		}
-
-		if ok = h.AddTransaction(newTransaction); !ok {
+		if ok := h.AddTransaction(p.Transaction); !ok {
 			c.String(http.StatusBadRequest, "host add transaction failed.")
-			infoLogger.Error("ui: transfer-post transaction check failed.")
+			infoLogger.Error("ui: pst submit-post transaction check failed.")
 			return
 		}
-
-		infoLogger.Info("transfer-post success")
+		infoLogger.Info("pst submit-post success:", p.Hash)
 		c.String(200, "OK")
 	})
 
@@ -258,9 +211,145 @@ func (u *UI) New(debugLogger, infoLogger *log.Logger, h host.Host) {
 			"privateKey": c.GetString("private-key"),
 			"publicKey":  c.GetString("public-key"),
 			"myBalance":  myBalance,
+			"myNonce":    h.GetNonce(c.GetString("public-key")),
+			"csrf":       u.csrfToken,
+		})
+	})
+
+	u.r.GET("/wallet", func(c *gin.Context) {
+		if u.w == nil {
+			c.HTML(200, "wallet.html", gin.H{"noWallet": true, "csrf": u.csrfToken})
+			return
+		}
+		c.HTML(200, "wallet.html", gin.H{
+			"accounts": u.w.Balances(),
+			"contacts": u.w.AddressBook().Contacts(),
+			"hasSeed":  u.w.HasSeed(),
+			"csrf":     u.csrfToken,
 		})
 	})
 
+	// An empty mnemonic creates a new seed and shows its mnemonic once.
+	// Otherwise the seed is restored and the used accounts are scanned.
+	u.r.POST("/wallet/seed-post", func(c *gin.Context) {
+		if u.w == nil {
+			c.String(http.StatusNotFound, "no wallet.")
+			return
+		}
+		mnemonic := c.Request.PostFormValue("mnemonic")
+		if mnemonic == "" {
+			created, err := u.w.NewSeed(wallet.DefaultMnemonicBits)
+			if err != nil {
+				infoLogger.Error("wallet seed-post error:", err)
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			c.String(200, "Write down your mnemonic, it is not shown again:\n\n"+created)
+			return
+		}
+		if _, err := u.w.RestoreSeed(mnemonic, wallet.DefaultGapLimit); err != nil {
+			infoLogger.Error("wallet seed-post error:", err)
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/wallet")
+	})
+
+	u.r.POST("/wallet/scan-post", func(c *gin.Context) {
+		if u.w == nil {
+			c.String(http.StatusNotFound, "no wallet.")
+			return
+		}
+		if _, err := u.w.Scan(wallet.DefaultGapLimit); err != nil {
+			infoLogger.Error("wallet scan-post error:", err)
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/wallet")
+	})
+
+	u.r.POST("/wallet/account-post", func(c *gin.Context) {
+		if u.w == nil {
+			c.String(http.StatusNotFound, "no wallet.")
+			return
+		}
+		var err error
+		name := c.Request.PostFormValue("name")
+		priString := c.Request.PostFormValue("private-key")
+		switch {
+		case priString != "":
+			_, err = u.w.ImportAccount(name, priString)
+		case u.w.HasSeed():
+			_, err = u.w.DeriveAccount(name)
+		default:
+			_, err = u.w.CreateAccount(name)
+		}
+		if err != nil {
+			infoLogger.Error("wallet account-post error:", err)
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/wallet")
+	})
+
+	u.r.POST("/wallet/contact-post", func(c *gin.Context) {
+		if u.w == nil {
+			c.String(http.StatusNotFound, "no wallet.")
+			return
+		}
+		var err error
+		label, address := c.Request.PostFormValue("label"), c.Request.PostFormValue("address")
+		if address == "" {
+			err = u.w.RemoveContact(label)
+		} else {
+			err = u.w.SetContact(label, address)
+		}
+		if err != nil {
+			infoLogger.Error("wallet contact-post error:", err)
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/wallet")
+	})
+
+	// Same receivers as transfer-post, signed by an account of the wallet.
+	u.r.POST("/wallet/transfer-post", func(c *gin.Context) {
+		if u.w == nil {
+			c.String(http.StatusNotFound, "no wallet.")
+			return
+		}
+		payments := make([]wallet.Payment, 0)
+		for i := 0; ; i++ {
+			to := c.Request.PostFormValue(fmt.Sprintf("receiver-addr-%d", i))
+			if to == "" {
+				break
+			}
+			amount, err := strconv.ParseUint(
+				c.Request.PostFormValue(fmt.Sprintf("receiver-amount-%d", i)), 10, 64)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			payments = append(payments, wallet.Payment{To: to, Amount: amount})
+		}
+		var fee uint64
+		if value := c.Request.PostFormValue("fee"); value != "" {
+			var err error
+			if fee, err = strconv.ParseUint(value, 10, 64); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		tr, err := u.w.Send(c.Request.PostFormValue("from"), payments, fee)
+		if err != nil {
+			infoLogger.Error("wallet transfer-post error:", err)
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		infoLogger.Info("wallet transfer-post success:", tr.Hash())
+		c.String(200, "OK")
+	})
+
 	u.r.GET("/block/:hash", func(c *gin.Context) {
 		hash := c.Param("hash")
 		var blocks map[string]host.Block
@@ -353,21 +442,284 @@ func (u *UI) New(debugLogger, infoLogger *log.Logger, h host.Host) {
 		balanceInterface, _ := c.Get("balance")
 		balance := balanceInterface.(map[string]uint64)
 
+		var supply, maxSupply uint64
+		var syncProgress host.SyncProgress
+		var rejectedPeers map[string]string
+		var knownPeers []host.AddressEntry
+		var bannedPeers []host.BannedPeer
+		var connections []host.PeerPoolStats
+		var inboundPeers []string
+		if u.h != nil {
+			supply, maxSupply = u.h.GetSupply()
+			syncProgress = u.h.GetSyncProgress()
+			rejectedPeers = u.h.GetRejectedPeers()
+			knownPeers = u.h.GetKnownPeers()
+			bannedPeers = u.h.GetBannedPeers()
+			connections = u.h.GetPoolStats()
+			inboundPeers = u.h.GetInboundPeers()
+		}
+
 		reverseAny(levels)
 		c.HTML(200, "index.html", gin.H{
-			"levels":      levels,
-			"levelNumber": levelNumbers,
-			"publicKey":   c.GetString("public-key"),
-			"address":     c.GetString("address"),
-			"balance":     balance,
+			"levels":       levels,
+			"levelNumber":  levelNumbers,
+			"publicKey":    c.GetString("public-key"),
+			"address":      c.GetString("address"),
+			"balance":      balance,
+			"supply":       supply,
+			"maxSupply":    maxSupply,
+			"sync":         syncProgress,
+			"rejected":     rejectedPeers,
+			"knownPeers":   knownPeers,
+			"bannedPeers":  bannedPeers,
+			"connections":  connections,
+			"inboundPeers": inboundPeers,
 		})
 	})
 
 }
 
+// transferForm ...
+// Build the transaction of a transfer form: sender-addr-i, sender-amount-i,
+// sender-key-i, sender-nonce-i, receiver-addr-i and receiver-amount-i.
+// A multisig sender also has sender-threshold-i and its public keys in sender-keys-i.
+// Without keysRequired, the private keys are optional and the transaction may be
+// partially signed.
+func (u *UI) transferForm(c *gin.Context, keysRequired bool) (*host.HippoTransaction, error) {
+	var (
+		SenderAddresses   []string
+		senderAmounts     []uint64
+		senderKeys        []host.Key
+		senderNonces      []uint64
+		receiverAddresses []string
+		receiverAmounts   []uint64
+
+		numSenders   = 0
+		numReceivers = 0
+
+		err error
+		h   = u.h
+	)
+	c.MultipartForm()
+
+	// First, iterate through sender amounts and receiver amounts to determine size.
+	for key, value := range c.Request.PostForm {
+		switch {
+		case len(value) == 0 || value[0] == "0":
+			continue
+		case strings.Contains(key, "sender-amount-"):
+			v, err := strconv.Atoi(key[len("sender-amount-"):])
+			if err != nil {
+				u.infoLogger.Error("transfer form error:", err)
+				return nil, err
+			}
+			if v > numSenders {
+				numSenders = v
+			}
+		case strings.Contains(key, "receiver-amount-"):
+			v, err := strconv.Atoi(key[len("receiver-amount-"):])
+			if err != nil {
+				u.infoLogger.Error("transfer form error:", err)
+				return nil, err
+			}
+			if v > numReceivers {
+				numReceivers = v
+			}
+		}
+	}
+
+	numSenders++
+	numReceivers++
+
+	SenderAddresses = make([]string, 0)
+	senderAmounts = make([]uint64, 0)
+	senderKeys = make([]host.Key, 0)
+	senderNonces = make([]uint64, 0)
+
+	receiverAddresses = make([]string, 0)
+	receiverAmounts = make([]uint64, 0)
+
+	for i := 0; i < numSenders; i++ {
+		var key, value string
+		key = fmt.Sprintf("sender-addr-%d", i)
+		if value = c.Request.PostFormValue(key); value == "" {
+			return nil, errors.New("empty sender address.")
+		}
+		SenderAddresses = append(SenderAddresses, value)
+
+		key = fmt.Sprintf("sender-amount-%d", i)
+		if value = c.Request.PostFormValue(key); value == "" {
+			return nil, errors.New("empty sender amount.")
+		}
+		if amount, err := strconv.Atoi(value); err != nil {
+			u.infoLogger.Error("transfer form error:", err)
+			return nil, err
+		} else {
+			senderAmounts = append(senderAmounts, uint64(amount))
+		}
+
+		key = fmt.Sprintf("sender-key-%d", i)
+		if value = c.Request.PostFormValue(key); value == "" {
+			if keysRequired {
+				return nil, errors.New("empty sender key.")
+			}
+		} else {
+			senderKey := host.Key{}
+			if err = senderKey.LoadPrivateKeyString(value, h.GetCurve()); err != nil {
+				u.infoLogger.Error("transfer form error:", err)
+				return nil, err
+			}
+			senderKeys = append(senderKeys, senderKey)
+		}
+
+		// An empty nonce is filled with the next nonce of the sender.
+		key = fmt.Sprintf("sender-nonce-%d", i)
+		if value = c.Request.PostFormValue(key); value == "" {
+			senderNonces = append(senderNonces, h.GetNonce(SenderAddresses[i]))
+		} else if nonce, err := strconv.ParseUint(value, 10, 64); err != nil {
+			u.infoLogger.Error("transfer form error:", err)
+			return nil, err
+		} else {
+			senderNonces = append(senderNonces, nonce)
+		}
+	}
+
+	for i := 0; i < numReceivers; i++ {
+		var key, value string
+		key = fmt.Sprintf("receiver-addr-%d", i)
+		if value = c.Request.PostFormValue(key); value == "" {
+			return nil, errors.New("empty receiver address.")
+		}
+		receiverAddresses = append(receiverAddresses, value)
+
+		key = fmt.Sprintf("receiver-amount-%d", i)
+		if value = c.Request.PostFormValue(key); value == "" {
+			return nil, errors.New("empty receiver amount")
+		}
+		if amount, err := strconv.Atoi(value); err != nil {
+			u.infoLogger.Error("transfer form error:", err)
+			return nil, err
+		} else {
+			receiverAmounts = append(receiverAmounts, uint64(amount))
+		}
+	}
+
+	newTransaction := new(host.HippoTransaction)
+	newTransaction.New(h.GetHashFunction(), h.GetCurve())
+	if ok := newTransaction.SetSender(SenderAddresses, senderAmounts); !ok {
+		u.infoLogger.Error("ui: transfer form set senders failed.")
+		return nil, errors.New("set senders failed.")
+	}
+	if ok := newTransaction.SetNonces(senderNonces); !ok {
+		u.infoLogger.Error("ui: transfer form set nonces failed.")
+		return nil, errors.New("set nonces failed.")
+	}
+	if ok := newTransaction.SetReceiver(receiverAddresses, receiverAmounts); !ok {
+		u.infoLogger.Error("ui: transfer form set receivers failed.")
+		return nil, errors.New("set receivers failed.")
+	}
+	if ok := newTransaction.UpdateFee(); !ok {
+		return nil, errors.New("Wrong fee!")
+	}
+	for i, address := range SenderAddresses {
+		value := c.Request.PostFormValue(fmt.Sprintf("sender-keys-%d", i))
+		if value == "" {
+			continue
+		}
+		threshold, err := strconv.Atoi(c.Request.PostFormValue(fmt.Sprintf("sender-threshold-%d", i)))
+		if err != nil {
+			return nil, err
+		}
+		keys := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		if ok := newTransaction.SetMultisig(address, threshold, keys); !ok {
+			u.infoLogger.Error("ui: transfer form set multisig failed.")
+			return nil, fmt.Errorf("set multisig of sender %d failed.", i)
+		}
+	}
+	for _, key := range senderKeys {
+		if ok := newTransaction.Sign(key); !ok {
+			u.infoLogger.Error("ui: transfer form sign failed.")
+			return nil, errors.New("sign failed.")
+		}
+	}
+	return newTransaction, nil
+}
+
+// partialForm ...
+// Decode the partially signed transaction of the form value pst.
+func (u *UI) partialForm(c *gin.Context) (*host.PartiallySignedTransaction, error) {
+	data := c.Request.PostFormValue("pst")
+	if data == "" {
+		return nil, errors.New("empty partially signed transaction.")
+	}
+	return host.DecodePartiallySignedTransaction([]byte(data), u.h.GetHashFunction(), u.h.GetCurve())
+}
+
+// SetWallet ...
+// Enable the wallet pages. Call it before Main.
+func (u *UI) SetWallet(w wallet.Wallet) { u.w = w }
+
+// SetPassword ...
+// Ask for password with HTTP basic auth, for any user name. Call it before Main.
+func (u *UI) SetPassword(password string) { u.password = password }
+
 // Main ...
-func (u *UI) Main(port string) {
-	go u.r.Run(":" + port)
+// Serve on address:port, 127.0.0.1 if address is empty. Since the UI spends from
+// the wallet, an address other than loopback requires a password.
+func (u *UI) Main(address string, port string) error {
+	if address == "" {
+		address = "127.0.0.1"
+	}
+	if u.password == "" && !isLoopback(address) {
+		return fmt.Errorf("ui: a password is required to listen on %s", address)
+	}
+	go func() {
+		if err := u.r.Run(net.JoinHostPort(address, port)); err != nil {
+			u.infoLogger.Error("ui:", err)
+		}
+	}()
+	return nil
+}
+
+// authenticate ...
+func (u *UI) authenticate(c *gin.Context) {
+	if u.password == "" {
+		return
+	}
+	_, password, ok := c.Request.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(u.password)) != 1 {
+		c.Header("WWW-Authenticate", `Basic realm="HippoCoin"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+// checkCSRF ...
+// Refuse a POST without the token of the forms, which another site cannot read.
+func (u *UI) checkCSRF(c *gin.Context) {
+	if c.Request.Method != http.MethodPost {
+		return
+	}
+	token := c.Request.PostFormValue("csrf")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(u.csrfToken)) != 1 {
+		c.String(http.StatusForbidden, "bad csrf token.")
+		c.Abort()
+	}
+}
+
+func newCSRFToken() string {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+func isLoopback(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
 }
 
 func reverseAny(s interface{}) {
@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Contact ...
+type Contact struct {
+	Label   string `json:"label"`
+	Address string `json:"address"`
+}
+
+// AddressBook ...
+// Labelled addresses of the receivers, kept across restarts.
+// Steps:
+// 1. New(path)  An empty path keeps the book in memory.
+// 2. Load()
+// 3. Set(label, address)  Remove(label)  Lookup(label)
+// 4. Save()
+type AddressBook interface {
+	New(path string)
+	Load() error
+	Save() error
+
+	Set(label string, address string)
+	Remove(label string) bool
+	Lookup(label string) (address string, ok bool)
+	LabelOf(address string) (label string, ok bool)
+	Contacts() []Contact
+	Len() int
+}
+
+// HippoAddressBook ...
+// HippoAddressBook is thread-safe and saved as a JSON file.
+type HippoAddressBook struct {
+	lock     sync.Mutex
+	path     string
+	contacts map[string]string
+}
+
+// New ...
+func (book *HippoAddressBook) New(path string) {
+	book.path = path
+	book.contacts = make(map[string]string)
+}
+
+// Load ...
+// A missing file is an empty book.
+func (book *HippoAddressBook) Load() error {
+	if book.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(book.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var contacts []Contact
+	if err = json.Unmarshal(data, &contacts); err != nil {
+		return err
+	}
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	for _, c := range contacts {
+		if c.Label != "" && c.Address != "" {
+			book.contacts[c.Label] = c.Address
+		}
+	}
+	return nil
+}
+
+// Save ...
+func (book *HippoAddressBook) Save() error {
+	if book.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(book.Contacts(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(book.path), 0700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a crash never leaves a broken book.
+	tmp := book.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, book.path)
+}
+
+// Set ...
+// Add a contact, or replace the address of label.
+func (book *HippoAddressBook) Set(label string, address string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	book.contacts[label] = address
+}
+
+// Remove ...
+func (book *HippoAddressBook) Remove(label string) bool {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	_, has := book.contacts[label]
+	delete(book.contacts, label)
+	return has
+}
+
+// Lookup ...
+func (book *HippoAddressBook) Lookup(label string) (string, bool) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	address, has := book.contacts[label]
+	return address, has
+}
+
+// LabelOf ...
+// The first label of address in alphabetical order.
+func (book *HippoAddressBook) LabelOf(address string) (string, bool) {
+	for _, c := range book.Contacts() {
+		if c.Address == address {
+			return c.Label, true
+		}
+	}
+	return "", false
+}
+
+// Contacts ...
+// Sorted by label.
+func (book *HippoAddressBook) Contacts() []Contact {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	contacts := make([]Contact, 0, len(book.contacts))
+	for label, address := range book.contacts {
+		contacts = append(contacts, Contact{Label: label, Address: address})
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].Label < contacts[j].Label })
+	return contacts
+}
+
+// Len ...
+func (book *HippoAddressBook) Len() int {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	return len(book.contacts)
+}
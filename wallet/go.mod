@@ -0,0 +1,10 @@
+module github.com/XieGuochao/HippoCoin/wallet
+
+go 1.15
+
+replace github.com/XieGuochao/HippoCoin/host => ../host
+
+require (
+	github.com/XieGuochao/HippoCoin/host v0.0.0-00010101000000-000000000000
+	github.com/tyler-smith/go-bip39 v1.0.2
+)
@@ -0,0 +1,206 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/XieGuochao/HippoCoin/host"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Deterministic keys, in the way of BIP32 and SLIP-10 on the NIST curves:
+// - A BIP39 mnemonic gives the seed, and the seed the master key of the curve.
+// - A child key is the parent key plus the first bytes of an HMAC-SHA512
+//   of the chain code, modulo the curve order. Hardened children hash the
+//   parent private key, normal ones its compressed public key.
+// - A child that is not a valid key is derived again from 0x01 and the rest of the
+//   HMAC. On P256 this is exactly SLIP-10; P224 takes the first 28 bytes of the HMAC.
+
+// HardenedOffset ...
+const HardenedOffset uint32 = 1 << 31
+
+const (
+	// DefaultDerivationPath ...
+	// Account i of the wallet is DefaultDerivationPath/i.
+	DefaultDerivationPath = "m/44'/2020'/0'/0"
+	// DefaultGapLimit ...
+	// Scanning stops after this many unused addresses in a row.
+	DefaultGapLimit = 20
+	// DefaultMnemonicBits ...
+	// 24 words.
+	DefaultMnemonicBits = 256
+)
+
+// ErrBadMnemonic ...
+var ErrBadMnemonic = errors.New("wallet: bad mnemonic")
+
+// HDKey ...
+// An extended private key: the key and the chain code of its children.
+type HDKey struct {
+	curve     elliptic.Curve
+	key       *big.Int
+	chainCode []byte
+}
+
+// NewMnemonic ...
+// bits of entropy: 128 for 12 words to 256 for 24 words.
+func NewMnemonic(bits int) (string, error) {
+	entropy, err := bip39.NewEntropy(bits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// MnemonicToSeed ...
+func MnemonicToSeed(mnemonic string, passphrase string) ([]byte, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(normalizeMnemonic(mnemonic), passphrase)
+	if err != nil {
+		return nil, ErrBadMnemonic
+	}
+	return seed, nil
+}
+
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// curveSeedKey ...
+// The HMAC key of the master key of curve.
+func curveSeedKey(curve elliptic.Curve) ([]byte, error) {
+	switch curve {
+	case elliptic.P224():
+		return []byte("Nist224p1 seed"), nil
+	case elliptic.P256():
+		return []byte("Nist256p1 seed"), nil
+	}
+	return nil, fmt.Errorf("wallet: no key derivation on %s", curve.Params().Name)
+}
+
+// NewMasterKey ...
+func NewMasterKey(seed []byte, curve elliptic.Curve) (*HDKey, error) {
+	seedKey, err := curveSeedKey(curve)
+	if err != nil {
+		return nil, err
+	}
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, seedKey)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		if k := curveScalar(curve, sum[:32]); k != nil {
+			return &HDKey{curve: curve, key: k, chainCode: sum[32:]}, nil
+		}
+		data = sum
+	}
+}
+
+// curveScalar ...
+// The first bytes of b as a private key of curve, or nil if it is 0 or not below the order.
+func curveScalar(curve elliptic.Curve, b []byte) *big.Int {
+	size := (curve.Params().BitSize + 7) / 8
+	k := new(big.Int).SetBytes(b[:size])
+	if k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		return nil
+	}
+	return k
+}
+
+// Child ...
+// index >= HardenedOffset is a hardened child.
+func (k *HDKey) Child(index uint32) (*HDKey, error) {
+	params := k.curve.Params()
+	size := (params.BitSize + 7) / 8
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0}, k.key.FillBytes(make([]byte, size))...)
+	} else {
+		x, y := k.curve.ScalarBaseMult(k.key.FillBytes(make([]byte, size)))
+		data = elliptic.MarshalCompressed(k.curve, x, y)
+	}
+	data = append(data, ser32(index)...)
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		if tweak := curveScalar(k.curve, sum[:32]); tweak != nil {
+			child := new(big.Int).Add(tweak, k.key)
+			child.Mod(child, params.N)
+			if child.Sign() != 0 {
+				return &HDKey{curve: k.curve, key: child, chainCode: sum[32:]}, nil
+			}
+		}
+		data = append(append([]byte{1}, sum[32:]...), ser32(index)...)
+	}
+}
+
+func ser32(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+	return b
+}
+
+// ParsePath ...
+// A path like m/44'/2020'/0'/0, where ' or h marks a hardened index.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("wallet: bad path %q", path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		hardened := strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h")
+		if hardened {
+			p = p[:len(p)-1]
+		}
+		i, err := strconv.ParseUint(p, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("wallet: bad path %q", path)
+		}
+		index := uint32(i)
+		if hardened {
+			index += HardenedOffset
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// Derive ...
+func (k *HDKey) Derive(path string) (*HDKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	key := k
+	for _, i := range indexes {
+		if key, err = key.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// PrivateKey ...
+func (k *HDKey) PrivateKey() *ecdsa.PrivateKey {
+	key := &ecdsa.PrivateKey{D: new(big.Int).Set(k.key)}
+	key.Curve = k.curve
+	key.X, key.Y = k.curve.ScalarBaseMult(k.key.Bytes())
+	return key
+}
+
+// Key ...
+func (k *HDKey) Key() host.Key {
+	var key host.Key
+	key.New(k.curve)
+	key.SetKey(k.PrivateKey())
+	return key
+}
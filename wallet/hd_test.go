@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"testing"
+
+	"github.com/XieGuochao/HippoCoin/host"
+)
+
+func TestHDKey(t *testing.T) {
+	// SLIP-10 test vector 1 for nist256p1.
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed, elliptic.P256())
+	assertT(err == nil, t)
+	assertT(hex.EncodeToString(master.key.Bytes()) ==
+		"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2", t)
+	child, err := master.Derive("m/0'")
+	assertT(err == nil && hex.EncodeToString(child.key.Bytes()) ==
+		"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c", t)
+	child, err = master.Derive("m/0h/1")
+	assertT(err == nil && hex.EncodeToString(child.key.Bytes()) ==
+		"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129", t)
+
+	// P224 keys are deterministic, distinct and on the curve.
+	master224, err := NewMasterKey(seed, elliptic.P224())
+	assertT(err == nil, t)
+	a, _ := master224.Derive(DefaultDerivationPath + "/0")
+	b, _ := master224.Derive(DefaultDerivationPath + "/0")
+	c, _ := master224.Derive(DefaultDerivationPath + "/1")
+	assertT(a.key.Cmp(b.key) == 0 && a.key.Cmp(c.key) != 0, t)
+	key := a.PrivateKey()
+	assertT(key.Curve == elliptic.P224() && key.Curve.IsOnCurve(key.X, key.Y), t)
+	aKey := a.Key()
+	assertT(host.ValidAddress(aKey.ToAddress(), elliptic.P224()), t)
+
+	_, err = ParsePath("44'/0")
+	assertT(err != nil, t)
+	_, err = ParsePath("m/2147483648")
+	assertT(err != nil, t)
+	indexes, err := ParsePath("m/44'/1")
+	assertT(err == nil && len(indexes) == 2 && indexes[0] == HardenedOffset+44 && indexes[1] == 1, t)
+
+	mnemonic, err := NewMnemonic(128)
+	assertT(err == nil, t)
+	_, err = MnemonicToSeed(mnemonic, "")
+	assertT(err == nil, t)
+	_, err = MnemonicToSeed(mnemonic+" abandon", "")
+	assertT(err == ErrBadMnemonic, t)
+}
+
+func TestWalletSeed(t *testing.T) {
+	initTest(t)
+	dir := t.TempDir()
+	node := newTestNode()
+	w := new(HippoWallet)
+	assertT(w.New(dir, "secret", node) == nil, t)
+	_, err := w.DeriveAccount("a")
+	assertT(err == ErrNoSeed, t)
+
+	mnemonic, err := w.NewSeed(DefaultMnemonicBits)
+	assertT(err == nil && w.HasSeed(), t)
+	_, err = w.NewSeed(DefaultMnemonicBits)
+	assertT(err == ErrSeedExists, t)
+	first, err := w.DeriveAccount("first")
+	assertT(err == nil && first.Path == DefaultDerivationPath+"/0", t)
+	second, err := w.DeriveAccount("second")
+	assertT(err == nil && second.Path == DefaultDerivationPath+"/1", t)
+	assertT(w.RemoveAccount("first") == nil, t)
+	third, err := w.DeriveAccount("third")
+	assertT(err == nil && third.Path == DefaultDerivationPath+"/2", t)
+
+	// Derived accounts are derived again when the wallet is opened.
+	reopened := new(HippoWallet)
+	assertT(reopened.New(dir, "secret", node) == nil, t)
+	accounts := reopened.Accounts()
+	assertT(len(accounts) == 2 && accounts[0] == second && accounts[1] == third, t)
+	assertT(new(HippoWallet).New(dir, "wrong", node) != nil, t)
+
+	// Index 0 has a balance, index 2 mined the genesis block and index 5 is too far.
+	node.balance[first.Address] = 10
+	genesis := host.CreateGenesisBlock(host.Hash, elliptic.P224(), deriveTestKey(t, mnemonic, 2))
+	node.chain = []host.Block{&genesis}
+	farKey := deriveTestKey(t, mnemonic, 5)
+	node.balance[farKey.ToAddress()] = 10
+
+	restored := new(HippoWallet)
+	assertT(restored.New(t.TempDir(), "other", node) == nil, t)
+	_, err = restored.RestoreSeed("not a mnemonic", 2)
+	assertT(err == ErrBadMnemonic && !restored.HasSeed(), t)
+	found, err := restored.RestoreSeed(mnemonic, 2)
+	assertT(err == nil && len(found) == 2, t)
+	assertT(found[0].Name == "hd-0" && found[0].Address == first.Address, t)
+	assertT(found[1].Name == "hd-2" && found[1].Address == third.Address, t)
+	found, err = restored.Scan(3)
+	assertT(err == nil && len(found) == 1 && found[0].Name == "hd-5", t)
+	found, err = restored.Scan(3)
+	assertT(err == nil && len(found) == 0, t)
+}
+
+func deriveTestKey(t *testing.T, mnemonic string, index uint32) host.Key {
+	seed, err := MnemonicToSeed(mnemonic, "")
+	assertT(err == nil, t)
+	master, err := NewMasterKey(seed, elliptic.P224())
+	assertT(err == nil, t)
+	key, err := master.Derive(DefaultDerivationPath)
+	assertT(err == nil, t)
+	child, err := key.Child(index)
+	assertT(err == nil, t)
+	return child.Key()
+}
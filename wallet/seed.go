@@ -0,0 +1,291 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/XieGuochao/HippoCoin/host"
+)
+
+// The seed of the wallet is kept in dir/seed.json: the mnemonic encrypted by the
+// wallet password, and the index of each derived account. Derived accounts have
+// no keystore; they are derived again when the wallet is opened.
+
+const (
+	seedFile    = "seed.json"
+	seedVersion = 1
+)
+
+var (
+	// ErrNoSeed ...
+	ErrNoSeed = errors.New("wallet: no seed")
+	// ErrSeedExists ...
+	ErrSeedExists = errors.New("wallet: the wallet already has a seed")
+)
+
+// walletSeed ...
+type walletSeed struct {
+	Version  int               `json:"version"`
+	Curve    string            `json:"curve"`
+	Path     string            `json:"path"`
+	Accounts map[string]uint32 `json:"accounts"`
+	host.EncryptedData
+}
+
+func (s *walletSeed) additionalData() []byte {
+	return []byte(s.Curve + " " + s.Path)
+}
+
+// loadSeedUnsafe ...
+// Open dir/seed.json and derive its accounts. A missing file is a wallet without seed.
+func (w *HippoWallet) loadSeedUnsafe() error {
+	data, err := ioutil.ReadFile(filepath.Join(w.dir, seedFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	seed := new(walletSeed)
+	if err = json.Unmarshal(data, seed); err != nil {
+		return err
+	}
+	if seed.Version != seedVersion {
+		return fmt.Errorf("wallet: unsupported seed version %d", seed.Version)
+	}
+	if curve := w.node.GetCurve().Params().Name; seed.Curve != curve {
+		return fmt.Errorf("wallet: seed curve %s, expected %s", seed.Curve, curve)
+	}
+	mnemonic, err := host.DecryptData(seed.EncryptedData, seed.additionalData(), w.password)
+	if err != nil {
+		return err
+	}
+	if err = w.setSeedUnsafe(seed, string(mnemonic)); err != nil {
+		return err
+	}
+	for name, index := range seed.Accounts {
+		if err = w.addDerivedUnsafe(name, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *HippoWallet) setSeedUnsafe(seed *walletSeed, mnemonic string) error {
+	bytes, err := MnemonicToSeed(mnemonic, "")
+	if err != nil {
+		return err
+	}
+	master, err := NewMasterKey(bytes, w.node.GetCurve())
+	if err != nil {
+		return err
+	}
+	base, err := master.Derive(seed.Path)
+	if err != nil {
+		return err
+	}
+	if seed.Accounts == nil {
+		seed.Accounts = make(map[string]uint32)
+	}
+	w.seed, w.base = seed, base
+	return nil
+}
+
+// saveSeedUnsafe ...
+func (w *HippoWallet) saveSeedUnsafe() error {
+	if w.dir == "" {
+		return nil
+	}
+	return host.WriteSecretFile(filepath.Join(w.dir, seedFile), w.seed)
+}
+
+// initSeedUnsafe ...
+func (w *HippoWallet) initSeedUnsafe(mnemonic string) error {
+	if w.seed != nil {
+		return ErrSeedExists
+	}
+	mnemonic = normalizeMnemonic(mnemonic)
+	seed := &walletSeed{
+		Version: seedVersion,
+		Curve:   w.node.GetCurve().Params().Name,
+		Path:    DefaultDerivationPath,
+	}
+	data, err := host.EncryptData([]byte(mnemonic), seed.additionalData(),
+		w.password, host.DefaultScryptParams)
+	if err != nil {
+		return err
+	}
+	seed.EncryptedData = data
+	if err = w.setSeedUnsafe(seed, mnemonic); err != nil {
+		return err
+	}
+	if err = w.saveSeedUnsafe(); err != nil {
+		w.seed, w.base = nil, nil
+		return err
+	}
+	return nil
+}
+
+// derivedPath ...
+func (w *HippoWallet) derivedPath(index uint32) string {
+	return w.seed.Path + "/" + strconv.FormatUint(uint64(index), 10)
+}
+
+// addDerivedUnsafe ...
+// Add account index of the seed as name. It is not saved.
+func (w *HippoWallet) addDerivedUnsafe(name string, index uint32) error {
+	child, err := w.base.Child(index)
+	if err != nil {
+		return err
+	}
+	if !accountNamePattern.MatchString(name) {
+		return ErrBadAccountName
+	}
+	if _, has := w.accounts[name]; has {
+		return ErrAccountExists
+	}
+	key := child.Key()
+	w.accounts[name] = &walletAccount{
+		Account: Account{Name: name, Address: key.ToAddress(), Saved: true,
+			Path: w.derivedPath(index)},
+		key:   key,
+		index: index,
+	}
+	return nil
+}
+
+// HasSeed ...
+func (w *HippoWallet) HasSeed() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.seed != nil
+}
+
+// NewSeed ...
+// Create the seed of the wallet, and return its mnemonic to be written down.
+// The mnemonic is all that is needed to recover the derived accounts.
+func (w *HippoWallet) NewSeed(bits int) (string, error) {
+	mnemonic, err := NewMnemonic(bits)
+	if err != nil {
+		return "", err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err = w.initSeedUnsafe(mnemonic); err != nil {
+		return "", err
+	}
+	return mnemonic, nil
+}
+
+// RestoreSeed ...
+// Set the seed of the wallet from a mnemonic, then Scan(gapLimit) for the used accounts.
+func (w *HippoWallet) RestoreSeed(mnemonic string, gapLimit int) ([]Account, error) {
+	w.lock.Lock()
+	err := w.initSeedUnsafe(mnemonic)
+	w.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return w.Scan(gapLimit)
+}
+
+// DeriveAccount ...
+// Add the account after the last derived one.
+func (w *HippoWallet) DeriveAccount(name string) (Account, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.seed == nil {
+		return Account{}, ErrNoSeed
+	}
+	var next uint32
+	for _, index := range w.seed.Accounts {
+		if index+1 > next {
+			next = index + 1
+		}
+	}
+	return w.deriveUnsafe(name, next)
+}
+
+func (w *HippoWallet) deriveUnsafe(name string, index uint32) (Account, error) {
+	if err := w.addDerivedUnsafe(name, index); err != nil {
+		return Account{}, err
+	}
+	w.seed.Accounts[name] = index
+	if err := w.saveSeedUnsafe(); err != nil {
+		delete(w.seed.Accounts, name)
+		delete(w.accounts, name)
+		return Account{}, err
+	}
+	return w.accounts[name].Account, nil
+}
+
+// usedAddresses ...
+// The addresses with a balance or a nonce, or found on the main chain.
+func (w *HippoWallet) usedAddresses() map[string]bool {
+	used := make(map[string]bool)
+	for address, value := range w.node.GetBalance() {
+		if value > 0 {
+			used[address] = true
+		}
+	}
+	for _, b := range w.node.GetMainChain() {
+		used[b.GetMiner()] = true
+		for _, tr := range b.GetTransactions() {
+			senders, _ := tr.GetSender()
+			receivers, _ := tr.GetReceiver()
+			for _, a := range append(senders, receivers...) {
+				used[a] = true
+			}
+		}
+	}
+	return used
+}
+
+// Scan ...
+// Walk the accounts of the seed from index 0 and add the used ones that are not in
+// the wallet yet, as hd-<index>. Stop after gapLimit unused accounts in a row.
+// Return the added accounts.
+func (w *HippoWallet) Scan(gapLimit int) ([]Account, error) {
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+	used := w.usedAddresses()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.seed == nil {
+		return nil, ErrNoSeed
+	}
+	known := make(map[uint32]bool)
+	for _, index := range w.seed.Accounts {
+		known[index] = true
+	}
+
+	added := make([]Account, 0)
+	for index, gap := uint32(0), 0; gap < gapLimit && index < HardenedOffset; index++ {
+		if known[index] {
+			gap = 0
+			continue
+		}
+		child, err := w.base.Child(index)
+		if err != nil {
+			return added, err
+		}
+		key := child.Key()
+		if !used[key.ToAddress()] && w.node.GetNonce(key.ToAddress()) == 0 {
+			gap++
+			continue
+		}
+		gap = 0
+		account, err := w.deriveUnsafe("hd-"+strconv.FormatUint(uint64(index), 10), index)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, account)
+	}
+	return added, nil
+}
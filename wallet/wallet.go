@@ -0,0 +1,438 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/XieGuochao/HippoCoin/host"
+)
+
+// A wallet keeps named accounts and signs their transactions on the node,
+// so that private keys never have to be typed into the web client:
+// - Saved accounts are keystores encrypted by the wallet password in dir/accounts.
+// - Accounts derived from the seed of the wallet are only recorded in dir/seed.json.
+// - Other accounts, e.g. the host key, are only kept in memory.
+// - The address book is dir/address-book.json.
+
+const (
+	accountsDir     = "accounts"
+	addressBookFile = "address-book.json"
+	keystoreExt     = ".json"
+)
+
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	// ErrNoAccount ...
+	ErrNoAccount = errors.New("wallet: no such account")
+	// ErrAccountExists ...
+	ErrAccountExists = errors.New("wallet: account exists")
+	// ErrBadAccountName ...
+	ErrBadAccountName = errors.New("wallet: account names are 1 to 64 letters, digits, - or _")
+	// ErrBadAddress ...
+	ErrBadAddress = errors.New("wallet: bad address")
+	// ErrInsufficientFunds ...
+	ErrInsufficientFunds = errors.New("wallet: insufficient funds")
+	// ErrRejected ...
+	ErrRejected = errors.New("wallet: transaction rejected by the node")
+	// ErrNotSigner ...
+	ErrNotSigner = errors.New("wallet: the account does not sign the transaction")
+)
+
+// Node ...
+// The part of host.Host used by the wallet.
+type Node interface {
+	GetBalance() map[string]uint64
+	GetNonce(address string) uint64
+	GetHashFunction() host.HashFunction
+	GetCurve() elliptic.Curve
+	AddTransaction(tr host.Transaction) bool
+	GetMainChain() []host.Block
+}
+
+// Account ...
+// Address is the public key string used in transactions.
+// Saved is false for the accounts kept only in memory.
+// Path is the derivation path of the accounts derived from the seed.
+type Account struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Saved   bool   `json:"saved"`
+	Path    string `json:"path,omitempty"`
+}
+
+// AccountBalance ...
+// Nonce is the nonce of the next transaction of the account.
+type AccountBalance struct {
+	Account
+	Balance uint64 `json:"balance"`
+	Nonce   uint64 `json:"nonce"`
+}
+
+// Payment ...
+// To is an address or a label of the address book.
+type Payment struct {
+	To     string `json:"to"`
+	Amount uint64 `json:"amount"`
+}
+
+// Wallet ...
+// Steps:
+// 1. New(dir, password, node)  An empty dir keeps the wallet in memory.
+// 2. CreateAccount(name)  ImportAccount(name, priString)  AddKey(name, key)
+// 3. SetContact(label, address)
+// 4. Transfer(from, payments, fee) to sign, or Send(from, payments, fee) to also submit.
+// 5. SignPartial(name, p) to add a signature to a partially signed transaction.
+// With a seed, NewSeed(bits) or RestoreSeed(mnemonic, gapLimit), then DeriveAccount(name).
+type Wallet interface {
+	New(dir string, password string, node Node) error
+
+	HasSeed() bool
+	NewSeed(bits int) (mnemonic string, err error)
+	RestoreSeed(mnemonic string, gapLimit int) ([]Account, error)
+	DeriveAccount(name string) (Account, error)
+	Scan(gapLimit int) ([]Account, error)
+
+	CreateAccount(name string) (Account, error)
+	ImportAccount(name string, priString string) (Account, error)
+	AddKey(name string, key host.Key) (Account, error)
+	RemoveAccount(name string) error
+	GetAccount(name string) (Account, bool)
+	Accounts() []Account
+	Balances() []AccountBalance
+
+	AddressBook() AddressBook
+	SetContact(label string, address string) error
+	RemoveContact(label string) error
+	Resolve(to string) (address string, err error)
+
+	Transfer(from string, payments []Payment, fee uint64) (host.Transaction, error)
+	Send(from string, payments []Payment, fee uint64) (host.Transaction, error)
+	SignPartial(name string, p *host.PartiallySignedTransaction) error
+}
+
+type walletAccount struct {
+	Account
+	key   host.Key
+	index uint32
+}
+
+// HippoWallet ...
+// HippoWallet is thread-safe.
+type HippoWallet struct {
+	lock     sync.Mutex
+	dir      string
+	password string
+	node     Node
+	accounts map[string]*walletAccount
+	book     AddressBook
+	seed     *walletSeed
+	base     *HDKey
+}
+
+// New ...
+// Load the saved accounts, the seed and the address book. A saved account or a
+// seed that the password does not open is an error.
+func (w *HippoWallet) New(dir string, password string, node Node) error {
+	w.dir, w.password, w.node = dir, password, node
+	w.accounts = make(map[string]*walletAccount)
+	w.seed, w.base = nil, nil
+	w.book = new(HippoAddressBook)
+	if dir == "" {
+		w.book.New("")
+		return nil
+	}
+	w.book.New(filepath.Join(dir, addressBookFile))
+	if err := w.book.Load(); err != nil {
+		return fmt.Errorf("wallet: address book: %v", err)
+	}
+	if err := w.loadSeedUnsafe(); err != nil {
+		return fmt.Errorf("wallet: seed: %v", err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, accountsDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), keystoreExt)
+		if f.IsDir() || !strings.HasSuffix(f.Name(), keystoreExt) || !accountNamePattern.MatchString(name) {
+			continue
+		}
+		key, err := host.LoadKeystore(w.accountPath(name), password)
+		if err != nil {
+			return fmt.Errorf("wallet: account %s: %v", name, err)
+		}
+		if key.Curve != node.GetCurve() {
+			return fmt.Errorf("wallet: account %s: curve %s, expected %s", name,
+				key.Curve.Params().Name, node.GetCurve().Params().Name)
+		}
+		if _, has := w.accounts[name]; has {
+			return fmt.Errorf("wallet: account %s: %v", name, ErrAccountExists)
+		}
+		var k host.Key
+		k.New(key.Curve)
+		k.SetKey(key)
+		w.accounts[name] = &walletAccount{
+			Account: Account{Name: name, Address: k.ToAddress(), Saved: true},
+			key:     k,
+		}
+	}
+	return nil
+}
+
+func (w *HippoWallet) accountPath(name string) string {
+	return filepath.Join(w.dir, accountsDir, name+keystoreExt)
+}
+
+// addUnsafe ...
+// Add key as account name, and save it unless the wallet is in memory or save is false.
+func (w *HippoWallet) addUnsafe(name string, key host.Key, save bool) (Account, error) {
+	if !accountNamePattern.MatchString(name) {
+		return Account{}, ErrBadAccountName
+	}
+	if _, has := w.accounts[name]; has {
+		return Account{}, ErrAccountExists
+	}
+	save = save && w.dir != ""
+	if save {
+		if err := host.SaveKeystore(w.accountPath(name), key.Key(), w.password); err != nil {
+			return Account{}, err
+		}
+	}
+	account := &walletAccount{
+		Account: Account{Name: name, Address: key.ToAddress(), Saved: save},
+		key:     key,
+	}
+	w.accounts[name] = account
+	return account.Account, nil
+}
+
+// CreateAccount ...
+func (w *HippoWallet) CreateAccount(name string) (Account, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var key host.Key
+	key.New(w.node.GetCurve())
+	key.GenerateKey()
+	return w.addUnsafe(name, key, true)
+}
+
+// ImportAccount ...
+// Import a hex private key, as shown by the web client.
+func (w *HippoWallet) ImportAccount(name string, priString string) (Account, error) {
+	var key host.Key
+	key.New(w.node.GetCurve())
+	if err := key.LoadPrivateKeyString(priString, w.node.GetCurve()); err != nil {
+		return Account{}, err
+	}
+	if key.Key().Curve != w.node.GetCurve() {
+		return Account{}, errors.New("wallet: the key is not on the curve of the node")
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.addUnsafe(name, key, true)
+}
+
+// AddKey ...
+// Add an account that is kept only in memory, e.g. the host key.
+func (w *HippoWallet) AddKey(name string, key host.Key) (Account, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.addUnsafe(name, key, false)
+}
+
+// RemoveAccount ...
+// The keystore of a saved account is deleted. A derived account can be derived again.
+func (w *HippoWallet) RemoveAccount(name string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	account, has := w.accounts[name]
+	if !has {
+		return ErrNoAccount
+	}
+	if account.Path != "" {
+		delete(w.seed.Accounts, name)
+		if err := w.saveSeedUnsafe(); err != nil {
+			w.seed.Accounts[name] = account.index
+			return err
+		}
+	} else if account.Saved {
+		if err := os.Remove(w.accountPath(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(w.accounts, name)
+	return nil
+}
+
+// GetAccount ...
+func (w *HippoWallet) GetAccount(name string) (Account, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	account, has := w.accounts[name]
+	if !has {
+		return Account{}, false
+	}
+	return account.Account, true
+}
+
+// Accounts ...
+// Sorted by name.
+func (w *HippoWallet) Accounts() []Account {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	accounts := make([]Account, 0, len(w.accounts))
+	for _, a := range w.accounts {
+		accounts = append(accounts, a.Account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts
+}
+
+// Balances ...
+// The balance on the main chain and the next nonce of each account, sorted by name.
+func (w *HippoWallet) Balances() []AccountBalance {
+	accounts := w.Accounts()
+	balance := w.node.GetBalance()
+	balances := make([]AccountBalance, 0, len(accounts))
+	for _, a := range accounts {
+		balances = append(balances, AccountBalance{
+			Account: a,
+			Balance: balance[a.Address],
+			Nonce:   w.node.GetNonce(a.Address),
+		})
+	}
+	return balances
+}
+
+// AddressBook ...
+func (w *HippoWallet) AddressBook() AddressBook { return w.book }
+
+// SetContact ...
+func (w *HippoWallet) SetContact(label string, address string) error {
+	if strings.TrimSpace(label) == "" {
+		return errors.New("wallet: empty label")
+	}
+	if !host.ValidAddress(address, w.node.GetCurve()) {
+		return ErrBadAddress
+	}
+	w.book.Set(label, address)
+	return w.book.Save()
+}
+
+// RemoveContact ...
+func (w *HippoWallet) RemoveContact(label string) error {
+	if !w.book.Remove(label) {
+		return fmt.Errorf("wallet: no contact %s", label)
+	}
+	return w.book.Save()
+}
+
+// Resolve ...
+// to is a label of the address book, the name of an account, or an address.
+func (w *HippoWallet) Resolve(to string) (string, error) {
+	if address, ok := w.book.Lookup(to); ok {
+		return address, nil
+	}
+	if account, ok := w.GetAccount(to); ok {
+		return account.Address, nil
+	}
+	if host.ValidAddress(to, w.node.GetCurve()) {
+		return to, nil
+	}
+	return "", fmt.Errorf("%v: %s", ErrBadAddress, to)
+}
+
+// Transfer ...
+// Build and sign a transaction paying payments and fee from the account from,
+// with its next nonce.
+func (w *HippoWallet) Transfer(from string, payments []Payment, fee uint64) (host.Transaction, error) {
+	w.lock.Lock()
+	account, has := w.accounts[from]
+	w.lock.Unlock()
+	if !has {
+		return nil, ErrNoAccount
+	}
+	if len(payments) == 0 {
+		return nil, errors.New("wallet: no payment")
+	}
+
+	var (
+		receivers = make([]string, 0, len(payments))
+		amounts   = make([]uint64, 0, len(payments))
+		total     = fee
+	)
+	for _, p := range payments {
+		address, err := w.Resolve(p.To)
+		if err != nil {
+			return nil, err
+		}
+		if p.Amount == 0 {
+			return nil, errors.New("wallet: zero payment to " + p.To)
+		}
+		if total+p.Amount < total {
+			return nil, errors.New("wallet: amount overflow")
+		}
+		total += p.Amount
+		receivers = append(receivers, address)
+		amounts = append(amounts, p.Amount)
+	}
+	if w.node.GetBalance()[account.Address] < total {
+		return nil, ErrInsufficientFunds
+	}
+
+	tr := new(host.HippoTransaction)
+	tr.New(w.node.GetHashFunction(), w.node.GetCurve())
+	switch {
+	case !tr.SetSender([]string{account.Address}, []uint64{total}):
+		return nil, errors.New("wallet: set senders failed")
+	case !tr.SetNonces([]uint64{w.node.GetNonce(account.Address)}):
+		return nil, errors.New("wallet: set nonces failed")
+	case !tr.SetReceiver(receivers, amounts):
+		return nil, errors.New("wallet: set receivers failed")
+	case !tr.UpdateFee():
+		return nil, errors.New("wallet: wrong fee")
+	case !tr.Sign(account.key):
+		return nil, errors.New("wallet: sign failed")
+	}
+	return tr, nil
+}
+
+// Send ...
+// Transfer, then submit the transaction to the node.
+func (w *HippoWallet) Send(from string, payments []Payment, fee uint64) (host.Transaction, error) {
+	tr, err := w.Transfer(from, payments, fee)
+	if err != nil {
+		return nil, err
+	}
+	if !w.node.AddTransaction(tr) {
+		return nil, ErrRejected
+	}
+	return tr, nil
+}
+
+// SignPartial ...
+// Sign p by the account name, as a sender or a key of a multisig sender.
+func (w *HippoWallet) SignPartial(name string, p *host.PartiallySignedTransaction) error {
+	w.lock.Lock()
+	account, has := w.accounts[name]
+	w.lock.Unlock()
+	if !has {
+		return ErrNoAccount
+	}
+	if !p.Sign(account.key) {
+		return ErrNotSigner
+	}
+	return nil
+}
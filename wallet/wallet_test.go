@@ -0,0 +1,159 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"path/filepath"
+	"testing"
+
+	"github.com/XieGuochao/HippoCoin/host"
+)
+
+func assertT(b bool, t *testing.T) {
+	t.Helper()
+	if !b {
+		t.Fatal("assert failed")
+	}
+}
+
+// testNode ...
+type testNode struct {
+	balance      map[string]uint64
+	nonces       map[string]uint64
+	transactions []host.Transaction
+	chain        []host.Block
+}
+
+func newTestNode() *testNode {
+	return &testNode{balance: make(map[string]uint64), nonces: make(map[string]uint64)}
+}
+
+func (n *testNode) GetBalance() map[string]uint64      { return n.balance }
+func (n *testNode) GetNonce(address string) uint64     { return n.nonces[address] }
+func (n *testNode) GetHashFunction() host.HashFunction { return host.Hash }
+func (n *testNode) GetCurve() elliptic.Curve           { return elliptic.P224() }
+func (n *testNode) GetMainChain() []host.Block         { return n.chain }
+func (n *testNode) AddTransaction(tr host.Transaction) bool {
+	n.transactions = append(n.transactions, tr)
+	return true
+}
+
+func initTest(t *testing.T) {
+	new(host.HippoHost).InitLogger(false)
+	params := host.DefaultScryptParams
+	host.DefaultScryptParams = host.ScryptParams{N: 1 << 10, R: 8, P: 1}
+	t.Cleanup(func() { host.DefaultScryptParams = params })
+}
+
+func TestWalletAccounts(t *testing.T) {
+	initTest(t)
+	dir := t.TempDir()
+	node := newTestNode()
+	w := new(HippoWallet)
+	assertT(w.New(dir, "secret", node) == nil, t)
+
+	alice, err := w.CreateAccount("alice")
+	assertT(err == nil && alice.Saved, t)
+	_, err = w.CreateAccount("alice")
+	assertT(err == ErrAccountExists, t)
+	_, err = w.CreateAccount("../bob")
+	assertT(err == ErrBadAccountName, t)
+
+	imported := host.GenerateKey(elliptic.P224())
+	bob, err := w.ImportAccount("bob", host.KeyToHexString(imported))
+	assertT(err == nil && bob.Saved, t)
+	var key host.Key
+	key.New(elliptic.P224())
+	key.GenerateKey()
+	mem, err := w.AddKey("host", key)
+	assertT(err == nil && !mem.Saved && mem.Address == key.ToAddress(), t)
+
+	node.balance[alice.Address] = 50
+	node.nonces[alice.Address] = 3
+	balances := w.Balances()
+	assertT(len(balances) == 3 && balances[0].Name == "alice", t)
+	assertT(balances[0].Balance == 50 && balances[0].Nonce == 3, t)
+
+	// Saved accounts are loaded again, the ones in memory are not.
+	reopened := new(HippoWallet)
+	assertT(reopened.New(dir, "secret", node) == nil, t)
+	accounts := reopened.Accounts()
+	assertT(len(accounts) == 2 && accounts[0] == alice && accounts[1] == bob, t)
+	assertT(new(HippoWallet).New(dir, "wrong", node) != nil, t)
+
+	assertT(reopened.RemoveAccount("bob") == nil, t)
+	assertT(reopened.RemoveAccount("bob") == ErrNoAccount, t)
+	assertT(new(HippoWallet).New(dir, "secret", node) == nil, t)
+	_, has := reopened.GetAccount("bob")
+	assertT(!has, t)
+}
+
+func TestWalletTransfer(t *testing.T) {
+	initTest(t)
+	dir := t.TempDir()
+	node := newTestNode()
+	w := new(HippoWallet)
+	assertT(w.New(dir, "secret", node) == nil, t)
+	alice, _ := w.CreateAccount("alice")
+	receiver := host.GenerateKey(elliptic.P224())
+	var receiverKey host.Key
+	receiverKey.New(elliptic.P224())
+	receiverKey.SetKey(receiver)
+
+	assertT(w.SetContact("carol", "not an address") == ErrBadAddress, t)
+	assertT(w.SetContact("carol", receiverKey.ToAddress()) == nil, t)
+	book := new(HippoAddressBook)
+	book.New(filepath.Join(dir, addressBookFile))
+	assertT(book.Load() == nil, t)
+	address, ok := book.Lookup("carol")
+	assertT(ok && address == receiverKey.ToAddress(), t)
+	label, ok := book.LabelOf(address)
+	assertT(ok && label == "carol", t)
+
+	payments := []Payment{{To: "carol", Amount: 30}}
+	_, err := w.Transfer("alice", payments, 5)
+	assertT(err == ErrInsufficientFunds, t)
+	node.balance[alice.Address] = 100
+	node.nonces[alice.Address] = 7
+	_, err = w.Transfer("nobody", payments, 5)
+	assertT(err == ErrNoAccount, t)
+	_, err = w.Transfer("alice", []Payment{{To: "dave", Amount: 1}}, 0)
+	assertT(err != nil, t)
+
+	tr, err := w.Send("alice", payments, 5)
+	assertT(err == nil && len(node.transactions) == 1, t)
+	senders, senderAmounts := tr.GetSender()
+	receivers, receiverAmounts := tr.GetReceiver()
+	assertT(len(senders) == 1 && senders[0] == alice.Address && senderAmounts[0] == 35, t)
+	assertT(receivers[0] == receiverKey.ToAddress() && receiverAmounts[0] == 30, t)
+	assertT(tr.GetFee() == 5 && tr.GetNonces()[0] == 7, t)
+	assertT(tr.CheckSignatures(), t)
+
+	assertT(w.RemoveContact("carol") == nil && w.RemoveContact("carol") != nil, t)
+}
+
+func TestWalletSignPartial(t *testing.T) {
+	initTest(t)
+	node := newTestNode()
+	alice, bob := new(HippoWallet), new(HippoWallet)
+	assertT(alice.New("", "", node) == nil && bob.New("", "", node) == nil, t)
+	a, _ := alice.CreateAccount("alice")
+	b, _ := bob.CreateAccount("bob")
+	other, _ := bob.CreateAccount("other")
+	shared, err := host.MultisigAddress(2, []string{a.Address, b.Address}, elliptic.P224())
+	assertT(err == nil && alice.SetContact("shared", shared) == nil, t)
+
+	tr := new(host.HippoTransaction)
+	tr.New(node.GetHashFunction(), node.GetCurve())
+	tr.SetSender([]string{shared}, []uint64{10})
+	tr.SetReceiver([]string{other.Address}, []uint64{10})
+	assertT(tr.SetMultisig(shared, 2, []string{b.Address, a.Address}), t)
+	pst := host.NewPartiallySignedTransaction(tr)
+
+	// Each co-owner signs on its own machine.
+	assertT(alice.SignPartial("nobody", pst) == ErrNoAccount, t)
+	assertT(alice.SignPartial("alice", pst) == nil && !pst.Complete(), t)
+	received, err := host.DecodePartiallySignedTransaction(pst.Encode(), node.GetHashFunction(), node.GetCurve())
+	assertT(err == nil, t)
+	assertT(bob.SignPartial("other", received) == ErrNotSigner, t)
+	assertT(bob.SignPartial("bob", received) == nil && received.Complete(), t)
+}